@@ -0,0 +1,634 @@
+package mvb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	alarmsFlag       = ""
+	alarmLogFlag     = ""
+	alarmWebhookFlag = ""
+	alarmExecFlag    = ""
+)
+
+func initAlarmFlags() {
+	flag.StringVar(&alarmsFlag, "alarms", alarmsFlag, "evaluate the alarm rules in this file, one per line, e.g. 'threshold current var(\"motor current\") as uint16 > 300 hysteresis 20'")
+	flag.StringVar(&alarmLogFlag, "alarm-log", alarmLogFlag, "append the alarm notifications to this file, as JSON lines")
+	flag.StringVar(&alarmWebhookFlag, "alarm-webhook", alarmWebhookFlag, "POST the alarm notifications to this URL, as JSON")
+	flag.StringVar(&alarmExecFlag, "alarm-exec", alarmExecFlag, "run this shell command for each alarm notification, with the notification as JSON on stdin and in MVB_ALARM_* environment variables")
+}
+
+const (
+	alarmCheckInterval  = 100 * time.Millisecond
+	alarmWebhookTimeout = 10 * time.Second
+	alarmExecTimeout    = 30 * time.Second
+	alarmQueueSize      = 100
+)
+
+// AlarmRule is a condition on the decoded variables that raises an alarm,
+// of one of these kinds:
+//
+//	condition  while a bool expression holds
+//	threshold  while a number expression compares with a limit, e.g. > 300;
+//	           with hysteresis H, it clears only once the value is H past
+//	           the limit
+//	change     whenever the value of an expression changes, until it is
+//	           acknowledged
+//	stale      while none of the ports of an expression has been received
+//	           for the given timeout
+//
+// With debounce D, a condition or threshold alarm is raised or cleared only
+// once its condition has held or not for D.
+type AlarmRule struct {
+	Kind string
+	Name string
+
+	src   string
+	typ   exprType
+	eval  func(env *exprEnv) exprValue
+	ports []uint16
+
+	op         string
+	limit      float64
+	hysteresis float64
+	debounce   time.Duration
+	timeout    time.Duration
+}
+
+func (r *AlarmRule) String() string {
+	return r.src
+}
+
+var (
+	alarmRuleRE  = regexp.MustCompile(`^(\S+)\s+(\S+)\s+(.*?)((?:\s+(?:hysteresis|debounce|timeout)\s+\S+)*)\s*$`)
+	alarmLimitRE = regexp.MustCompile(`^(.*?)\s*(>=|<=|>|<)\s*(\S+)$`)
+)
+
+// ParseAlarmRules reads one rule per line: the kind, a name, an expression,
+// as CompileExpr, and the options of the kind, e.g.
+//
+//	condition mitsubishi var("alarma mitsubishi M3")[0:2] as uint16 != 0 debounce 500ms
+//	threshold current var("motor current") as uint16 > 300 hysteresis 20 debounce 2s
+//	change doors port(0x025)
+//	stale speed port(0x014) timeout 2s
+//
+// var() refers to the variables in vars. Empty lines and lines starting with
+// # are ignored.
+func ParseAlarmRules(r io.Reader, vars []RecorderPortSpec) ([]*AlarmRule, error) {
+	var rules []*AlarmRule
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule, err := parseAlarmRule(text, vars)
+		if err == nil && names[rule.Name] {
+			err = fmt.Errorf("duplicate alarm %q", rule.Name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		names[rule.Name] = true
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func parseAlarmRule(text string, vars []RecorderPortSpec) (*AlarmRule, error) {
+	m := alarmRuleRE.FindStringSubmatch(text)
+	if m == nil {
+		return nil, fmt.Errorf("want kind, name and expression")
+	}
+	r := &AlarmRule{Kind: m[1], Name: m[2], src: text}
+	src := m[3]
+	if r.Kind == "threshold" {
+		lm := alarmLimitRE.FindStringSubmatch(src)
+		if lm == nil {
+			return nil, fmt.Errorf("want the expression compared with a limit, e.g. > 300")
+		}
+		src, r.op = lm[1], lm[2]
+		limit, err := parseAlarmNumber(lm[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit %q", lm[3])
+		}
+		r.limit = limit
+	}
+	p, n, err := compileExpr(src, vars)
+	if err != nil {
+		return nil, err
+	}
+	r.typ, r.eval, r.ports = n.typ, n.eval, p.ports
+
+	switch r.Kind {
+	case "condition":
+		if r.typ != typeBool {
+			return nil, fmt.Errorf("the condition is %s, not a bool", r.typ.withArticle())
+		}
+	case "threshold":
+		if r.typ != typeNumber {
+			return nil, fmt.Errorf("the threshold needs a number, got %s", r.typ.withArticle())
+		}
+	case "change":
+	case "stale":
+		if len(r.ports) == 0 {
+			return nil, fmt.Errorf("the expression refers to no port")
+		}
+	default:
+		return nil, fmt.Errorf("invalid kind %q, expected condition, threshold, change or stale", r.Kind)
+	}
+
+	options := strings.Fields(m[4])
+	for i := 0; i+1 < len(options); i += 2 {
+		name, value := options[i], options[i+1]
+		switch {
+		case name == "hysteresis" && r.Kind == "threshold":
+			h, err := parseAlarmNumber(value)
+			if err != nil || h < 0 {
+				return nil, fmt.Errorf("invalid hysteresis %q", value)
+			}
+			r.hysteresis = h
+		case name == "debounce" && (r.Kind == "condition" || r.Kind == "threshold"):
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid debounce %q", value)
+			}
+			r.debounce = d
+		case name == "timeout" && r.Kind == "stale":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q", value)
+			}
+			r.timeout = d
+		default:
+			return nil, fmt.Errorf("%s doesn't apply to %s alarms", name, r.Kind)
+		}
+	}
+	if r.Kind == "stale" && r.timeout == 0 {
+		return nil, fmt.Errorf("stale alarms need a timeout")
+	}
+	return r, nil
+}
+
+func parseAlarmNumber(s string) (float64, error) {
+	if n, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return float64(n), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// exceeds reports whether x is past the limit, taking the hysteresis into
+// account if the alarm is active.
+func (r *AlarmRule) exceeds(x float64, active bool) bool {
+	limit := r.limit
+	if active {
+		if r.op[0] == '>' {
+			limit -= r.hysteresis
+		} else {
+			limit += r.hysteresis
+		}
+	}
+	switch r.op {
+	case ">":
+		return x > limit
+	case ">=":
+		return x >= limit
+	case "<":
+		return x < limit
+	}
+	return x <= limit
+}
+
+// AlarmNotification is sent to the sinks when an alarm is raised, cleared or
+// acknowledged.
+type AlarmNotification struct {
+	Time  time.Time `json:"time"`
+	Name  string    `json:"name"`
+	Kind  string    `json:"kind"`
+	State string    `json:"state"`
+	Value string    `json:"value"`
+	Rule  string    `json:"rule"`
+}
+
+// AlarmSink delivers alarm notifications. Notify must not block.
+type AlarmSink interface {
+	Notify(n *AlarmNotification)
+	Close()
+}
+
+// AlarmStatus is an alarm that is active or not yet acknowledged.
+type AlarmStatus struct {
+	Name   string    `json:"name"`
+	Kind   string    `json:"kind"`
+	Rule   string    `json:"rule"`
+	Active bool      `json:"active"`
+	Acked  bool      `json:"acked"`
+	Since  time.Time `json:"since"`
+	Value  string    `json:"value"`
+}
+
+// State returns ACTIVE, ACKED for an acknowledged active alarm, or CLEARED
+// for an alarm that cleared before it was acknowledged.
+func (s *AlarmStatus) State() string {
+	switch {
+	case s.Active && s.Acked:
+		return "ACKED"
+	case s.Active:
+		return "ACTIVE"
+	}
+	return "CLEARED"
+}
+
+// Alarms evaluates the alarm rules over the decoded events, and keeps the
+// list of alarms, which is safe to read and acknowledge from other
+// goroutines.
+type Alarms struct {
+	sinks []AlarmSink
+	stats Stats
+	// when each port was last received, for stale rules
+	seen  map[uint16]time.Time
+	start time.Time
+
+	mu     sync.Mutex
+	alarms []*alarm
+}
+
+type alarm struct {
+	*AlarmRule
+	active bool
+	acked  bool
+	since  time.Time
+	value  string
+
+	// since when the condition differs from active, for debounce
+	pending time.Time
+	// previous value, for change rules
+	last exprValue
+}
+
+// NewAlarms loads the rules given with -alarms, whose var() refers to the
+// watched ports, with the sinks given with -alarm-log, -alarm-webhook and
+// -alarm-exec. It returns nil if -alarms was not set.
+func NewAlarms(ports []RecorderPortSpec) (*Alarms, error) {
+	if alarmsFlag == "" {
+		if alarmLogFlag != "" || alarmWebhookFlag != "" || alarmExecFlag != "" {
+			return nil, fmt.Errorf("the alarm sinks need -alarms")
+		}
+		return nil, nil
+	}
+	fp, err := os.Open(alarmsFlag)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	rules, err := ParseAlarmRules(fp, ports)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", alarmsFlag, err)
+	}
+
+	var sinks []AlarmSink
+	if alarmLogFlag != "" {
+		sink, err := NewAlarmLogSink(alarmLogFlag)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if alarmWebhookFlag != "" {
+		sink, err := NewAlarmWebhookSink(alarmWebhookFlag)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if alarmExecFlag != "" {
+		sinks = append(sinks, NewAlarmExecSink(alarmExecFlag))
+	}
+	return newAlarms(rules, sinks, time.Now()), nil
+}
+
+func newAlarms(rules []*AlarmRule, sinks []AlarmSink, start time.Time) *Alarms {
+	a := &Alarms{
+		sinks: sinks,
+		stats: NewStats(),
+		seen:  make(map[uint16]time.Time),
+		start: start,
+	}
+	for _, r := range rules {
+		a.alarms = append(a.alarms, &alarm{AlarmRule: r, acked: true})
+	}
+	return a
+}
+
+// List returns the alarms that are active or not yet acknowledged, in the
+// order of the rules.
+func (a *Alarms) List() []AlarmStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var list []AlarmStatus
+	for _, al := range a.alarms {
+		if al.active || !al.acked {
+			list = append(list, AlarmStatus{
+				Name:   al.Name,
+				Kind:   al.Kind,
+				Rule:   al.src,
+				Active: al.active,
+				Acked:  al.acked,
+				Since:  al.since,
+				Value:  al.value,
+			})
+		}
+	}
+	return list
+}
+
+// Ack acknowledges the alarm with the given name, or all of them if name is
+// empty, and returns how many were acknowledged. Acknowledging a change alarm
+// clears it.
+func (a *Alarms) Ack(name string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	found, acked := false, 0
+	now := time.Now()
+	for _, al := range a.alarms {
+		if name != "" && al.Name != name {
+			continue
+		}
+		found = true
+		if al.acked {
+			continue
+		}
+		al.acked = true
+		if al.Kind == "change" {
+			al.active = false
+		}
+		a.notify(al, "acknowledged", now)
+		acked++
+	}
+	switch {
+	case !found:
+		return 0, fmt.Errorf("unknown alarm %q", name)
+	case acked == 0:
+		return 0, fmt.Errorf("no alarms to acknowledge")
+	}
+	return acked, nil
+}
+
+func (a *Alarms) Loop(mvbEvents chan Event) {
+	defer func() {
+		for _, sink := range a.sinks {
+			sink.Close()
+		}
+	}()
+
+	secondsTicker := time.Tick(1 * time.Second)
+	checkTicker := time.Tick(alarmCheckInterval)
+	for {
+		select {
+		case <-secondsTicker:
+			a.stats.Tick()
+		case now := <-checkTicker:
+			// debounce and stale rules change with time alone
+			a.update(nil, now)
+		case ev, ok := <-mvbEvents:
+			if !ok {
+				return
+			}
+			a.update(ev, time.Now())
+		}
+	}
+}
+
+// update counts ev, which may be nil, and evaluates the rules at now.
+func (a *Alarms) update(ev Event, now time.Time) {
+	switch ev := ev.(type) {
+	case *Telegram:
+		a.stats.CountTelegram(ev)
+		if ev.Slave != nil {
+			a.seen[ev.Master.Address] = now
+		}
+	case Error:
+		a.stats.CountError(ev)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	env := &exprEnv{ev, &a.stats}
+	for _, al := range a.alarms {
+		v := al.eval(env)
+		if v.ok {
+			al.value = v.format(al.typ)
+		}
+		var cond bool
+		switch al.Kind {
+		case "change":
+			if v.ok && al.last.ok && !v.equal(al.last, al.typ) {
+				a.set(al, true, now)
+			}
+			if v.ok {
+				al.last = v
+			}
+			continue
+		case "condition":
+			cond = v.ok && v.b
+		case "threshold":
+			if !v.ok {
+				continue
+			}
+			cond = al.exceeds(v.num, al.active)
+		case "stale":
+			cond = a.stale(al.AlarmRule, now)
+		}
+		a.debounce(al, cond, now)
+	}
+}
+
+func (a *Alarms) stale(r *AlarmRule, now time.Time) bool {
+	last := a.start
+	for _, port := range r.ports {
+		if t := a.seen[port]; t.After(last) {
+			last = t
+		}
+	}
+	return now.Sub(last) >= r.timeout
+}
+
+// debounce raises or clears al once cond has differed from its state for
+// the debounce time of the rule.
+func (a *Alarms) debounce(al *alarm, cond bool, now time.Time) {
+	if cond == al.active {
+		al.pending = time.Time{}
+		return
+	}
+	if al.pending.IsZero() {
+		al.pending = now
+	}
+	if now.Sub(al.pending) < al.debounce {
+		return
+	}
+	al.pending = time.Time{}
+	a.set(al, cond, now)
+}
+
+func (a *Alarms) set(al *alarm, active bool, now time.Time) {
+	al.active = active
+	al.since = now
+	state := "cleared"
+	if active {
+		al.acked = false
+		state = "active"
+	}
+	a.notify(al, state, now)
+}
+
+func (a *Alarms) notify(al *alarm, state string, now time.Time) {
+	logEvent("alarm", "name", al.Name, "state", state, "value", al.value)
+	n := &AlarmNotification{
+		Time:  now,
+		Name:  al.Name,
+		Kind:  al.Kind,
+		State: state,
+		Value: al.value,
+		Rule:  al.src,
+	}
+	for _, sink := range a.sinks {
+		sink.Notify(n)
+	}
+}
+
+// AlarmLogSink appends the notifications to a file, as JSON lines.
+type AlarmLogSink struct {
+	fp  *os.File
+	enc *json.Encoder
+}
+
+func NewAlarmLogSink(path string) (*AlarmLogSink, error) {
+	fp, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &AlarmLogSink{fp: fp, enc: json.NewEncoder(fp)}, nil
+}
+
+func (s *AlarmLogSink) Notify(n *AlarmNotification) {
+	if err := s.enc.Encode(n); err != nil {
+		logEvent("alarm_sink_error", "sink", "log", "name", n.Name, "err", err)
+	}
+}
+
+func (s *AlarmLogSink) Close() {
+	s.fp.Close()
+}
+
+// queuedSink delivers the notifications in order in the background, dropping
+// them while too many are pending.
+type queuedSink struct {
+	name    string
+	deliver func(n *AlarmNotification) error
+	queue   chan *AlarmNotification
+	done    chan struct{}
+}
+
+func newQueuedSink(name string, deliver func(n *AlarmNotification) error) *queuedSink {
+	s := &queuedSink{
+		name:    name,
+		deliver: deliver,
+		queue:   make(chan *AlarmNotification, alarmQueueSize),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		for n := range s.queue {
+			if err := s.deliver(n); err != nil {
+				logEvent("alarm_sink_error", "sink", s.name, "name", n.Name, "err", err)
+			}
+		}
+	}()
+	return s
+}
+
+func (s *queuedSink) Notify(n *AlarmNotification) {
+	select {
+	case s.queue <- n:
+	default:
+		logEvent("alarm_sink_dropped", "sink", s.name, "name", n.Name, "state", n.State)
+	}
+}
+
+// Close waits for the pending notifications to be delivered.
+func (s *queuedSink) Close() {
+	close(s.queue)
+	<-s.done
+}
+
+// NewAlarmWebhookSink POSTs each notification to url, as JSON.
+func NewAlarmWebhookSink(rawURL string) (AlarmSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid webhook URL %q", rawURL)
+	}
+	client := &http.Client{Timeout: alarmWebhookTimeout}
+	return newQueuedSink("webhook", func(n *AlarmNotification) error {
+		body, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Post(rawURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("%s: %s", rawURL, resp.Status)
+		}
+		return nil
+	}), nil
+}
+
+// NewAlarmExecSink runs a shell command for each notification, with the
+// notification as JSON on stdin, and its fields in the environment variables
+// MVB_ALARM, MVB_ALARM_STATE, MVB_ALARM_VALUE and MVB_ALARM_TIME.
+func NewAlarmExecSink(command string) AlarmSink {
+	return newQueuedSink("exec", func(n *AlarmNotification) error {
+		body, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), alarmExecTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Stdin = bytes.NewReader(body)
+		cmd.Env = append(os.Environ(),
+			"MVB_ALARM="+n.Name,
+			"MVB_ALARM_STATE="+n.State,
+			"MVB_ALARM_VALUE="+n.Value,
+			"MVB_ALARM_TIME="+n.Time.Format(time.RFC3339),
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,165 @@
+package mvb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAlarmRules(t *testing.T) {
+	vars := []RecorderPortSpec{{0x014, 0, 2, "motor current"}}
+	rules, err := ParseAlarmRules(strings.NewReader(`
+# comment
+threshold current var("motor current") as uint16 >= 0x100 hysteresis 20 debounce 2s
+condition mitsubishi port(0x030) as uint16 != 0
+change doors port(0x025)
+stale speed port(0x014) timeout 2s
+`), vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("got %d rules", len(rules))
+	}
+	r := rules[0]
+	if r.Kind != "threshold" || r.Name != "current" || r.op != ">=" || r.limit != 256 ||
+		r.hysteresis != 20 || r.debounce != 2*time.Second {
+		t.Errorf("got %+v", r)
+	}
+
+	for rule, want := range map[string]string{
+		"threshold x port(0x014) as uint16":              "compared with a limit",
+		"threshold x port(0x014) > 3":                    "the threshold needs a number, got bytes",
+		"condition x port(0x014) as uint16":              "the condition is a number, not a bool",
+		"condition x true hysteresis 1":                  "hysteresis doesn't apply to condition alarms",
+		"change x port(0x014) debounce 1s":               "debounce doesn't apply to change alarms",
+		"stale x port(0x014)":                            "stale alarms need a timeout",
+		"stale x 1 + 1 timeout 1s":                       "the expression refers to no port",
+		"threshold x port(0x014) as uint16 > 1 debounce": "compared with a limit",
+		"alarm x true":                                   `invalid kind "alarm"`,
+		"condition x":                                    "want kind, name and expression",
+		"condition x true\ncondition x false":            `line 2: duplicate alarm "x"`,
+		"condition x var(\"speed\") == port(1)":          `unknown variable "speed"`,
+	} {
+		_, err := ParseAlarmRules(strings.NewReader(rule), vars)
+		if err == nil {
+			t.Errorf("%s: no error", rule)
+			continue
+		}
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %q, want %q", rule, err, want)
+		}
+	}
+}
+
+// testSink records the notifications as "name state value".
+type testSink []string
+
+func (s *testSink) Notify(n *AlarmNotification) {
+	*s = append(*s, fmt.Sprintf("%s %s %s", n.Name, n.State, n.Value))
+}
+
+func (s *testSink) Close() {}
+
+func TestAlarms(t *testing.T) {
+	rules, err := ParseAlarmRules(strings.NewReader(`
+threshold current port(0x014) as uint16 > 300 hysteresis 20 debounce 1s
+change doors port(0x025)
+stale speed port(0x020) timeout 2s
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sink testSink
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	a := newAlarms(rules, []AlarmSink{&sink}, start)
+	at := func(d time.Duration, ev Event, want ...string) {
+		t.Helper()
+		sink = nil
+		a.update(ev, start.Add(d))
+		if strings.Join(sink, ", ") != strings.Join(want, ", ") {
+			t.Errorf("at %v: got %q, want %q", d, sink, want)
+		}
+	}
+
+	at(0, testTelegram(1, 0x014, "012c"))
+	at(0, testTelegram(2, 0x025, "00"))
+	at(0, testTelegram(3, 0x020, "00"))
+	// debounced: a spike is ignored, the alarm is raised after 1s
+	at(100*time.Millisecond, testTelegram(4, 0x014, "0140"))
+	at(200*time.Millisecond, testTelegram(5, 0x014, "012c"))
+	at(300*time.Millisecond, testTelegram(6, 0x014, "0140"))
+	at(1200*time.Millisecond, nil)
+	at(1300*time.Millisecond, nil, "current active 320")
+	// hysteresis: still active until the value falls to 280
+	at(1400*time.Millisecond, testTelegram(7, 0x014, "0122"))
+	at(1900*time.Millisecond, testTelegram(8, 0x020, "01"))
+	at(2500*time.Millisecond, nil)
+	at(2600*time.Millisecond, testTelegram(9, 0x014, "0118"))
+	at(3600*time.Millisecond, nil, "current cleared 280")
+
+	// stale two seconds after port 0x020 was last received
+	at(3800*time.Millisecond, nil)
+	at(3900*time.Millisecond, nil, "speed active 01")
+	at(4*time.Second, testTelegram(10, 0x025, "01"), "doors active 01")
+
+	list := a.List()
+	var states []string
+	for _, s := range list {
+		states = append(states, s.Name+" "+s.State())
+	}
+	if got, want := strings.Join(states, ", "), "current CLEARED, doors ACTIVE, speed ACTIVE"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	sink = nil
+	if n, err := a.Ack("doors"); n != 1 || err != nil {
+		t.Errorf("got %d, %v", n, err)
+	}
+	if n, err := a.Ack(""); n != 2 || err != nil {
+		t.Errorf("got %d, %v", n, err)
+	}
+	if want := "doors acknowledged 01, current acknowledged 280, speed acknowledged 01"; strings.Join(sink, ", ") != want {
+		t.Errorf("got %q, want %q", sink, want)
+	}
+	// the change alarm cleared when acknowledged, the stale one is active
+	if list := a.List(); len(list) != 1 || list[0].Name != "speed" || list[0].State() != "ACKED" {
+		t.Errorf("got %+v", list)
+	}
+	if _, err := a.Ack(""); err == nil {
+		t.Errorf("no error acknowledging nothing")
+	}
+	if _, err := a.Ack("brake"); err == nil {
+		t.Errorf("no error acknowledging an unknown alarm")
+	}
+}
+
+func TestAlarmWebhookSink(t *testing.T) {
+	got := make(chan AlarmNotification, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n AlarmNotification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		got <- n
+	}))
+	defer srv.Close()
+
+	sink, err := NewAlarmWebhookSink(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Notify(&AlarmNotification{Name: "current", State: "active", Value: "320"})
+	sink.Close()
+	if n := <-got; n.Name != "current" || n.State != "active" || n.Value != "320" {
+		t.Errorf("got %+v", n)
+	}
+
+	if _, err := NewAlarmWebhookSink("localhost:8080"); err == nil {
+		t.Errorf("no error with a URL without scheme")
+	}
+}
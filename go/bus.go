@@ -0,0 +1,232 @@
+package mvb
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what a Bus does with an event when the queue of a
+// subscriber is full.
+type OverflowPolicy int
+
+const (
+	// Block waits for the subscriber, and so stalls the decoder and every
+	// other subscriber; samples are then lost upstream, in the input pipe.
+	Block OverflowPolicy = iota
+	// DropOldest discards the oldest queued event to make room.
+	DropOldest
+	// DropNewest discards the event for this subscriber only.
+	DropNewest
+)
+
+var overflowPolicies = map[string]OverflowPolicy{
+	"block":       Block,
+	"drop-oldest": DropOldest,
+	"drop-newest": DropNewest,
+}
+
+var queueSize = 1 << 16
+
+// OverflowFlag is the policy given with -overflow, for the subscribers that
+// can afford to lose events.
+var OverflowFlag = DropOldest
+
+func initBusFlags() {
+	flag.IntVar(&queueSize, "queue", queueSize, "events queued for each consumer")
+	flag.Func("overflow", "when the queue of a consumer other than the recorder is full: block, drop-oldest or drop-newest (default drop-oldest)", func(s string) error {
+		p, ok := overflowPolicies[s]
+		if !ok {
+			return fmt.Errorf("unknown overflow policy: %s", s)
+		}
+		OverflowFlag = p
+		return nil
+	})
+}
+
+// Overrun is delivered to a subscriber in place of the events it missed
+// because its queue was full.
+type Overrun struct {
+	n       uint64
+	Dropped uint64
+}
+
+func (o *Overrun) N() uint64 {
+	return o.n
+}
+
+func (o *Overrun) IsError() bool {
+	return false
+}
+
+// Bus delivers every event produced by a decoder to several subscribers, so
+// that the dashboard, the recorder and the other consumers can share a single
+// input stream.
+type Bus struct {
+	mu     sync.Mutex
+	subs   []*Subscriber
+	closed bool
+
+	wg       sync.WaitGroup
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+type Subscriber struct {
+	Name    string
+	C       chan Event
+	policy  OverflowPolicy
+	dropped uint64
+	stalls  uint64
+	// dropped events not yet reported with an Overrun
+	missed uint64
+}
+
+func NewBus() *Bus {
+	return &Bus{done: make(chan struct{})}
+}
+
+// Subscribe returns a subscriber receiving every event from now on, with a
+// queue of the given size.
+func (b *Bus) Subscribe(name string, size int, policy OverflowPolicy) *Subscriber {
+	s := &Subscriber{Name: name, C: make(chan Event, size), policy: policy}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.C)
+	} else {
+		b.subs = append(b.subs, s)
+	}
+	return s
+}
+
+// Dropped returns the number of events discarded because the queue of the
+// subscriber was full.
+func (s *Subscriber) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Subscribers returns the current subscribers.
+func (b *Bus) Subscribers() []*Subscriber {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*Subscriber(nil), b.subs...)
+}
+
+// Stalls returns the number of events for which a blocking subscriber made
+// the bus wait.
+func (s *Subscriber) Stalls() uint64 {
+	return atomic.LoadUint64(&s.stalls)
+}
+
+// Loop broadcasts the events received from in until it is closed, and then
+// closes the bus. Once the bus is closed, the events are still consumed, so
+// that the decoder never blocks.
+func (b *Bus) Loop(in <-chan Event) {
+	for ev := range in {
+		b.mu.Lock()
+		for _, s := range b.subs {
+			s.send(ev)
+		}
+		b.mu.Unlock()
+	}
+	b.Close()
+}
+
+func (s *Subscriber) send(ev Event) {
+	if s.missed > 0 {
+		o := &Overrun{n: ev.N(), Dropped: s.missed}
+		s.missed = 0
+		if !s.offer(o) {
+			s.missed += o.Dropped
+		}
+	}
+	if !s.offer(ev) {
+		s.lost(1)
+	}
+}
+
+// offer queues ev, applying the overflow policy if the queue is full. It
+// returns false if ev was dropped.
+func (s *Subscriber) offer(ev Event) bool {
+	for {
+		select {
+		case s.C <- ev:
+			return true
+		default:
+		}
+		switch s.policy {
+		case Block:
+			atomic.AddUint64(&s.stalls, 1)
+			s.C <- ev
+			return true
+		case DropOldest:
+			select {
+			case old := <-s.C:
+				if o, ok := old.(*Overrun); ok {
+					s.missed += o.Dropped
+				} else {
+					s.lost(1)
+				}
+			default:
+			}
+		default:
+			return false
+		}
+	}
+}
+
+func (s *Subscriber) lost(n uint64) {
+	s.missed += n
+	atomic.AddUint64(&s.dropped, n)
+}
+
+// Close closes the channels of all the subscribers.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for _, s := range b.subs {
+		close(s.C)
+	}
+	b.subs = nil
+}
+
+// Go runs loop on its own subscription, with the queue size given by -queue
+// and the given overflow policy. Loops are expected to return when their
+// channel is closed.
+func (b *Bus) Go(name string, policy OverflowPolicy, loop func(chan Event)) {
+	s := b.Subscribe(name, queueSize, policy)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		loop(s.C)
+		// a blocking subscriber must not stall the bus after returning
+		go func() {
+			for range s.C {
+			}
+		}()
+		b.doneOnce.Do(func() { close(b.done) })
+	}()
+}
+
+// Wait waits until any of the loops started with Go returns, and then closes
+// the bus and waits for the others to finish.
+func (b *Bus) Wait() {
+	<-b.done
+	subs := b.Subscribers()
+	b.Close()
+	b.wg.Wait()
+	for _, s := range subs {
+		if n := s.Dropped(); n > 0 {
+			logEvent("dropped", "subscriber", s.Name, "events", n)
+		}
+		if n := s.Stalls(); n > 0 {
+			logEvent("stalled", "subscriber", s.Name, "events", n)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package mvb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// publish sends a telegram for each n to the subscribers of b.
+func publish(b *Bus, ns ...uint64) {
+	in := make(chan Event, len(ns))
+	for _, n := range ns {
+		in <- testTelegram(n, 0x014, "")
+	}
+	close(in)
+	// like Loop, without closing the bus
+	for ev := range in {
+		b.mu.Lock()
+		for _, s := range b.subs {
+			s.send(ev)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// drain lists the events queued for s, as "n" or "overrun:dropped".
+func drain(s *Subscriber) string {
+	var got []string
+	for {
+		select {
+		case ev := <-s.C:
+			if o, ok := ev.(*Overrun); ok {
+				got = append(got, fmt.Sprintf("%d overrun:%d", o.N(), o.Dropped))
+			} else {
+				got = append(got, fmt.Sprint(ev.N()))
+			}
+		default:
+			return strings.Join(got, " ")
+		}
+	}
+}
+
+func TestBusOverflow(t *testing.T) {
+	for _, test := range []struct {
+		policy OverflowPolicy
+		// the events published before each drain of the queue
+		steps [][]uint64
+		want  []string
+		// the overruns report every dropped event
+		dropped uint64
+	}{
+		{
+			DropOldest,
+			[][]uint64{{1, 2, 3}, {4}, {5, 6, 7, 8}, {9}},
+			// the overrun before 8 is queued before 6 and 7 are dropped
+			[]string{"2 3", "4 overrun:1 4", "8 overrun:1 8", "9 overrun:2 9"},
+			4,
+		},
+		{
+			DropNewest,
+			[][]uint64{{1, 2, 3}, {4}, {5, 6, 7, 8}, {9}},
+			// the overrun before 8 is dropped too
+			[]string{"1 2", "4 overrun:1 4", "5 6", "9 overrun:2 9"},
+			3,
+		},
+	} {
+		b := NewBus()
+		s := b.Subscribe("test", 2, test.policy)
+		for i, step := range test.steps {
+			publish(b, step...)
+			if got := drain(s); got != test.want[i] {
+				t.Errorf("policy %d, step %d: got %q, want %q", test.policy, i, got, test.want[i])
+			}
+		}
+		if s.Dropped() != test.dropped || s.Stalls() != 0 {
+			t.Errorf("policy %d: %d dropped and %d stalls, want %d and 0", test.policy, s.Dropped(), s.Stalls(), test.dropped)
+		}
+	}
+}
+
+func TestBusBlock(t *testing.T) {
+	b := NewBus()
+	s := b.Subscribe("test", 1, Block)
+	published := make(chan bool)
+	go func() {
+		publish(b, 1, 2)
+		published <- true
+	}()
+	// 2 waits until 1 is received
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Stalls() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("not stalled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-published:
+		t.Fatal("published without waiting")
+	default:
+	}
+	if ev := <-s.C; ev.N() != 1 {
+		t.Errorf("got %d, want 1", ev.N())
+	}
+	<-published
+	if got := drain(s); got != "2" {
+		t.Errorf("got %q, want 2", got)
+	}
+	if s.Dropped() != 0 || s.Stalls() != 1 {
+		t.Errorf("%d dropped and %d stalls, want 0 and 1", s.Dropped(), s.Stalls())
+	}
+}
+
+func TestBusWait(t *testing.T) {
+	b := NewBus()
+	in := make(chan Event)
+	got := make(chan string, 2)
+	for _, name := range []string{"a", "b"} {
+		name := name
+		b.Go(name, Block, func(events chan Event) {
+			var ns []string
+			for ev := range events {
+				ns = append(ns, fmt.Sprint(ev.N()))
+			}
+			got <- name + ": " + strings.Join(ns, " ")
+		})
+	}
+	go b.Loop(in)
+	in <- testTelegram(1, 0x014, "")
+	in <- testTelegram(2, 0x014, "")
+	close(in)
+	b.Wait()
+	close(got)
+	var all []string
+	for s := range got {
+		all = append(all, s)
+	}
+	sort.Strings(all)
+	if want := "a: 1 2, b: 1 2"; strings.Join(all, ", ") != want {
+		t.Errorf("got %s, want %s", strings.Join(all, ", "), want)
+	}
+}
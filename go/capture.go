@@ -0,0 +1,352 @@
+package mvb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// capture file to open in the capture view, instead of decoding stdin
+var LoadFlag string
+
+var (
+	captureDir    = "."
+	captureFormat = "json"
+	captureLast   = 30 * time.Second
+
+	captureLastTelegrams = 0
+)
+
+func initCaptureFlags() {
+	flag.StringVar(&LoadFlag, "load", "", "open a capture saved from the dashboard, instead of decoding stdin")
+	flag.StringVar(&captureDir, "capture-dir", captureDir, "directory the captures are saved to")
+	flag.StringVar(&captureFormat, "capture-format", captureFormat, "format of the saved captures: json or binary")
+	flag.DurationVar(&captureLast, "capture-last", captureLast, "keep the telegrams and var changes of this last time, to capture them afterwards; 0 for no time limit")
+	flag.IntVar(&captureLastTelegrams, "capture-last-telegrams", captureLastTelegrams, "keep at most this many telegrams for -capture-last; 0 for no limit")
+}
+
+// A saved capture is a sequence of events in the same formats as sent by the
+// Server: JSON lines, or binary frames after captureMagic. Besides telegrams,
+// it holds the var changes, which are "var" events in JSON, and frames of
+// frameVar type:
+//
+//	var: n (uint64) address (uint16) value
+const (
+	captureMagic = "MVBCAP1\n"
+	frameVar     = byte('V')
+)
+
+var captureExts = map[string]string{
+	"json":   ".json",
+	"binary": ".mvbcap",
+}
+
+// SaveCapture writes c to a new file in -capture-dir, named after the current
+// time, and returns its path.
+func SaveCapture(c *Capture) (string, error) {
+	ext, ok := captureExts[captureFormat]
+	if !ok {
+		return "", fmt.Errorf("invalid capture format %q", captureFormat)
+	}
+	path := filepath.Join(captureDir, "capture-"+time.Now().Format("2006-01-02-150405")+ext)
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(fp)
+	err = WriteCapture(w, c, captureFormat)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	logEvent("capture_saved", "path", path, "telegrams", len(c.Telegrams))
+	return path, nil
+}
+
+type captureChange struct {
+	port uint16
+	VarChange
+}
+
+// WriteCapture writes the telegrams and the var changes of c in order, in json
+// or binary format.
+func WriteCapture(w io.Writer, c *Capture, format string) error {
+	if _, ok := captureExts[format]; !ok {
+		return fmt.Errorf("invalid capture format %q", format)
+	}
+	if format == "binary" {
+		if _, err := io.WriteString(w, captureMagic); err != nil {
+			return err
+		}
+	}
+	var changes []captureChange
+	for _, port := range c.SeenPorts {
+		for _, change := range c.Vars[uint16(port)] {
+			changes = append(changes, captureChange{uint16(port), change})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].N < changes[j].N
+	})
+
+	// a telegram goes before the var change it caused
+	i := 0
+	for _, t := range c.Telegrams {
+		for ; i < len(changes) && changes[i].N < t.n; i++ {
+			if err := writeVarChange(w, format, &changes[i]); err != nil {
+				return err
+			}
+		}
+		if err := writeEvent(w, format, t); err != nil {
+			return err
+		}
+	}
+	for ; i < len(changes); i++ {
+		if err := writeVarChange(w, format, &changes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeVarChange(w io.Writer, format string, c *captureChange) error {
+	if format == "json" {
+		data := hex.EncodeToString(c.Value)
+		return writeJSONLine(w, &StreamEvent{
+			Type:    "var",
+			N:       c.N,
+			Time:    sampleTimestamp(c.N).Seconds(),
+			Address: fmt.Sprintf("%03x", c.port),
+			Data:    &data,
+		})
+	}
+	payload := make([]byte, 10, 10+len(c.Value))
+	binary.BigEndian.PutUint64(payload, c.N)
+	binary.BigEndian.PutUint16(payload[8:], c.port)
+	return writeFrame(w, frameVar, append(payload, c.Value...))
+}
+
+// LoadCapture reads a capture saved with SaveCapture, in either format.
+func LoadCapture(path string) (*Capture, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	c, err := ReadCapture(fp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// ReadCapture reads a capture written by WriteCapture, in either format. The
+// capture is stopped.
+func ReadCapture(r io.Reader) (*Capture, error) {
+	c := &Capture{
+		Stopped: true,
+		Vars:    make(map[uint16][]VarChange),
+	}
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(captureMagic))
+	var err error
+	if string(magic) == captureMagic {
+		br.Discard(len(captureMagic))
+		err = readCaptureFrames(br, c)
+	} else {
+		err = readCaptureLines(br, c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func readCaptureLines(r io.Reader, c *Capture) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var se StreamEvent
+		if err := json.Unmarshal(scanner.Bytes(), &se); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		switch se.Type {
+		case "telegram":
+			t, err := se.telegram()
+			if err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+			c.AddTelegram(t)
+		case "var":
+			port, err := strconv.ParseUint(se.Address, 16, 12)
+			if err != nil || se.Data == nil {
+				return fmt.Errorf("line %d: invalid var", line)
+			}
+			value, err := hex.DecodeString(*se.Data)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid var: %v", line, err)
+			}
+			c.SetVar(se.N, uint16(port), value)
+		default:
+			return fmt.Errorf("line %d: unexpected event %q", line, se.Type)
+		}
+	}
+	return scanner.Err()
+}
+
+func readCaptureFrames(r io.Reader, c *Capture) error {
+	var header [3]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		payload := make([]byte, binary.BigEndian.Uint16(header[1:]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+		switch header[0] {
+		case frameTelegram:
+			if len(payload) < 12 || fcodes[payload[8]] == nil {
+				return errors.New("invalid telegram frame")
+			}
+			t := &Telegram{
+				n: binary.BigEndian.Uint64(payload),
+				Master: &MasterFrame{
+					FCode:   payload[8],
+					Address: binary.BigEndian.Uint16(payload[9:]) & 0xfff,
+				},
+			}
+			if payload[11] != 0 {
+				t.Slave = &SlaveFrame{payload[12:]}
+			}
+			c.AddTelegram(t)
+		case frameVar:
+			if len(payload) < 10 {
+				return errors.New("invalid var frame")
+			}
+			port := binary.BigEndian.Uint16(payload[8:]) & 0xfff
+			c.SetVar(binary.BigEndian.Uint64(payload), port, payload[10:])
+		default:
+			return fmt.Errorf("unexpected frame %q", header[0])
+		}
+	}
+}
+
+// Ring keeps the telegrams and the var changes of the last -capture-last,
+// and at most the last -capture-last-telegrams, so that something already
+// seen on the screen can still be captured.
+type Ring struct {
+	window uint64 // in samples, or 0
+	limit  int    // telegrams, or 0
+	start  uint64
+	last   uint64
+
+	telegrams []*Telegram
+	changes   []captureChange
+
+	// values of the vars before the oldest change
+	base map[uint16][]byte
+}
+
+// NewRing returns a ring limited to the last d, if positive, and to the last
+// limit telegrams, if positive. It returns nil if neither is.
+func NewRing(d time.Duration, limit int) *Ring {
+	if d <= 0 && limit <= 0 {
+		return nil
+	}
+	r := &Ring{
+		limit: limit,
+		base:  make(map[uint16][]byte),
+	}
+	if d > 0 {
+		r.window = uint64(d.Seconds() * SampleRate)
+	}
+	return r
+}
+
+func (r *Ring) AddTelegram(t *Telegram) {
+	r.advance(t.n)
+	r.telegrams = append(r.telegrams, t)
+	if r.limit > 0 && len(r.telegrams) > r.limit {
+		r.start = r.telegrams[len(r.telegrams)-r.limit].n
+		r.forget()
+	}
+}
+
+// SetVar records a change of the value of a port.
+func (r *Ring) SetVar(n uint64, port uint16, value []byte) {
+	r.advance(n)
+	r.changes = append(r.changes, captureChange{port, VarChange{n, value}})
+}
+
+// advance forgets what is older than the window. Going back in time, as when
+// a replay is sought, forgets everything.
+func (r *Ring) advance(n uint64) {
+	if n < r.last {
+		r.telegrams = nil
+		r.changes = nil
+		r.base = make(map[uint16][]byte)
+		r.start = 0
+	}
+	r.last = n
+	if r.window > 0 && n > r.window && n-r.window > r.start {
+		r.start = n - r.window
+		r.forget()
+	}
+}
+
+// forget drops what is older than start.
+func (r *Ring) forget() {
+	// the slices are reallocated with only the kept elements as they grow
+	i := 0
+	for i < len(r.telegrams) && r.telegrams[i].n < r.start {
+		i++
+	}
+	r.telegrams = r.telegrams[i:]
+	i = 0
+	for ; i < len(r.changes) && r.changes[i].N < r.start; i++ {
+		r.base[r.changes[i].port] = r.changes[i].Value
+	}
+	r.changes = r.changes[i:]
+}
+
+// Capture returns a stopped capture of the window, starting with the values
+// the vars had then.
+func (r *Ring) Capture() *Capture {
+	c := &Capture{
+		Stopped:   true,
+		Telegrams: append([]*Telegram(nil), r.telegrams...),
+		Vars:      make(map[uint16][]VarChange),
+	}
+	ports := make([]int, 0, len(r.base))
+	for port := range r.base {
+		ports = append(ports, int(port))
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		c.SetVar(r.start, uint16(port), r.base[uint16(port)])
+	}
+	for _, change := range r.changes {
+		c.SetVar(change.N, change.port, change.Value)
+	}
+	return c
+}
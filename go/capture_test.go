@@ -0,0 +1,97 @@
+package mvb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testTelegram(n uint64, port uint16, data string) *Telegram {
+	t := &Telegram{n: n, Master: &MasterFrame{FCode: 1, Address: port}}
+	if data != "" {
+		var b []byte
+		fmt.Sscanf(data, "%x", &b)
+		t.Slave = &SlaveFrame{b}
+	}
+	return t
+}
+
+// captureString lists the telegrams and the var changes of c.
+func captureString(c *Capture) string {
+	var b strings.Builder
+	for _, t := range c.Telegrams {
+		fmt.Fprintf(&b, "%d %03x %v\n", t.n, t.Master.Address, t.Slave)
+	}
+	for _, port := range c.SeenPorts {
+		for _, change := range c.Vars[uint16(port)] {
+			fmt.Fprintf(&b, "%03x %d %x\n", port, change.N, change.Value)
+		}
+	}
+	return b.String()
+}
+
+func TestCaptureSaveLoad(t *testing.T) {
+	s := NewStats()
+	s.StartStopCapture()
+	s.CountTelegram(testTelegram(100, 0x014, "0001"))
+	s.CountTelegram(testTelegram(200, 0x020, ""))
+	s.CountTelegram(testTelegram(300, 0x014, "0001"))
+	s.CountTelegram(testTelegram(400, 0x014, "0002"))
+	// as replayed, without telegram
+	s.SetVar(500, 0x0ee, []byte{0xff})
+	s.StartStopCapture()
+	want := captureString(s.Capture)
+
+	for _, format := range []string{"json", "binary"} {
+		var buf bytes.Buffer
+		if err := WriteCapture(&buf, s.Capture, format); err != nil {
+			t.Fatal(err)
+		}
+		c, err := ReadCapture(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if got := captureString(c); got != want || !c.Stopped {
+			t.Errorf("%s: got\n%swant\n%s", format, got, want)
+		}
+	}
+
+	if _, err := ReadCapture(strings.NewReader("{\"type\":\"error\"}\n")); err == nil {
+		t.Errorf("no error reading an error event")
+	}
+	if _, err := ReadCapture(strings.NewReader(captureMagic + "T\x00\x02xx")); err == nil {
+		t.Errorf("no error reading a short frame")
+	}
+}
+
+func TestRing(t *testing.T) {
+	r := NewRing(time.Second, 0)
+	second := uint64(SampleRate)
+	r.AddTelegram(testTelegram(0, 0x014, "0001"))
+	r.SetVar(0, 0x014, []byte{0, 1})
+	r.AddTelegram(testTelegram(second/2, 0x014, "0002"))
+	r.SetVar(second/2, 0x014, []byte{0, 2})
+	r.SetVar(second/2, 0x020, []byte{0xaa})
+	r.AddTelegram(testTelegram(2*second, 0x014, "0003"))
+	r.SetVar(2*second, 0x014, []byte{0, 3})
+
+	// the values before the window are kept as of its start
+	want := fmt.Sprintf("%d 014 0003\n014 %d 0002\n014 %d 0003\n020 %d aa\n",
+		2*second, second, 2*second, second)
+	if got := captureString(r.Capture()); got != want {
+		t.Errorf("got\n%swant\n%s", got, want)
+	}
+
+	// going back forgets everything
+	r.SetVar(second, 0x020, []byte{0xbb})
+	want = fmt.Sprintf("020 %d bb\n", second)
+	if got := captureString(r.Capture()); got != want {
+		t.Errorf("got\n%swant\n%s", got, want)
+	}
+
+	if NewRing(0, 0) != nil {
+		t.Errorf("ring with no window")
+	}
+}
@@ -0,0 +1,239 @@
+package mvb
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const (
+	// width of the sparklines of the watched variables
+	sparkWidth = 30
+	// width of the value labels left of the chart
+	chartLabelWidth = 12
+	// shortest span shown when zooming in
+	chartMinSpan = SampleRate / 100
+)
+
+// chart is the full-screen chart of a watched variable.
+type chart struct {
+	index int
+	// samples shown
+	span uint64
+	// columns left of the right edge
+	cursor int
+	// if frozen, the chart ends at end instead of the last sample
+	frozen bool
+	end    uint64
+	signed bool
+	blocks bool
+}
+
+// braille dots of each pixel of a cell, by row and column
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+func (d *Dashboard) openChart() error {
+	h := d.stats.History
+	switch {
+	case len(d.watchedPorts) == 0:
+		return fmt.Errorf("no watched variables")
+	case h == nil:
+		return fmt.Errorf("-history-window is disabled")
+	}
+	d.chart = &chart{index: d.watchedPortsSelected, span: h.Series[0].window}
+	return nil
+}
+
+func (d *Dashboard) tryChart(ev *tcell.EventKey) bool {
+	c := d.chart
+	if c == nil {
+		return false
+	}
+	w, _ := d.screen.Size()
+	maxCursor := w - chartLabelWidth - 1
+	switch {
+	case ev.Key() == tcell.KeyESC:
+		d.chart = nil
+	case ev.Key() == tcell.KeyLeft:
+		c.cursor = clamp(c.cursor+1, 0, maxCursor)
+	case ev.Key() == tcell.KeyRight:
+		c.cursor = clamp(c.cursor-1, 0, maxCursor)
+	case ev.Key() == tcell.KeyPgUp:
+		c.cursor = clamp(c.cursor+10, 0, maxCursor)
+	case ev.Key() == tcell.KeyPgDn:
+		c.cursor = clamp(c.cursor-10, 0, maxCursor)
+	case ev.Key() == tcell.KeyHome:
+		c.cursor = maxCursor
+	case ev.Key() == tcell.KeyEnd:
+		c.cursor = 0
+	case ev.Rune() == '+':
+		if c.span /= 2; c.span < chartMinSpan {
+			c.span = chartMinSpan
+		}
+	case ev.Rune() == '-':
+		if c.span *= 2; c.span > d.stats.History.Series[c.index].window {
+			c.span = d.stats.History.Series[c.index].window
+		}
+	case ev.Rune() == 'p' || ev.Rune() == 'P':
+		c.frozen = !c.frozen
+		c.end = d.n()
+	case ev.Rune() == 'u' || ev.Rune() == 'U':
+		c.signed = !c.signed
+	case ev.Rune() == 'b' || ev.Rune() == 'B':
+		c.blocks = !c.blocks
+	case ev.Key() == tcell.KeyUp:
+		c.index = addWatchedPortOffset(c.index, -1, len(d.watchedPorts)-1)
+	case ev.Key() == tcell.KeyDown:
+		c.index = addWatchedPortOffset(c.index, 1, len(d.watchedPorts)-1)
+	default:
+		return false
+	}
+	return true
+}
+
+func (d *Dashboard) renderChart(c *chart) {
+	s := d.screen
+	w, h := s.Size()
+	spec := d.watchedPorts[c.index]
+	series := d.stats.History.Series[c.index]
+
+	header := fmt.Sprintf("MVB CHART %s (%03x", spec.Desc, spec.Port)
+	if r := specRange(&spec); r != "" {
+		header += ":" + r
+	}
+	header += ") [left/right: cursor] [+/-: zoom] [up/down: var] [u: signed] [b: blocks] [p: freeze] [esc: back]"
+	d.renderHeader(invStyle, header)
+
+	end := d.n()
+	if c.frozen {
+		end = c.end
+	}
+	start := uint64(0)
+	if end > c.span {
+		start = end - c.span
+	}
+	cols, rows := w-chartLabelWidth, h-3
+	if cols < 1 || rows < 1 || end == start {
+		return
+	}
+	// time of the start of column x of n, and of its end at x+1
+	at := func(x, n int) uint64 {
+		return start + (end-start)*uint64(x)/uint64(n)
+	}
+
+	// value range of the visible values
+	lo, hi, ok := series.span(start, end, c.signed)
+	if !ok {
+		drawText(s, chartLabelWidth, 1+rows/2, defStyle, "no values")
+		return
+	}
+	if lo == hi {
+		lo, hi = lo-1, hi+1
+	}
+
+	if c.blocks {
+		d.renderChartBlocks(c, series, at, lo, hi, cols, rows)
+	} else {
+		d.renderChartBraille(c, series, at, lo, hi, cols, rows)
+	}
+
+	for i, v := range []float64{hi, (lo + hi) / 2, lo} {
+		drawText(s, 0, 2+(rows-1)*i/2, defStyle, fmt.Sprintf("%*s", chartLabelWidth-1, formatChartValue(v)))
+	}
+	left := fmt.Sprintf("%.3fs", sampleTimestamp(start).Seconds())
+	right := fmt.Sprintf("%.3fs", sampleTimestamp(end).Seconds())
+	drawText(s, chartLabelWidth, h-1, defStyle, left)
+	drawText(s, w-len(right), h-1, defStyle, right)
+
+	// the cursor, with the values of its column
+	x := cols - 1 - clamp(c.cursor, 0, cols-1)
+	for y := 2; y < 2+rows; y++ {
+		r, _, style, _ := s.GetContent(chartLabelWidth+x, y)
+		s.SetContent(chartLabelWidth+x, y, r, nil, style.Reverse(true))
+	}
+	a, b := at(x, cols), at(x+1, cols)
+	readout := fmt.Sprintf("cursor %.3fs", sampleTimestamp(b).Seconds())
+	if cmin, cmax, ok := series.span(a, b, c.signed); !ok {
+		readout += " = ?"
+	} else if cmin == cmax {
+		readout += " = " + formatChartValue(cmin)
+	} else {
+		readout += fmt.Sprintf(" = %s..%s", formatChartValue(cmin), formatChartValue(cmax))
+	}
+	readout += fmt.Sprintf("   min %s   max %s   span %.3fs", formatChartValue(lo), formatChartValue(hi),
+		sampleTimestamp(end-start).Seconds())
+	if c.frozen {
+		readout += "   FROZEN"
+	}
+	drawText(s, 0, 1, defStyle, readout)
+}
+
+// renderChartBraille draws the minimum to maximum of each pixel column,
+// with 2x4 braille pixels per cell.
+func (d *Dashboard) renderChartBraille(c *chart, series *Series, at func(x, n int) uint64, lo, hi float64, cols, rows int) {
+	cells := make([][]rune, rows)
+	for y := range cells {
+		cells[y] = make([]rune, cols)
+	}
+	pw, ph := 2*cols, 4*rows
+	pixel := func(v float64) int {
+		return ph - 1 - int(math.Round((v-lo)/(hi-lo)*float64(ph-1)))
+	}
+	for px := 0; px < pw; px++ {
+		vmin, vmax, ok := series.span(at(px, pw), at(px+1, pw), c.signed)
+		if !ok {
+			continue
+		}
+		for py := pixel(vmax); py <= pixel(vmin); py++ {
+			cells[py/4][px/2] |= brailleDots[py%4][px%2]
+		}
+	}
+	for y, row := range cells {
+		for x, dots := range row {
+			if dots != 0 {
+				d.screen.SetContent(chartLabelWidth+x, 2+y, 0x2800+dots, nil, defStyle)
+			}
+		}
+	}
+}
+
+// renderChartBlocks draws the maximum of each column as a bar, with eighths
+// of a cell.
+func (d *Dashboard) renderChartBlocks(c *chart, series *Series, at func(x, n int) uint64, lo, hi float64, cols, rows int) {
+	for x := 0; x < cols; x++ {
+		_, vmax, ok := series.span(at(x, cols), at(x+1, cols), c.signed)
+		if !ok {
+			continue
+		}
+		eighths := 1 + int(math.Round((vmax-lo)/(hi-lo)*float64(8*rows-1)))
+		for y := rows - 1; y >= 0 && eighths > 0; y-- {
+			r := '█'
+			if eighths < 8 {
+				r = '▁' + rune(eighths-1)
+			}
+			d.screen.SetContent(chartLabelWidth+x, 2+y, r, nil, defStyle)
+			eighths -= 8
+		}
+	}
+}
+
+func formatChartValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', 8, 64)
+}
+
+func clamp(v, lo, hi int) int {
+	if v > hi {
+		v = hi
+	}
+	if v < lo {
+		v = lo
+	}
+	return v
+}
@@ -0,0 +1,140 @@
+// Package client is a small Go client for the gRPC API served with -grpc.
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"mvb/mvbpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Client wraps the generated mvbpb.MVBClient with plain Go types.
+type Client struct {
+	conn *grpc.ClientConn
+	api  mvbpb.MVBClient
+}
+
+// Dial connects to a server, e.g. "localhost:7001". The connection is
+// established lazily by the first call.
+func Dial(addr string) (*Client, error) {
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, api: mvbpb.NewMVBClient(conn)}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// API returns the generated client, for the calls not wrapped here.
+func (c *Client) API() mvbpb.MVBClient {
+	return c.api
+}
+
+// Port returns the last value of a process data port, and whether it has
+// been seen at all.
+func (c *Client) Port(ctx context.Context, port uint16) ([]byte, bool, error) {
+	p, err := c.api.GetPort(ctx, &mvbpb.GetPortRequest{Port: uint32(port)})
+	if err != nil {
+		return nil, false, err
+	}
+	return p.Value, p.Seen, nil
+}
+
+// Ports returns the last value of every port seen.
+func (c *Client) Ports(ctx context.Context) (map[uint16][]byte, error) {
+	resp, err := c.api.ListPorts(ctx, &mvbpb.ListPortsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	ports := make(map[uint16][]byte)
+	for _, p := range resp.Ports {
+		ports[uint16(p.Port)] = p.Value
+	}
+	return ports, nil
+}
+
+// Variables returns the variables watched by the server.
+func (c *Client) Variables(ctx context.Context) ([]*mvbpb.Variable, error) {
+	resp, err := c.api.ListVariables(ctx, &mvbpb.ListVariablesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Variables, nil
+}
+
+// DeviceStatus returns the last status reported by a device.
+func (c *Client) DeviceStatus(ctx context.Context, address uint16) ([]byte, bool, error) {
+	s, err := c.api.GetDeviceStatus(ctx, &mvbpb.GetDeviceStatusRequest{Address: uint32(address)})
+	if err != nil {
+		return nil, false, err
+	}
+	return s.Status, s.Seen, nil
+}
+
+func (c *Client) Rates(ctx context.Context) (*mvbpb.Rates, error) {
+	return c.api.GetRates(ctx, &mvbpb.GetRatesRequest{})
+}
+
+func (c *Client) Errors(ctx context.Context) ([]*mvbpb.Error, error) {
+	resp, err := c.api.ListErrors(ctx, &mvbpb.ListErrorsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Errors, nil
+}
+
+// WatchPorts calls fn for every change of the given ports (all if empty),
+// starting with their current values, until ctx is done, the stream fails
+// or fn returns an error.
+func (c *Client) WatchPorts(ctx context.Context, ports []uint16, fn func(*mvbpb.PortChange) error) error {
+	req := &mvbpb.SubscribePortsRequest{Initial: true}
+	for _, p := range ports {
+		req.Ports = append(req.Ports, uint32(p))
+	}
+	stream, err := c.api.SubscribePorts(ctx, req)
+	if err != nil {
+		return err
+	}
+	for {
+		change, err := stream.Recv()
+		if err != nil {
+			return eof(err)
+		}
+		if err := fn(change); err != nil {
+			return err
+		}
+	}
+}
+
+// WatchVariables calls fn for every change of the watched variables with the
+// given descriptions (all if empty), starting with their current values.
+func (c *Client) WatchVariables(ctx context.Context, descs []string, fn func(*mvbpb.VariableChange) error) error {
+	stream, err := c.api.SubscribeVariables(ctx, &mvbpb.SubscribeVariablesRequest{Descs: descs, Initial: true})
+	if err != nil {
+		return err
+	}
+	for {
+		change, err := stream.Recv()
+		if err != nil {
+			return eof(err)
+		}
+		if err := fn(change); err != nil {
+			return err
+		}
+	}
+}
+
+// eof turns the normal end of a stream into a nil error.
+func eof(err error) error {
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
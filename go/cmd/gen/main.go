@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"mvb"
+	"mvb/gen"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+var (
+	rate      = float64(mvb.SampleRate)
+	high      = byte(0xff)
+	low       = byte(0xfe)
+	telegrams = ""
+	count     = 0
+	gap       = 50 * time.Microsecond
+	replyGap  = 5 * time.Microsecond
+	realtime  = false
+	schedule  = ""
+
+	faults      = ""
+	faultScript = ""
+	seed        = int64(1)
+	jitter      = time.Duration(0)
+	truth       = ""
+)
+
+func byteFlag(p *byte) func(string) error {
+	return func(s string) error {
+		n, err := strconv.ParseUint(s, 16, 8)
+		*p = byte(n)
+		return err
+	}
+}
+
+// Writes MVB traffic to stdout as raw samples, one byte per sample, e.g.
+//
+//	go run cmd/gen/main.go -realtime | go run cmd/main.go
+func main() {
+	log.SetFlags(0)
+
+	flag.Float64Var(&rate, "rate", rate, "samples per second")
+	flag.Func("high", "byte value for output = high (default ff)", byteFlag(&high))
+	flag.Func("low", "byte value for output = low (default fe)", byteFlag(&low))
+	flag.StringVar(&telegrams, "telegrams", telegrams, "file with the telegrams to send, one per line: fcode, port and slave data in hex (default: the telegrams of gen/gen.c)")
+	flag.StringVar(&schedule, "schedule", schedule, "simulate a bus administrator that polls the ports of this schedule file, instead of sending the telegrams in turn")
+	flag.IntVar(&count, "count", count, "number of telegrams to send (0: forever)")
+	flag.DurationVar(&gap, "gap", gap, "idle time between telegrams")
+	flag.DurationVar(&replyGap, "reply-gap", replyGap, "idle time between a master frame and its slave frame")
+	flag.BoolVar(&realtime, "realtime", realtime, "write the samples at the pace of the sample rate")
+	flag.StringVar(&faults, "faults", faults, "inject faults at random, with a probability per telegram, e.g. bit_flip=0.01,glitch=0.001; faults: bit_flip, jitter, truncate, glitch, missing_reply, duplicate_master")
+	flag.StringVar(&faultScript, "fault-script", faultScript, "file with the faults to inject, one per line: telegram index, fault and optionally master or slave")
+	flag.Int64Var(&seed, "seed", seed, "seed of the random faults")
+	flag.DurationVar(&jitter, "jitter", jitter, "maximum displacement of the level changes with the jitter fault (default BT/8)")
+	flag.StringVar(&truth, "truth", truth, "write the injected faults to this file, as JSON lines")
+	flag.Parse()
+
+	if term.IsTerminal(1) {
+		log.Fatalf("stdout must be a pipe")
+	}
+
+	var list []*mvb.Telegram
+	var sim *gen.Simulator
+	var err error
+	if schedule != "" {
+		var fp *os.File
+		fp, err = os.Open(schedule)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var s *gen.Schedule
+		s, err = gen.ParseSchedule(fp, filepath.Dir(schedule), seed)
+		fp.Close()
+		if err == nil && len(s.Ports) == 0 {
+			err = fmt.Errorf("%s: no ports", schedule)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		sim = gen.NewSimulator(s)
+	} else if telegrams == "" {
+		list, err = gen.ParseTelegrams(strings.NewReader(gen.DefaultTelegrams))
+	} else {
+		var fp *os.File
+		fp, err = os.Open(telegrams)
+		if err != nil {
+			log.Fatal(err)
+		}
+		list, err = gen.ParseTelegrams(fp)
+		fp.Close()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if sim == nil && len(list) == 0 {
+		log.Fatal("no telegrams to send")
+	}
+
+	e := gen.NewEncoder(os.Stdout, rate)
+	e.High, e.Low = high, low
+	e.ReplyGap = replyGap
+
+	in := gen.NewInjector(e, seed)
+	in.Rates, err = gen.ParseFaultRates(faults)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if faultScript != "" {
+		fp, err := os.Open(faultScript)
+		if err != nil {
+			log.Fatal(err)
+		}
+		in.Script, err = gen.ParseFaultScript(fp)
+		fp.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if jitter != 0 {
+		in.JitterMax = jitter
+	}
+	if truth != "" {
+		fp, err := os.Create(truth)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer fp.Close()
+		enc := json.NewEncoder(fp)
+		in.OnFault = func(f *gen.Fault) {
+			if err := enc.Encode(f); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	start := time.Now()
+	for i := 0; count == 0 || i < count; i++ {
+		if sim != nil {
+			// from the start of the basic period, or else after the
+			// previous telegram
+			at, t := sim.Next()
+			if d := at - e.Time(); d > gap {
+				e.Idle(d)
+			} else {
+				e.Idle(gap)
+			}
+			in.Telegram(t)
+		} else {
+			e.Idle(gap)
+			in.Telegram(list[i%len(list)])
+		}
+		if realtime {
+			if err := e.Flush(); err != nil {
+				log.Fatal(err)
+			}
+			time.Sleep(time.Until(start.Add(e.Time())))
+		}
+	}
+	// let the decoder see the end of the last frame
+	e.Idle(gap)
+	if err := e.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -42,19 +42,155 @@ func main() {
 
 	log.SetFlags(0)
 
-	if term.IsTerminal(0) {
+	mvb.InitFlags()
+
+	if mvb.ConnectFlag == "" && mvb.ReplayFlag == "" && mvb.LoadFlag == "" && term.IsTerminal(0) {
 		log.Fatalf("stdin must be a pipe")
 	}
 
-	mvb.InitFlags()
-
 	ports, err := mvb.ParseRecorderPortSpecs(flag.CommandLine.Args())
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := mvb.InitConditions(ports); err != nil {
+		log.Fatal(err)
+	}
+
+	alarms, err := mvb.NewAlarms(ports)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if alarms != nil && (mvb.ReplayFlag != "" || mvb.LoadFlag != "") {
+		log.Fatal("-alarms can't be used with -replay or -load")
+	}
+
+	if mvb.ReplayFlag != "" {
+		replay(ports)
+		return
+	}
+	if mvb.LoadFlag != "" {
+		load(ports)
+		return
+	}
+
+	var decoder interface {
+		N() uint64
+		Loop(chan<- mvb.Event)
+	}
+	if mvb.ConnectFlag != "" {
+		decoder = mvb.NewRemoteDecoder(mvb.ConnectFlag)
+	} else {
+		stream, err := mvb.NewInputStream()
+		if err != nil {
+			log.Fatal(err)
+		}
+		decoder = mvb.NewDecoder(stream)
+	}
+
+	var recorder *mvb.Recorder
+	if mvb.RecordFlag {
+		recorder, err = mvb.NewRecorder(ports)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if mvb.HTTPFlag == "" && mvb.LogFlag == "" {
+			// keep the recorder messages off the terminal dashboard
+			fp, err := os.OpenFile("record.log", os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.SetOutput(fp)
+		}
+	}
 
 	events := make(chan mvb.Event)
-	decoder := mvb.NewDecoder(mvb.NewMVBStream())
 	go decoder.Loop(events)
-	mvb.NewDashboard(decoder.N, ports).Loop(events)
+	metrics := mvb.StartMetrics(ports)
+	grpc, err := mvb.StartGRPC(ports)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bus := mvb.NewBus()
+	metrics.WatchBus(bus)
+	if metrics != nil {
+		bus.Go("metrics", mvb.OverflowFlag, metrics.Loop)
+	}
+	if grpc != nil {
+		bus.Go("grpc", mvb.OverflowFlag, grpc.Loop)
+	}
+	if mvb.HTTPFlag != "" {
+		d := mvb.NewWebDashboard(mvb.HTTPFlag, decoder.N, ports)
+		d.SetAlarms(alarms)
+		bus.Go("dashboard", mvb.OverflowFlag, d.Loop)
+	} else {
+		d := mvb.NewDashboard(decoder.N, ports)
+		d.SetAlarms(alarms)
+		bus.Go("dashboard", mvb.OverflowFlag, d.Loop)
+	}
+	if recorder != nil {
+		bus.Go("recorder", mvb.Block, recorder.Loop)
+	}
+	if alarms != nil {
+		bus.Go("alarms", mvb.OverflowFlag, alarms.Loop)
+	}
+	go bus.Loop(events)
+	bus.Wait()
+}
+
+// load shows a capture saved from the dashboard, instead of the decoded
+// events.
+func load(ports []mvb.RecorderPortSpec) {
+	if mvb.RecordFlag {
+		log.Fatal("-record can't be used with -load")
+	}
+	c, err := mvb.LoadCapture(mvb.LoadFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	n := func() uint64 {
+		if len(c.Telegrams) == 0 {
+			return 0
+		}
+		return c.Telegrams[len(c.Telegrams)-1].N()
+	}
+
+	// nothing is decoded
+	events := make(chan mvb.Event)
+	if mvb.HTTPFlag != "" {
+		d := mvb.NewWebDashboard(mvb.HTTPFlag, n, ports)
+		d.SetCapture(c)
+		d.Loop(events)
+	} else {
+		d := mvb.NewDashboard(n, ports)
+		d.SetCapture(c)
+		d.Loop(events)
+	}
+}
+
+// replay shows the values recorded by the recorder in a day, instead of the
+// decoded events. Without port specs, all the recorded files are watched.
+func replay(ports []mvb.RecorderPortSpec) {
+	if mvb.RecordFlag {
+		log.Fatal("-record can't be used with -replay")
+	}
+	r, err := mvb.NewReplay(mvb.ReplayFlag, ports)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(ports) == 0 {
+		ports = r.Specs
+	}
+
+	// nothing is decoded
+	events := make(chan mvb.Event)
+	if mvb.HTTPFlag != "" {
+		d := mvb.NewWebDashboard(mvb.HTTPFlag, r.N, ports)
+		d.SetReplay(r)
+		d.Loop(events)
+	} else {
+		d := mvb.NewDashboard(r.N, ports)
+		d.SetReplay(r)
+		d.Loop(events)
+	}
 }
@@ -5,7 +5,6 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"os"
 	"strconv"
 	"time"
 
@@ -19,11 +18,53 @@ const (
 
 var initialPort uint16 = 0
 
+// address of the web dashboard; if set, the dashboard is served over HTTP
+// instead of the terminal
+var HTTPFlag string
+
+// address of a Server to read the events from, instead of decoding stdin
+var ConnectFlag string
+
+// if set, the watched ports are also recorded, as with record/main.go
+var RecordFlag bool
+
+// how long the values of the watched variables are kept, for the sparklines
+// and charts
+var historyWindow = 60 * time.Second
+
+// conditions whose ports are highlighted while they hold, compiled by
+// InitConditions
+var (
+	highlightFlags []string
+	highlights     []*Expr
+)
+
 func initDashboardFlags() {
 	flag.Func("port", "initial port offset", func(s string) (err error) {
 		initialPort, err = decodePort(s)
 		return
 	})
+	flag.StringVar(&HTTPFlag, "http", "", "serve the dashboard over HTTP on this address, e.g. :8080")
+	flag.StringVar(&ConnectFlag, "connect", "", "read the events from a server started with serve/main.go, instead of stdin")
+	flag.BoolVar(&RecordFlag, "record", false, "record the watched ports while showing the dashboard")
+	flag.Func("highlight", "highlight the ports of this condition while it holds, e.g. 'port(0x014)[0:2] as int16 < -50'; can be repeated", func(s string) error {
+		highlightFlags = append(highlightFlags, s)
+		return nil
+	})
+	flag.DurationVar(&historyWindow, "history-window", historyWindow, "keep the values of the watched variables for this long, for the sparklines and charts (0: disabled)")
+}
+
+// highlighted returns the ports of the -highlight conditions that hold.
+func highlighted(s *Stats) map[uint16]bool {
+	ports := make(map[uint16]bool)
+	for _, e := range highlights {
+		if e.Eval(nil, s) {
+			for _, port := range e.Ports() {
+				ports[port] = true
+			}
+		}
+	}
+	return ports
 }
 
 func decodePort(s string) (uint16, error) {
@@ -55,15 +96,46 @@ type Dashboard struct {
 	n                  func() uint64
 	watchedPorts       []RecorderPortSpec
 	watchedPortsOffset int
+	// watched variable whose chart is opened with c
+	watchedPortsSelected int
+	chart                *chart
+	diff                 *diff
+	replay               *Replay
+	alarms               *Alarms
+
+	// result of the last command, shown until the next key
+	message string
 }
 
 func NewDashboard(n func() uint64, watchedPorts []RecorderPortSpec) *Dashboard {
-	return &Dashboard{
-		stats:        NewStats(),
+	d := &Dashboard{
+		stats:        newCaptureStats(),
 		port:         uint16(initialPort),
 		n:            n,
 		watchedPorts: watchedPorts,
 	}
+	d.stats.History = NewVarHistory(watchedPorts, historyWindow)
+	if initialDiff != nil {
+		d.diff = newPortDiff(initialDiff[0], initialDiff[1])
+	}
+	return d
+}
+
+// SetCapture opens c in the capture view, as with -load.
+func (d *Dashboard) SetCapture(c *Capture) {
+	d.stats.Capture = c
+}
+
+// SetReplay makes the dashboard show the values replayed by r, which can be
+// controlled from the keyboard, instead of decoded events.
+func (d *Dashboard) SetReplay(r *Replay) {
+	d.replay = r
+}
+
+// SetAlarms shows the active and unacknowledged alarms of a, which can be
+// acknowledged from the keyboard.
+func (d *Dashboard) SetAlarms(a *Alarms) {
+	d.alarms = a
 }
 
 var (
@@ -71,6 +143,7 @@ var (
 	invStyle = defStyle.Reverse(true)
 	errStyle = tcell.StyleDefault.Background(tcell.ColorReset).Foreground(tcell.ColorRed)
 	capStyle = errStyle.Background(tcell.ColorWhite).Reverse(true).Bold(true)
+	hiStyle  = defStyle.Foreground(tcell.ColorYellow).Bold(true)
 )
 
 func (d *Dashboard) init() {
@@ -89,18 +162,18 @@ func (d *Dashboard) init() {
 	d.screen = s
 }
 
-func (d *Dashboard) quit() {
-	d.screen.Fini()
-	os.Exit(0)
-}
-
 func (d *Dashboard) render() {
 	if d.paused {
 		return
 	}
 	s := d.screen
 	s.Clear()
+	d.checkDiff()
 	switch {
+	case d.diff != nil:
+		d.renderDiff(d.diff)
+	case d.chart != nil:
+		d.renderChart(d.chart)
 	case d.stats.Capture != nil:
 		d.renderCapture(d.stats.Capture)
 	default:
@@ -109,29 +182,67 @@ func (d *Dashboard) render() {
 	s.Show()
 }
 
-func (d *Dashboard) showPort(y int, port uint16) {
+func (d *Dashboard) showPort(y int, port uint16, style tcell.Style) {
 	v := d.stats.Vars[port]
-	drawText(d.screen, 0, y, defStyle, fmt.Sprintf("port %03x = %s", port, hex.EncodeToString(v)))
+	drawText(d.screen, 0, y, style, fmt.Sprintf("port %03x = %s", port, hex.EncodeToString(v)))
+}
+
+func portStyle(hi map[uint16]bool, port uint16) tcell.Style {
+	if hi[port] {
+		return hiStyle
+	}
+	return defStyle
 }
 
 func (d *Dashboard) renderHeader(style tcell.Style, s string) {
+	if d.message != "" {
+		s += " " + d.message
+	}
 	w, _ := d.screen.Size()
 	drawText(d.screen, 0, 0, style, fmt.Sprintf(fmt.Sprintf("%%-%ds", w), s))
 }
 
+// renderOverrun shows whether the dashboard is falling behind the decoder.
+func (d *Dashboard) renderOverrun(x int, y int) {
+	if d.stats.Overrun() {
+		drawText(d.screen, x, y, errStyle.Reverse(true), " INPUT OVERRUN ")
+	}
+	if d.stats.Dropped > 0 {
+		drawText(d.screen, x+16, y, errStyle, fmt.Sprintf("%d events dropped", d.stats.Dropped))
+	}
+}
+
 func (d *Dashboard) renderMain() {
 	s := d.screen
 
-	d.renderHeader(invStyle, "MVB [space: capture] [/: port filter] [p: pause] [q: quit]")
 	y := 1
-
-	drawText(s, 0, y, defStyle, fmt.Sprintf("Total: %d telegrams", d.stats.Total))
-	drawText(s, 40, y, defStyle, fmt.Sprintf("%.3fs", sampleTimestamp(d.n()).Seconds()))
+	if d.replay != nil {
+		d.renderHeader(invStyle, "MVB REPLAY [enter: play/pause] [</>: speed] [left/right: 10s] [[/]: 1m] [space: capture] [l: last] [q: quit]")
+		drawText(s, 0, y, defStyle, d.replay.Status())
+	} else {
+		keys := "[space: capture] [l: last] [d: diff] [/ and enter: port filter] [p: pause] [q: quit]"
+		if len(d.watchedPorts) != 0 {
+			keys = "[c: chart] " + keys
+		}
+		if d.alarms != nil {
+			keys = "[a: ack alarms] " + keys
+		}
+		d.renderHeader(invStyle, "MVB "+keys)
+		drawText(s, 0, y, defStyle, fmt.Sprintf("Total: %d telegrams", d.stats.Total))
+		drawText(s, 40, y, defStyle, fmt.Sprintf("%.3fs", sampleTimestamp(d.n()).Seconds()))
+		d.renderOverrun(56, y)
+	}
 	y++
 
 	drawHLine(s, y, defStyle)
 	y++
 
+	if end := d.renderAlarms(y); end > y {
+		y = end
+		drawHLine(s, y, defStyle)
+		y++
+	}
+
 	rate := d.stats.Rate()
 	drawText(s, 0, y, defStyle, fmt.Sprintf(
 		"%s %6d telegrams/s",
@@ -143,6 +254,7 @@ func (d *Dashboard) renderMain() {
 	drawHLine(s, y, defStyle)
 	y++
 
+	hi := highlighted(&d.stats)
 	if len(d.watchedPorts) == 0 {
 		for i := range d.stats.mrRates {
 			rate := d.stats.MRRate(MasterRequest(i))
@@ -159,16 +271,16 @@ func (d *Dashboard) renderMain() {
 		y++
 
 		if d.portFilter != nil {
-			d.showPort(y, d.portFilter.port())
+			d.showPort(y, d.portFilter.port(), portStyle(hi, d.portFilter.port()))
 			y++
 		} else {
 			for port := d.port; port < d.port+portPageSize; port++ {
-				d.showPort(y, port)
+				d.showPort(y, port, portStyle(hi, port))
 				y++
 			}
 		}
 	} else {
-		y = d.renderWatchedPorts(y)
+		y = d.renderWatchedPorts(y, hi)
 	}
 
 	drawHLine(s, y, defStyle)
@@ -200,10 +312,55 @@ func (d *Dashboard) renderMain() {
 	s.Show()
 }
 
-func (d *Dashboard) renderWatchedPorts(y int) int {
+// renderAlarms lists the active and unacknowledged alarms, the latter in
+// reverse.
+func (d *Dashboard) renderAlarms(y int) int {
+	if d.alarms == nil {
+		return y
+	}
+	for _, a := range d.alarms.List() {
+		style := errStyle
+		if !a.Acked {
+			style = style.Reverse(true)
+		}
+		drawText(d.screen, 0, y, style, fmt.Sprintf("%-7s %s %-24s %-16s %s",
+			a.State(), a.Since.Format("15:04:05"), a.Name, a.Value, a.Rule))
+		y++
+	}
+	return y
+}
+
+// renderWatchedPorts lists the watched variables that fit above the errors,
+// scrolled to show the selected one, with a sparkline of their values.
+func (d *Dashboard) renderWatchedPorts(y int, hi map[uint16]bool) int {
 	s := d.screen
-	for _, w := range d.watchedPorts[d.watchedPortsOffset:] {
-		drawText(s, 0, y, defStyle, fmt.Sprintf("%32s %x", w.Desc, slice(d.stats.Vars[w.Port], w.I, w.J)))
+	_, h := s.Size()
+	rows := h - y - 3
+	if rows < 1 {
+		rows = 1
+	}
+	if d.watchedPortsSelected < d.watchedPortsOffset {
+		d.watchedPortsOffset = d.watchedPortsSelected
+	}
+	if d.watchedPortsSelected >= d.watchedPortsOffset+rows {
+		d.watchedPortsOffset = d.watchedPortsSelected - rows + 1
+	}
+	end := d.watchedPortsOffset + rows
+	if end > len(d.watchedPorts) {
+		end = len(d.watchedPorts)
+	}
+	for i := d.watchedPortsOffset; i < end; i++ {
+		w := d.watchedPorts[i]
+		style := portStyle(hi, w.Port)
+		if i == d.watchedPortsSelected {
+			style = style.Reverse(true)
+		}
+		line := fmt.Sprintf("%32s ", w.Desc)
+		if history := d.stats.History; history != nil {
+			series := history.Series[i]
+			line += series.spark(d.n(), series.window, sparkWidth, false) + " "
+		}
+		drawText(s, 0, y, style, line+fmt.Sprintf("%x", slice(d.stats.Vars[w.Port], w.I, w.J)))
 		y++
 	}
 	return y
@@ -225,9 +382,9 @@ func (d *Dashboard) scrollCaptureTelegramsEnd(c *Capture) {
 
 func (d *Dashboard) renderCaptureTelegrams(c *Capture) {
 	if c.Stopped {
-		d.renderHeader(invStyle, "CAPTURE (stopped) [m: show vars] [esc: back]")
+		d.renderHeader(invStyle, "CAPTURE (stopped"+captureTrigger(c)+") [m: show vars] [d: diff] [s: save] [esc: back]")
 	} else {
-		d.renderHeader(capStyle, "CAPTURE (running) [space: stop]")
+		d.renderHeader(capStyle, "CAPTURE (running"+captureTrigger(c)+") [space: stop]")
 	}
 
 	if d.captureOffset >= len(c.Telegrams) {
@@ -244,11 +401,18 @@ func (d *Dashboard) renderCaptureTelegrams(c *Capture) {
 	}
 }
 
+func captureTrigger(c *Capture) string {
+	if c.Trigger == "" {
+		return ""
+	}
+	return ", trigger " + c.Trigger
+}
+
 func (d *Dashboard) renderCaptureVars(c *Capture) {
 	if c.Stopped {
-		d.renderHeader(invStyle, "CAPTURE (stopped) [m: show telegrams] [/: port filter] [esc: back]")
+		d.renderHeader(invStyle, "CAPTURE (stopped"+captureTrigger(c)+") [m: show telegrams] [d: diff] [/ and enter: port filter] [s: save] [esc: back]")
 	} else {
-		d.renderHeader(capStyle, "CAPTURE (running) [space: stop]")
+		d.renderHeader(capStyle, "CAPTURE (running"+captureTrigger(c)+") [space: stop]")
 	}
 
 	_, h := d.screen.Size()
@@ -320,6 +484,12 @@ func (d *Dashboard) Loop(mvbEvents chan Event) {
 	secondsTicker := time.Tick(1 * time.Second)
 	dirty := false
 
+	var replayTicker <-chan time.Time
+	if d.replay != nil {
+		d.replay.Start(&d.stats)
+		replayTicker = time.Tick(50 * time.Millisecond)
+	}
+
 	d.render()
 	for {
 		select {
@@ -333,40 +503,27 @@ func (d *Dashboard) Loop(mvbEvents chan Event) {
 			d.stats.Tick()
 			dirty = true
 
+		case now := <-replayTicker:
+			d.replay.Advance(now, &d.stats)
+			dirty = true
+
 		case ev := <-tcellEvents:
 			switch ev := ev.(type) {
 			case *tcell.EventResize:
 				d.screen.Sync()
 			case *tcell.EventKey:
-				switch {
-				case ev.Key() == tcell.KeyCtrlC || ev.Rune() == 'q' || ev.Rune() == 'Q':
-					d.quit()
-				case ev.Rune() == 'm' || ev.Rune() == 'M':
-					d.captureMode = !d.captureMode
-					d.captureOffset = 0
-				case ev.Rune() == 'p' || ev.Rune() == 'P':
-					d.paused = !d.paused
-				case ev.Rune() == ' ':
-					d.stats.StartStopCapture()
-					if d.stats.Capture != nil && !d.stats.Capture.Stopped {
-						d.captureOffset = 0
-					}
-				case ev.Key() == tcell.KeyESC:
-					if d.portFilter != nil {
-						d.portFilter = nil
-					} else {
-						d.stats.DiscardCapture()
-					}
-				case ev.Key() == tcell.KeyCtrlL:
-					d.screen.Sync()
-				case d.tryScroll(ev):
-				case d.tryPortFilter(ev):
-					d.captureOffset = 0
+				if !d.handleKey(ev) {
+					d.screen.Fini()
+					return
 				}
 			}
 			d.render()
 
-		case ev := <-mvbEvents:
+		case ev, ok := <-mvbEvents:
+			if !ok {
+				d.screen.Fini()
+				return
+			}
 			switch ev := ev.(type) {
 			case *Telegram:
 				d.stats.CountTelegram(ev)
@@ -376,12 +533,103 @@ func (d *Dashboard) Loop(mvbEvents chan Event) {
 				}
 			case Error:
 				d.stats.CountError(ev)
+			case *Overrun:
+				d.stats.CountOverrun(ev)
 			}
 			dirty = true
 		}
 	}
 }
 
+// handleKey runs the command of a key. It returns false to quit.
+func (d *Dashboard) handleKey(ev *tcell.EventKey) bool {
+	d.message = ""
+	switch {
+	case ev.Key() == tcell.KeyCtrlC || ev.Rune() == 'q' || ev.Rune() == 'Q':
+		return false
+	case d.tryDiff(ev):
+	case d.tryChart(ev):
+	case d.typingPortFilter() && ev.Key() == tcell.KeyEnter:
+		// the filter stays, and the keys run their commands again
+		d.portFilter.typing = false
+	case (ev.Rune() == 'd' || ev.Rune() == 'D') && !d.typingPortFilter():
+		if err := d.openDiff(); err != nil {
+			d.message = err.Error()
+		}
+	case (ev.Rune() == 'c' || ev.Rune() == 'C') && d.stats.Capture == nil && !d.typingPortFilter():
+		if err := d.openChart(); err != nil {
+			d.message = err.Error()
+		}
+	case ev.Rune() == 'm' || ev.Rune() == 'M':
+		d.captureMode = !d.captureMode
+		d.captureOffset = 0
+	case ev.Rune() == 'p' || ev.Rune() == 'P':
+		d.paused = !d.paused
+	case ev.Rune() == ' ':
+		d.stats.StartStopCapture()
+		if d.stats.Capture != nil && !d.stats.Capture.Stopped {
+			d.captureOffset = 0
+		}
+	case ev.Rune() == 'l' || ev.Rune() == 'L':
+		if err := d.stats.CaptureLast(); err != nil {
+			d.message = err.Error()
+		} else {
+			d.captureOffset = 0
+		}
+	case (ev.Rune() == 'a' || ev.Rune() == 'A') && d.alarms != nil && !d.typingPortFilter():
+		if n, err := d.alarms.Ack(""); err != nil {
+			d.message = err.Error()
+		} else {
+			d.message = fmt.Sprintf("%d alarms acknowledged", n)
+		}
+	case ev.Rune() == 's' || ev.Rune() == 'S':
+		if path, err := d.stats.SaveCapture(); err != nil {
+			d.message = err.Error()
+		} else {
+			d.message = "saved to " + path
+		}
+	case ev.Key() == tcell.KeyESC:
+		if d.portFilter != nil {
+			d.portFilter = nil
+		} else {
+			d.stats.DiscardCapture()
+		}
+	case ev.Key() == tcell.KeyCtrlL:
+		d.screen.Sync()
+	case d.tryReplay(ev):
+	case d.tryScroll(ev):
+	case d.tryPortFilter(ev):
+		d.captureOffset = 0
+	}
+	return true
+}
+
+func (d *Dashboard) tryReplay(ev *tcell.EventKey) bool {
+	r := d.replay
+	if r == nil {
+		return false
+	}
+	switch {
+	case ev.Key() == tcell.KeyEnter:
+		r.TogglePause(&d.stats)
+	case ev.Rune() == '>':
+		r.SetSpeed(r.Speed() * 2)
+	case ev.Rune() == '<':
+		r.SetSpeed(r.Speed() / 2)
+	case ev.Key() == tcell.KeyRight:
+		r.Skip(10*time.Second, &d.stats)
+	case ev.Key() == tcell.KeyLeft:
+		r.Skip(-10*time.Second, &d.stats)
+	case ev.Rune() == ']':
+		r.Skip(time.Minute, &d.stats)
+	case ev.Rune() == '[':
+		r.Skip(-time.Minute, &d.stats)
+	default:
+		return false
+	}
+	return true
+}
+
 func (d *Dashboard) tryScroll(ev *tcell.EventKey) bool {
 	switch {
 	case d.stats.Capture != nil:
@@ -420,17 +668,17 @@ func (d *Dashboard) tryScrollPort(ev *tcell.EventKey) bool {
 func (d *Dashboard) tryScrollWatchedPorts(ev *tcell.EventKey) bool {
 	switch {
 	case ev.Key() == tcell.KeyPgDn:
-		d.watchedPortsOffset = addWatchedPortOffset(d.watchedPortsOffset, portPageSize, len(d.watchedPorts)-1)
+		d.watchedPortsSelected = addWatchedPortOffset(d.watchedPortsSelected, portPageSize, len(d.watchedPorts)-1)
 	case ev.Key() == tcell.KeyPgUp:
-		d.watchedPortsOffset = addWatchedPortOffset(d.watchedPortsOffset, -portPageSize, len(d.watchedPorts)-1)
+		d.watchedPortsSelected = addWatchedPortOffset(d.watchedPortsSelected, -portPageSize, len(d.watchedPorts)-1)
 	case ev.Key() == tcell.KeyDown:
-		d.watchedPortsOffset = addWatchedPortOffset(d.watchedPortsOffset, 1, len(d.watchedPorts)-1)
+		d.watchedPortsSelected = addWatchedPortOffset(d.watchedPortsSelected, 1, len(d.watchedPorts)-1)
 	case ev.Key() == tcell.KeyUp:
-		d.watchedPortsOffset = addWatchedPortOffset(d.watchedPortsOffset, -1, len(d.watchedPorts)-1)
+		d.watchedPortsSelected = addWatchedPortOffset(d.watchedPortsSelected, -1, len(d.watchedPorts)-1)
 	case ev.Key() == tcell.KeyHome:
-		d.watchedPortsOffset = 0
+		d.watchedPortsSelected = 0
 	case ev.Key() == tcell.KeyEnd:
-		d.watchedPortsOffset = len(d.watchedPorts) - 1
+		d.watchedPortsSelected = len(d.watchedPorts) - 1
 	default:
 		return false
 	}
@@ -461,6 +709,8 @@ func (d *Dashboard) tryScrollCapture(ev *tcell.EventKey) bool {
 
 type portFilter struct {
 	hex string
+	// until enter, the hex digits are added to hex
+	typing bool
 }
 
 func (p *portFilter) port() uint16 {
@@ -471,13 +721,19 @@ func (p *portFilter) port() uint16 {
 	return uint16(n)
 }
 
+// typingPortFilter reports whether the hex digits typed go to the port
+// filter, rather than running the commands of a to f.
+func (d *Dashboard) typingPortFilter() bool {
+	return d.portFilter != nil && d.portFilter.typing
+}
+
 func (d *Dashboard) tryPortFilter(ev *tcell.EventKey) bool {
 	if ev.Rune() == '/' {
-		d.portFilter = &portFilter{}
+		d.portFilter = &portFilter{typing: true}
 		return true
 	}
 	pf := d.portFilter
-	if pf == nil {
+	if pf == nil || !pf.typing {
 		return false
 	}
 	s := string(ev.Rune())
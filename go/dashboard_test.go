@@ -0,0 +1,41 @@
+package mvb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// typeKeys sends each rune of keys to d, as if typed.
+func typeKeys(d *Dashboard, keys string) {
+	for _, r := range keys {
+		d.handleKey(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+}
+
+func TestDashboardPortFilterKeys(t *testing.T) {
+	d := NewDashboard(func() uint64 { return 0 }, nil)
+	d.SetAlarms(newAlarms(nil, nil, time.Now()))
+
+	// the hex digits of the port don't run their commands
+	typeKeys(d, "/0a1")
+	if d.portFilter == nil || d.portFilter.hex != "0a1" || d.message != "" {
+		t.Errorf("got filter %+v, message %q", d.portFilter, d.message)
+	}
+	typeKeys(d, "/0c5")
+	if d.portFilter.hex != "0c5" || d.chart != nil || d.message != "" {
+		t.Errorf("got filter %+v, chart %v, message %q", d.portFilter, d.chart != nil, d.message)
+	}
+	typeKeys(d, "/0d4")
+	if d.portFilter.hex != "0d4" || d.diff != nil {
+		t.Errorf("got filter %+v, diff %v", d.portFilter, d.diff != nil)
+	}
+
+	// after enter, d compares the filtered port
+	d.handleKey(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	typeKeys(d, "d")
+	if d.portFilter.hex != "0d4" || d.diff == nil || d.diff.ports[0].port() != 0x0d4 {
+		t.Errorf("got filter %+v, diff %+v", d.portFilter, d.diff)
+	}
+}
@@ -0,0 +1,293 @@
+package mvb
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// bytes shown in each line of a diff
+const diffLineSize = 16
+
+// ports compared when the dashboard starts, set with -diff
+var initialDiff []uint16
+
+func initDiffFlags() {
+	flag.Func("diff", "start comparing these two ports byte by byte, e.g. 024,054", func(s string) error {
+		a, b, ok := strings.Cut(s, ",")
+		if !ok {
+			return fmt.Errorf("expected two ports separated by a comma")
+		}
+		initialDiff = nil
+		for _, p := range []string{a, b} {
+			port, err := parseTriggerPort(strings.TrimSpace(p))
+			if err != nil {
+				return err
+			}
+			initialDiff = append(initialDiff, port)
+		}
+		return nil
+	})
+}
+
+// diff compares the current values of two ports or, in a stopped capture,
+// the values of a port at two of its changes.
+type diff struct {
+	ports [2]portFilter
+	// the stopped capture compared, or nil for the current values
+	capture *Capture
+	// indexes of the changes compared, in a capture
+	cursors [2]int
+	// port or cursor changed by the keys
+	active int
+}
+
+func newPortDiff(a, b uint16) *diff {
+	return &diff{ports: [2]portFilter{{hex: fmt.Sprintf("%03x", a)}, {hex: fmt.Sprintf("%03x", b)}}}
+}
+
+// diffBytes reports which bytes of a and b differ, including those past the
+// end of the shorter one.
+func diffBytes(a, b []byte) []bool {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	differ := make([]bool, n)
+	for i := range differ {
+		differ[i] = i >= len(a) || i >= len(b) || a[i] != b[i]
+	}
+	return differ
+}
+
+// changedBetween reports which bytes changed in any of the changes after i up
+// to j.
+func changedBetween(changes []VarChange, i, j int) []bool {
+	if i > j {
+		i, j = j, i
+	}
+	var changed []bool
+	for k := i + 1; k <= j; k++ {
+		for l, d := range diffBytes(changes[k-1].Value, changes[k].Value) {
+			if l == len(changed) {
+				changed = append(changed, false)
+			}
+			changed[l] = changed[l] || d
+		}
+	}
+	return changed
+}
+
+// openDiff compares the selected port with itself, to type the other one,
+// or the first and last values of a port in a stopped capture.
+func (d *Dashboard) openDiff() error {
+	c := d.stats.Capture
+	if c != nil && !c.Stopped {
+		return fmt.Errorf("stop the capture first")
+	}
+	port := d.port
+	switch {
+	case d.portFilter != nil:
+		port = d.portFilter.port()
+	case c != nil && len(c.SeenPorts) != 0:
+		port = uint16(c.SeenPorts[0])
+	case c == nil && len(d.watchedPorts) != 0:
+		port = d.watchedPorts[d.watchedPortsSelected].Port
+	}
+	d.diff = newPortDiff(port, port)
+	if c != nil {
+		d.diff.capture = c
+		d.diff.resetCursors(c)
+	} else {
+		d.diff.active = 1
+	}
+	return nil
+}
+
+func (df *diff) resetCursors(c *Capture) {
+	df.cursors = [2]int{0, len(c.Vars[df.ports[0].port()]) - 1}
+	if df.cursors[1] < 0 {
+		df.cursors[1] = 0
+	}
+}
+
+// checkDiff closes a capture diff whose capture was replaced or discarded,
+// with space, l or a trigger, as its cursors index the old one.
+func (d *Dashboard) checkDiff() {
+	if df := d.diff; df != nil && df.capture != nil && df.capture != d.stats.Capture {
+		d.diff = nil
+	}
+}
+
+func (d *Dashboard) tryDiff(ev *tcell.EventKey) bool {
+	d.checkDiff()
+	df := d.diff
+	if df == nil {
+		return false
+	}
+	if ev.Key() == tcell.KeyESC {
+		d.diff = nil
+		return true
+	}
+	if ev.Key() == tcell.KeyTab {
+		df.active = 1 - df.active
+		return true
+	}
+	if _, err := strconv.ParseUint(string(ev.Rune()), 16, 8); err == nil {
+		pf := &df.ports[df.active]
+		if df.capture != nil {
+			pf = &df.ports[0]
+		}
+		pf.hex += string(ev.Rune())
+		if len(pf.hex) > 3 {
+			pf.hex = pf.hex[1:]
+		}
+		if df.capture != nil {
+			df.resetCursors(df.capture)
+		}
+		return true
+	}
+	if df.capture == nil {
+		pf := &df.ports[df.active]
+		switch ev.Key() {
+		case tcell.KeyUp:
+			pf.hex = fmt.Sprintf("%03x", addPortOffset(pf.port(), -1))
+		case tcell.KeyDown:
+			pf.hex = fmt.Sprintf("%03x", addPortOffset(pf.port(), 1))
+		default:
+			return false
+		}
+		return true
+	}
+
+	last := len(df.capture.Vars[df.ports[0].port()]) - 1
+	cursor := &df.cursors[df.active]
+	switch ev.Key() {
+	case tcell.KeyRight:
+		*cursor = addWatchedPortOffset(*cursor, 1, last)
+	case tcell.KeyLeft:
+		*cursor = addWatchedPortOffset(*cursor, -1, last)
+	case tcell.KeyPgDn:
+		*cursor = addWatchedPortOffset(*cursor, 10, last)
+	case tcell.KeyPgUp:
+		*cursor = addWatchedPortOffset(*cursor, -10, last)
+	case tcell.KeyHome:
+		*cursor = 0
+	case tcell.KeyEnd:
+		*cursor = addWatchedPortOffset(0, last, last)
+	default:
+		return false
+	}
+	return true
+}
+
+func (d *Dashboard) renderDiff(df *diff) {
+	if df.capture != nil {
+		d.renderCaptureDiff(df, df.capture)
+		return
+	}
+	d.renderHeader(invStyle, "MVB DIFF [0-9a-f: port] [tab: other port] [up/down: next port] [esc: back]")
+	var values [2][]byte
+	for i := range df.ports {
+		port := df.ports[i].port()
+		values[i] = d.stats.Vars[port]
+		line := fmt.Sprintf("%c port %03x %3d bytes", 'A'+i, port, len(values[i]))
+		if descs := d.watchedDescs(port); descs != "" {
+			line += "  " + descs
+		}
+		d.renderDiffLine(1+i, i == df.active, line)
+	}
+	d.renderByteDiff(3, values, nil)
+}
+
+func (d *Dashboard) renderCaptureDiff(df *diff, c *Capture) {
+	port := df.ports[0].port()
+	d.renderHeader(invStyle, fmt.Sprintf("CAPTURE DIFF port %03x [0-9a-f: port] [tab: other cursor] [left/right: move cursor] [esc: back]", port))
+	changes := c.Vars[port]
+	if len(changes) == 0 {
+		drawText(d.screen, 0, 1, defStyle, fmt.Sprintf("no values of port %03x in the capture", port))
+		return
+	}
+	var values [2][]byte
+	for i := range df.cursors {
+		df.cursors[i] = clamp(df.cursors[i], 0, len(changes)-1)
+		cursor := df.cursors[i]
+		change := changes[cursor]
+		values[i] = change.Value
+		d.renderDiffLine(1+i, i == df.active, fmt.Sprintf("%c #%-5d [%.3fs] %3d bytes",
+			'A'+i, cursor, sampleTimestamp(change.N).Seconds(), len(change.Value)))
+	}
+	d.renderByteDiff(3, values, changedBetween(changes, df.cursors[0], df.cursors[1]))
+}
+
+func (d *Dashboard) renderDiffLine(y int, active bool, s string) {
+	style := defStyle
+	if active {
+		style = invStyle
+	}
+	drawText(d.screen, 0, y, style, s)
+}
+
+// watchedDescs returns the descriptions of the watched variables of port.
+func (d *Dashboard) watchedDescs(port uint16) string {
+	var descs []string
+	for _, w := range d.watchedPorts {
+		if w.Port == port {
+			descs = append(descs, w.Desc)
+		}
+	}
+	return strings.Join(descs, ", ")
+}
+
+// renderByteDiff shows values A and B in hexadecimal, with the bytes that
+// differ highlighted, and those that differ only in between underlined. The
+// offsets are in decimal, as in the port specs.
+func (d *Dashboard) renderByteDiff(y int, values [2][]byte, between []bool) {
+	s := d.screen
+	differ := diffBytes(values[0], values[1])
+	var offsets []string
+	for i, dif := range differ {
+		if dif {
+			offsets = append(offsets, strconv.Itoa(i))
+		}
+	}
+	summary := "equal"
+	if len(offsets) != 0 {
+		summary = "different bytes: " + strings.Join(offsets, " ")
+	}
+	drawText(s, 0, y, defStyle, summary)
+	y++
+
+	header := "     "
+	for i := 0; i < diffLineSize; i++ {
+		header += fmt.Sprintf("%3s", "+"+strconv.Itoa(i))
+	}
+	drawText(s, 0, y, defStyle, header)
+	y++
+	for line := 0; line < len(differ); line += diffLineSize {
+		for i, v := range values {
+			if i == 0 {
+				drawText(s, 0, y, defStyle, fmt.Sprintf("%3d", line))
+			}
+			drawText(s, 4, y, defStyle, string(rune('A'+i)))
+			for j := line; j < line+diffLineSize && j < len(differ); j++ {
+				style := defStyle
+				switch {
+				case differ[j]:
+					style = hiStyle
+				case j < len(between) && between[j]:
+					style = defStyle.Underline(true)
+				}
+				b := "--"
+				if j < len(v) {
+					b = fmt.Sprintf("%02x", v[j])
+				}
+				drawText(s, 6+3*(j-line), y, style, b)
+			}
+			y++
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package mvb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestDiffBytes(t *testing.T) {
+	for _, c := range []struct {
+		a, b []byte
+		want string
+	}{
+		{[]byte{1, 2, 3}, []byte{1, 2, 3}, "[false false false]"},
+		{[]byte{1, 2, 3}, []byte{1, 9, 3, 4}, "[false true false true]"},
+		{nil, []byte{1}, "[true]"},
+	} {
+		if got := fmt.Sprint(diffBytes(c.a, c.b)); got != c.want {
+			t.Errorf("%x %x: got %s, want %s", c.a, c.b, got, c.want)
+		}
+	}
+
+	changes := []VarChange{
+		{1, []byte{0, 0, 0}},
+		{2, []byte{0, 1, 0}},
+		{3, []byte{0, 0, 2}},
+		{4, []byte{5, 0, 2}},
+	}
+	for _, c := range []struct {
+		i, j int
+		want string
+	}{
+		{0, 2, "[false true true]"},
+		{2, 0, "[false true true]"},
+		{1, 1, "[]"},
+		{2, 3, "[true false false]"},
+	} {
+		if got := fmt.Sprint(changedBetween(changes, c.i, c.j)); got != c.want {
+			t.Errorf("%d %d: got %s, want %s", c.i, c.j, got, c.want)
+		}
+	}
+}
+
+func TestCaptureDiffCursors(t *testing.T) {
+	screen := tcell.NewSimulationScreen("")
+	if err := screen.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer screen.Fini()
+	screen.SetSize(120, 40)
+	d := NewDashboard(func() uint64 { return 0 }, nil)
+	d.screen = screen
+
+	c := &Capture{Stopped: true, SeenPorts: []int{0x014}, Vars: make(map[uint16][]VarChange)}
+	for n := uint64(1); n <= 6; n++ {
+		c.Vars[0x014] = append(c.Vars[0x014], VarChange{n, []byte{byte(n)}})
+	}
+	d.stats.Capture = c
+	if err := d.openDiff(); err != nil {
+		t.Fatal(err)
+	}
+	if d.diff.cursors != [2]int{0, 5} {
+		t.Fatalf("got cursors %v", d.diff.cursors)
+	}
+
+	// render clamps the cursors to the changes of the port
+	d.diff.cursors = [2]int{0, 9}
+	d.render()
+	if d.diff.cursors != [2]int{0, 5} {
+		t.Errorf("got cursors %v after render", d.diff.cursors)
+	}
+
+	// space and l replace the capture without going through the diff
+	d.stats.Capture = &Capture{Stopped: true, SeenPorts: []int{0x014}, Vars: map[uint16][]VarChange{0x014: {{1, []byte{1}}}}}
+	d.render()
+	if d.diff != nil {
+		t.Errorf("diff of the replaced capture still open")
+	}
+
+	d.stats.Capture = c
+	d.openDiff()
+	d.stats.DiscardCapture()
+	if d.tryDiff(tcell.NewEventKey(tcell.KeyLeft, 0, tcell.ModNone)) || d.diff != nil {
+		t.Errorf("diff of the discarded capture still open")
+	}
+}
@@ -0,0 +1,256 @@
+package mvb
+
+import (
+	"encoding/binary"
+	"io"
+	"math/bits"
+)
+
+// batches queued between the edge scanner and the decoder
+const edgeBatches = 4
+
+// EdgeStream is a Stream computed from the positions of the signal edges
+// instead of from each sample. The raw buffers are scanned for edges by a
+// separate goroutine, so reading, scanning and decoding run in parallel, and
+// the decoder skips whole runs of equal samples at once.
+type EdgeStream struct {
+	batches chan *edgeBatch
+	free    chan *edgeBatch
+
+	// edges not consumed yet, from edges[head] on
+	edges []uint64
+	head  int
+	// samples scanned so far
+	end uint64
+
+	n uint64
+	v bool
+}
+
+type edgeBatch struct {
+	// positions of the samples whose level differs from the previous one
+	edges []uint64
+	// samples scanned up to the end of this batch
+	end uint64
+	err error
+}
+
+// NewEdgeStream returns a Stream over samples read from r, one byte per
+// sample. See also NewRunStream.
+func NewEdgeStream(r io.Reader) *EdgeStream {
+	s := newEdgeStream()
+	go scanningLoop(NewDoubleBufferedReader(r), s.batches, s.free)
+	return s
+}
+
+func newEdgeStream() *EdgeStream {
+	s := &EdgeStream{
+		batches: make(chan *edgeBatch, edgeBatches),
+		free:    make(chan *edgeBatch, edgeBatches+1),
+	}
+	for i := 0; i < edgeBatches+1; i++ {
+		s.free <- &edgeBatch{}
+	}
+	return s
+}
+
+// scanningLoop finds the edges of each buffer read by d. The level before
+// the first sample is LOW, as for MVBStream.
+func scanningLoop(d *BufferedReader, batches chan *edgeBatch, free chan *edgeBatch) {
+	level := LOW
+	end := uint64(0)
+	for {
+		b := <-free
+		b.edges = b.edges[:0]
+		if err := d.buffer(); err != nil {
+			b.end = end
+			b.err = err
+			batches <- b
+			return
+		}
+		b.edges, level = scanEdges(d.cur.buf, end, level, b.edges)
+		end += uint64(len(d.cur.buf))
+		b.end = end
+		d.disposeBuffer()
+		batches <- b
+	}
+}
+
+// scanEdges appends the positions of the edges in buf, whose first sample is
+// at position pos, and returns the level of its last sample.
+//
+// The samples are compared eight at a time: for each 64-bit word, bit 7 of
+// each byte of low is set if that sample is not signalHigh, and the edges are
+// the bits that differ from those of the previous sample.
+func scanEdges(buf []byte, pos uint64, level bool, edges []uint64) ([]uint64, bool) {
+	const (
+		ones  = 0x0101010101010101
+		low7  = 0x7f7f7f7f7f7f7f7f
+		high1 = 0x8080808080808080
+	)
+	high := uint64(signalHigh) * ones
+	// bit 7 set if the previous sample was low
+	prev := uint64(0)
+	if !level {
+		prev = 0x80
+	}
+	i := 0
+	for ; i+8 <= len(buf); i += 8 {
+		x := binary.LittleEndian.Uint64(buf[i:]) ^ high
+		// bit 7 of each byte set if the byte is not zero
+		low := ((x & low7) + low7 | x) & high1
+		d := low ^ (low<<8 | prev)
+		prev = low >> 56
+		for d != 0 {
+			edges = append(edges, pos+uint64(i+bits.TrailingZeros64(d)/8))
+			d &= d - 1
+		}
+	}
+	level = prev == 0
+	for ; i < len(buf); i++ {
+		if (buf[i] == signalHigh) != level {
+			edges = append(edges, pos+uint64(i))
+			level = !level
+		}
+	}
+	return edges, level
+}
+
+// next waits for the next batch of edges. After the input ends, it returns
+// the error once and then blocks, like BufferedReader.
+func (s *EdgeStream) next() error {
+	b := <-s.batches
+	s.edges = append(s.edges[:0], s.edges[s.head:]...)
+	s.head = 0
+	s.edges = append(s.edges, b.edges...)
+	s.end = b.end
+	err := b.err
+	s.free <- b
+	return err
+}
+
+// require waits until the samples before position p are known. On error,
+// every known sample is consumed.
+func (s *EdgeStream) require(p uint64) error {
+	for s.end < p {
+		if err := s.next(); err != nil {
+			s.advance(s.end)
+			return err
+		}
+	}
+	return nil
+}
+
+// advance consumes the samples before position p, which must be known.
+func (s *EdgeStream) advance(p uint64) {
+	for s.head < len(s.edges) && s.edges[s.head] < p {
+		s.v = !s.v
+		s.head++
+	}
+	s.n = p
+}
+
+// edgeAfter returns the position of the first edge after position p.
+func (s *EdgeStream) edgeAfter(p uint64) (uint64, error) {
+	for {
+		for i := s.head; i < len(s.edges); i++ {
+			if s.edges[i] > p {
+				return s.edges[i], nil
+			}
+		}
+		if err := s.next(); err != nil {
+			s.advance(s.end)
+			return 0, err
+		}
+	}
+}
+
+func (s *EdgeStream) NextSample() (bool, error) {
+	if err := s.require(s.n + 1); err != nil {
+		return false, err
+	}
+	s.advance(s.n + 1)
+	return s.v, nil
+}
+
+func (s *EdgeStream) WaitUntilElapsed(samples int) (bool, error) {
+	if samples < 1 {
+		samples = 1
+	}
+	p := s.n + uint64(samples)
+	if err := s.require(p); err != nil {
+		return false, err
+	}
+	s.advance(p)
+	return s.v, nil
+}
+
+func (s *EdgeStream) WaitUntilElapsedOrEdge(samples int, v1 bool) (bool, error) {
+	if s.v != v1 || samples <= 0 {
+		return s.v, nil
+	}
+	p := s.n + uint64(samples)
+	for {
+		// the level only changes at an edge, so the first pending edge ends
+		// the run of v1
+		if s.head < len(s.edges) && s.edges[s.head] < p {
+			s.advance(s.edges[s.head] + 1)
+			return s.v, nil
+		}
+		if s.end >= p {
+			s.advance(p)
+			return s.v, nil
+		}
+		if err := s.next(); err != nil {
+			s.advance(s.end)
+			return false, err
+		}
+	}
+}
+
+func (s *EdgeStream) WaitUntilIdle(samples int) (bool, error) {
+	for {
+		v1 := s.v
+		v2, err := s.WaitUntilElapsedOrEdge(samples, v1)
+		if err != nil {
+			return false, err
+		}
+		if v2 == v1 {
+			return s.v, nil
+		}
+	}
+}
+
+func (s *EdgeStream) WaitUntil(v bool) (bool, error) {
+	for {
+		if err := s.require(s.n + 1); err != nil {
+			return false, err
+		}
+		atEdge := s.head < len(s.edges) && s.edges[s.head] == s.n
+		if (s.v != atEdge) == v {
+			s.advance(s.n + 1)
+			return s.v, nil
+		}
+		e, err := s.edgeAfter(s.n)
+		if err != nil {
+			return false, err
+		}
+		s.advance(e)
+	}
+}
+
+func (s *EdgeStream) V() bool {
+	return s.v
+}
+
+func (s *EdgeStream) N() uint64 {
+	return s.n
+}
+
+// Annotate does nothing: annotations need the individual samples, see
+// NewInputStream.
+func (s *EdgeStream) Annotate(text string) {}
+
+func (s *EdgeStream) GetSamples() []Sample {
+	return nil
+}
@@ -0,0 +1,100 @@
+package mvb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// decodeAll decodes the samples until the end of the input.
+func decodeAll(s Stream) []Event {
+	events := make(chan Event)
+	go NewDecoder(s).Loop(events)
+	var out []Event
+	for ev := range events {
+		out = append(out, ev)
+		if err, ok := ev.(Error); ok && err.error == io.EOF {
+			break
+		}
+	}
+	return out
+}
+
+func describe(ev Event) string {
+	switch ev := ev.(type) {
+	case *Telegram:
+		return fmt.Sprintf("%d: %v %v", ev.N(), ev.Master, ev.Slave)
+	case Error:
+		return fmt.Sprintf("%d: %v", ev.N(), ev.error)
+	}
+	return fmt.Sprint(ev)
+}
+
+func TestEdgeStreamMatchesMVBStream(t *testing.T) {
+	samples := synthTraffic(200, 0)
+	// corrupt some samples, so that errors are compared too
+	for i := 1000; i < len(samples); i += 7919 {
+		samples[i] ^= signalHigh ^ signalLow
+	}
+
+	want := decodeAll(NewMVBStreamReader(bytes.NewReader(samples)))
+	got := decodeAll(NewEdgeStream(bytes.NewReader(samples)))
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if describe(got[i]) != describe(want[i]) {
+			t.Fatalf("event %d: got %s, want %s", i, describe(got[i]), describe(want[i]))
+		}
+	}
+}
+
+// repeatReader returns its contents n times.
+type repeatReader struct {
+	data []byte
+	r    bytes.Reader
+	n    int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	if r.r.Len() == 0 {
+		if r.n == 0 {
+			return 0, io.EOF
+		}
+		r.n--
+		r.r.Reset(r.data)
+	}
+	return r.r.Read(p)
+}
+
+func benchmarkStream(b *testing.B, idle int, newStream func(io.Reader) Stream) {
+	samples := synthTraffic(1000, idle)
+	b.SetBytes(int64(len(samples)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	decodeAll(newStream(&repeatReader{data: samples, n: b.N}))
+	b.ReportMetric(float64(len(samples)*b.N)/time.Since(start).Seconds(), "samples/s")
+}
+
+func mvbStreamOf(r io.Reader) Stream {
+	return NewMVBStreamReader(r)
+}
+
+func edgeStreamOf(r io.Reader) Stream {
+	return NewEdgeStream(r)
+}
+
+func runStreamOf(r io.Reader) Stream {
+	return NewRunStream(NewSampleRunReader(r))
+}
+
+// The throughput is reported in samples/s, and also in MB/s, as each sample
+// is a byte. "Busy" is back-to-back traffic; "Idle" leaves the bus idle about
+// half of the time.
+func BenchmarkMVBStreamBusy(b *testing.B)  { benchmarkStream(b, 0, mvbStreamOf) }
+func BenchmarkEdgeStreamBusy(b *testing.B) { benchmarkStream(b, 0, edgeStreamOf) }
+func BenchmarkMVBStreamIdle(b *testing.B)  { benchmarkStream(b, 2000, mvbStreamOf) }
+func BenchmarkEdgeStreamIdle(b *testing.B) { benchmarkStream(b, 2000, edgeStreamOf) }
@@ -0,0 +1,913 @@
+package mvb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Expr is a condition over the decoded variables and events, e.g.
+//
+//	port(0x014)[0:2] as int16 < -50
+//	bit(0x025, 3) == 1 && rate(PROCESS_DATA) < 100
+//	error("CRC") || missing() && address() == 0x014
+//
+// It is evaluated after each event is counted in a Stats, and holds only if
+// everything it refers to is known: a port that was never received, a range
+// past the end of the data or fcode() on an error make it false.
+//
+// Functions:
+//
+//	port(ADDR)        data of a port, as bytes
+//	var(DESC)         bytes of a watched variable, by its description
+//	bit(BYTES, N)     bit N % 8 (0 is the least significant) of byte N / 8,
+//	bit(ADDR, N)      as 0 or 1; ADDR stands for port(ADDR)
+//	len(BYTES)        number of bytes
+//	rate()            telegrams/s during the last second, or only those of a
+//	rate(REQUEST)     master request, e.g. PROCESS_DATA
+//	error()           the event is a decoding error, or one of a class, e.g.
+//	error(CLASS)      "crc"
+//	fcode()           fcode and port of the telegram
+//	address()
+//	missing()         the telegram lacks the slave frame its fcode requires
+//
+// Bytes are sliced with [I:J], and converted to numbers with "as TYPE", where
+// TYPE is one of uint8, int8, uint16, int16, uint32, int32, uint64 or int64,
+// big-endian. Numbers support + - * / %, and compare with == != < <= > >=;
+// bytes compare only with == and !=. Conditions combine with && || !.
+type Expr struct {
+	src   string
+	eval  func(env *exprEnv) exprValue
+	ports []uint16
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Ports returns the ports the expression refers to.
+func (e *Expr) Ports() []uint16 {
+	return e.ports
+}
+
+// Eval reports whether the condition holds after ev, which may be nil, was
+// counted in s.
+func (e *Expr) Eval(ev Event, s *Stats) bool {
+	v := e.eval(&exprEnv{ev, s})
+	return v.ok && v.b
+}
+
+type exprEnv struct {
+	ev Event
+	s  *Stats
+}
+
+// exprValue is the result of a node of the given type, if ok; it isn't ok
+// when something it refers to is unknown.
+type exprValue struct {
+	ok    bool
+	b     bool
+	num   float64
+	bytes []byte
+}
+
+var unknown = exprValue{}
+
+// format returns v, of type t, as shown to the user.
+func (v exprValue) format(t exprType) string {
+	switch {
+	case !v.ok:
+		return "?"
+	case t == typeBool:
+		return strconv.FormatBool(v.b)
+	case t == typeNumber:
+		return strconv.FormatFloat(v.num, 'g', -1, 64)
+	}
+	return fmt.Sprintf("%x", v.bytes)
+}
+
+// equal reports whether v and w, of the same type t, are known and equal.
+func (v exprValue) equal(w exprValue, t exprType) bool {
+	switch {
+	case !v.ok || !w.ok:
+		return false
+	case t == typeBool:
+		return v.b == w.b
+	case t == typeNumber:
+		return v.num == w.num
+	}
+	return bytes.Equal(v.bytes, w.bytes)
+}
+
+type exprType uint8
+
+const (
+	typeBool exprType = iota
+	typeNumber
+	typeBytes
+)
+
+func (t exprType) String() string {
+	switch t {
+	case typeBool:
+		return "bool"
+	case typeNumber:
+		return "number"
+	case typeBytes:
+		return "bytes"
+	}
+	panic("unreachable")
+}
+
+// withArticle returns the name of the type for a message, e.g. "a number".
+func (t exprType) withArticle() string {
+	if t == typeBytes {
+		return t.String()
+	}
+	return "a " + t.String()
+}
+
+// ExprError is a syntax or type error, at a byte offset of the expression.
+type ExprError struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+func (e *ExprError) Error() string {
+	return fmt.Sprintf("%s at column %d\n\t%s\n\t%s^", e.Msg, e.Pos+1, e.Expr, strings.Repeat(" ", e.Pos))
+}
+
+// CompileExpr parses and type checks a condition. var() refers to the
+// variables in vars.
+func CompileExpr(src string, vars []RecorderPortSpec) (*Expr, error) {
+	p, n, err := compileExpr(src, vars)
+	if err != nil {
+		return nil, err
+	}
+	if n.typ != typeBool {
+		return nil, p.errorf(0, "the condition is %s, not a bool", n.typ.withArticle())
+	}
+	return &Expr{src: src, eval: n.eval, ports: p.ports}, nil
+}
+
+// compileExpr parses and type checks an expression of any type.
+func compileExpr(src string, vars []RecorderPortSpec) (*exprParser, *exprNode, error) {
+	p := &exprParser{src: src, vars: vars}
+	if err := p.lex(); err != nil {
+		return nil, nil, err
+	}
+	n, err := p.or()
+	if err != nil {
+		return nil, nil, err
+	}
+	if t := p.peek(); t.kind != tokEnd {
+		return nil, nil, p.errorf(t.pos, "unexpected %s", t)
+	}
+	return p, n, nil
+}
+
+type tokenKind uint8
+
+const (
+	tokEnd tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string
+	pos  int
+	num  float64
+}
+
+func (t exprToken) String() string {
+	if t.kind == tokEnd {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// longer first, for <= to be found before <
+var exprOps = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ",", ":"}
+
+type exprParser struct {
+	src    string
+	tokens []exprToken
+	next   int
+	vars   []RecorderPortSpec
+	ports  []uint16
+}
+
+func (p *exprParser) errorf(pos int, format string, args ...interface{}) error {
+	return &ExprError{Expr: p.src, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func isIdentByte(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+func (p *exprParser) lex() error {
+	s := p.src
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(s) && (isIdentByte(s[j], false) || s[j] == '.') {
+				j++
+			}
+			var n float64
+			u, err := strconv.ParseUint(s[i:j], 0, 64)
+			if err == nil {
+				n = float64(u)
+			} else if n, err = strconv.ParseFloat(s[i:j], 64); err != nil {
+				return p.errorf(i, "invalid number %q", s[i:j])
+			}
+			p.tokens = append(p.tokens, exprToken{tokNumber, s[i:j], i, n})
+			i = j
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return p.errorf(i, "unterminated string")
+			}
+			text, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return p.errorf(i, "invalid string")
+			}
+			p.tokens = append(p.tokens, exprToken{tokString, text, i, 0})
+			i = j + 1
+		case isIdentByte(c, true):
+			j := i
+			for j < len(s) && isIdentByte(s[j], false) {
+				j++
+			}
+			p.tokens = append(p.tokens, exprToken{tokIdent, s[i:j], i, 0})
+			i = j
+		default:
+			op := ""
+			for _, o := range exprOps {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return p.errorf(i, "unexpected %q", c)
+			}
+			p.tokens = append(p.tokens, exprToken{tokOp, op, i, 0})
+			i += len(op)
+		}
+	}
+	p.tokens = append(p.tokens, exprToken{tokEnd, "", len(s), 0})
+	return nil
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.next]
+}
+
+// accept consumes the next token if it's the operator or keyword s.
+func (p *exprParser) accept(s string) bool {
+	t := p.peek()
+	if (t.kind == tokOp || t.kind == tokIdent) && t.text == s {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(s string) error {
+	if !p.accept(s) {
+		t := p.peek()
+		return p.errorf(t.pos, "expected %q, got %s", s, t)
+	}
+	return nil
+}
+
+// exprNode is a type checked expression, ready to be evaluated.
+type exprNode struct {
+	typ  exprType
+	pos  int
+	eval func(env *exprEnv) exprValue
+
+	// for bytes, the length if known, or else -1
+	size int
+
+	// for number literals
+	constant bool
+	num      float64
+}
+
+func (p *exprParser) checkType(n *exprNode, want exprType, what string) error {
+	if n.typ != want {
+		return p.errorf(n.pos, "%s needs %s, got %s", what, want.withArticle(), n.typ.withArticle())
+	}
+	return nil
+}
+
+func (p *exprParser) or() (*exprNode, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkType(l, typeBool, "||"); err != nil {
+			return nil, err
+		}
+		if err := p.checkType(r, typeBool, "||"); err != nil {
+			return nil, err
+		}
+		a, b := l.eval, r.eval
+		l = &exprNode{typ: typeBool, pos: l.pos, eval: func(env *exprEnv) exprValue {
+			x, y := a(env), b(env)
+			switch {
+			case x.ok && x.b || y.ok && y.b:
+				return exprValue{ok: true, b: true}
+			case x.ok && y.ok:
+				return exprValue{ok: true}
+			}
+			return unknown
+		}}
+	}
+	return l, nil
+}
+
+func (p *exprParser) and() (*exprNode, error) {
+	l, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		r, err := p.comparison()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkType(l, typeBool, "&&"); err != nil {
+			return nil, err
+		}
+		if err := p.checkType(r, typeBool, "&&"); err != nil {
+			return nil, err
+		}
+		a, b := l.eval, r.eval
+		l = &exprNode{typ: typeBool, pos: l.pos, eval: func(env *exprEnv) exprValue {
+			x, y := a(env), b(env)
+			switch {
+			case x.ok && !x.b || y.ok && !y.b:
+				return exprValue{ok: true}
+			case x.ok && y.ok:
+				return exprValue{ok: true, b: true}
+			}
+			return unknown
+		}}
+	}
+	return l, nil
+}
+
+var exprComparisons = map[string]func(c int) bool{
+	"==": func(c int) bool { return c == 0 },
+	"!=": func(c int) bool { return c != 0 },
+	"<":  func(c int) bool { return c < 0 },
+	"<=": func(c int) bool { return c <= 0 },
+	">":  func(c int) bool { return c > 0 },
+	">=": func(c int) bool { return c >= 0 },
+}
+
+func (p *exprParser) comparison() (*exprNode, error) {
+	l, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	cmp, ok := exprComparisons[t.text]
+	if t.kind != tokOp || !ok {
+		return l, nil
+	}
+	p.next++
+	r, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind == tokOp && exprComparisons[next.text] != nil {
+		return nil, p.errorf(next.pos, "comparisons can't be chained, use &&")
+	}
+
+	if l.typ != r.typ {
+		msg := fmt.Sprintf("can't compare %s with %s", l.typ.withArticle(), r.typ.withArticle())
+		if l.typ == typeBytes || r.typ == typeBytes {
+			msg += `, convert the bytes with "as", e.g. as uint16`
+		}
+		return nil, p.errorf(t.pos, "%s", msg)
+	}
+	if l.typ != typeNumber && t.text != "==" && t.text != "!=" {
+		return nil, p.errorf(t.pos, "%s can't be compared with %s", l.typ, t.text)
+	}
+	a, b := l.eval, r.eval
+	var compare func(x, y exprValue) int
+	switch l.typ {
+	case typeBool:
+		compare = func(x, y exprValue) int {
+			if x.b == y.b {
+				return 0
+			}
+			return 1
+		}
+	case typeNumber:
+		compare = func(x, y exprValue) int {
+			switch {
+			case x.num < y.num:
+				return -1
+			case x.num > y.num:
+				return 1
+			}
+			return 0
+		}
+	case typeBytes:
+		compare = func(x, y exprValue) int {
+			return bytes.Compare(x.bytes, y.bytes)
+		}
+	}
+	return &exprNode{typ: typeBool, pos: l.pos, eval: func(env *exprEnv) exprValue {
+		x, y := a(env), b(env)
+		if !x.ok || !y.ok {
+			return unknown
+		}
+		return exprValue{ok: true, b: cmp(compare(x, y))}
+	}}, nil
+}
+
+var exprArithmetic = map[string]func(x, y float64) (float64, bool){
+	"+": func(x, y float64) (float64, bool) { return x + y, true },
+	"-": func(x, y float64) (float64, bool) { return x - y, true },
+	"*": func(x, y float64) (float64, bool) { return x * y, true },
+	"/": func(x, y float64) (float64, bool) { return x / y, y != 0 },
+	"%": func(x, y float64) (float64, bool) { return math.Mod(x, y), y != 0 },
+}
+
+func (p *exprParser) sum() (*exprNode, error) {
+	return p.arithmetic(p.product, "+", "-")
+}
+
+func (p *exprParser) product() (*exprNode, error) {
+	return p.arithmetic(p.unary, "*", "/", "%")
+}
+
+func (p *exprParser) arithmetic(operand func() (*exprNode, error), ops ...string) (*exprNode, error) {
+	l, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		found := false
+		for _, op := range ops {
+			found = found || t.kind == tokOp && t.text == op
+		}
+		if !found {
+			return l, nil
+		}
+		p.next++
+		r, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkType(l, typeNumber, t.text); err != nil {
+			return nil, err
+		}
+		if err := p.checkType(r, typeNumber, t.text); err != nil {
+			return nil, err
+		}
+		a, b, f := l.eval, r.eval, exprArithmetic[t.text]
+		l = &exprNode{typ: typeNumber, pos: l.pos, eval: func(env *exprEnv) exprValue {
+			x, y := a(env), b(env)
+			if !x.ok || !y.ok {
+				return unknown
+			}
+			n, ok := f(x.num, y.num)
+			return exprValue{ok: ok, num: n}
+		}}
+	}
+}
+
+func (p *exprParser) unary() (*exprNode, error) {
+	t := p.peek()
+	switch {
+	case p.accept("-"):
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkType(n, typeNumber, "-"); err != nil {
+			return nil, err
+		}
+		a := n.eval
+		return &exprNode{typ: typeNumber, pos: t.pos, constant: n.constant, num: -n.num, eval: func(env *exprEnv) exprValue {
+			x := a(env)
+			x.num = -x.num
+			return x
+		}}, nil
+	case p.accept("!"):
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkType(n, typeBool, "!"); err != nil {
+			return nil, err
+		}
+		a := n.eval
+		return &exprNode{typ: typeBool, pos: t.pos, eval: func(env *exprEnv) exprValue {
+			x := a(env)
+			x.b = !x.b
+			return x
+		}}, nil
+	}
+	return p.postfix()
+}
+
+var exprIntTypes = map[string]struct {
+	size   int
+	signed bool
+}{
+	"uint8":  {1, false},
+	"int8":   {1, true},
+	"uint16": {2, false},
+	"int16":  {2, true},
+	"uint32": {4, false},
+	"int32":  {4, true},
+	"uint64": {8, false},
+	"int64":  {8, true},
+}
+
+func (p *exprParser) postfix() (*exprNode, error) {
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch {
+		case p.accept("["):
+			if n, err = p.slice(n, t.pos); err != nil {
+				return nil, err
+			}
+		case p.accept("as"):
+			if n, err = p.as(n, t.pos); err != nil {
+				return nil, err
+			}
+		default:
+			return n, nil
+		}
+	}
+}
+
+// constant parses an integer literal, between min and max.
+func (p *exprParser) constant(what string, min, max int) (int, error) {
+	t := p.peek()
+	n, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	if !n.constant || n.num != math.Trunc(n.num) {
+		return 0, p.errorf(t.pos, "%s must be an integer literal", what)
+	}
+	if n.num < float64(min) || n.num > float64(max) {
+		return 0, p.errorf(t.pos, "%s must be between %d and %d", what, min, max)
+	}
+	return int(n.num), nil
+}
+
+func (p *exprParser) slice(n *exprNode, pos int) (*exprNode, error) {
+	if n.typ != typeBytes {
+		return nil, p.errorf(pos, "%s can't be sliced, only bytes", n.typ.withArticle())
+	}
+	max := 1 << 12
+	if n.size >= 0 {
+		max = n.size
+	}
+	i, err := p.constant("the start of the range", 0, max-1)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	j, err := p.constant("the end of the range", i+1, max)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	a := n.eval
+	return &exprNode{typ: typeBytes, pos: n.pos, size: j - i, eval: func(env *exprEnv) exprValue {
+		x := a(env)
+		if !x.ok || len(x.bytes) < j {
+			return unknown
+		}
+		x.bytes = x.bytes[i:j]
+		return x
+	}}, nil
+}
+
+func (p *exprParser) as(n *exprNode, pos int) (*exprNode, error) {
+	t := p.peek()
+	it, ok := exprIntTypes[t.text]
+	if t.kind != tokIdent || !ok {
+		return nil, p.errorf(t.pos, "expected a type such as uint16 or int16, got %s", t)
+	}
+	p.next++
+	if n.typ != typeBytes {
+		return nil, p.errorf(pos, "only bytes can be converted, not %s", n.typ.withArticle())
+	}
+	if n.size >= 0 && n.size != it.size {
+		return nil, p.errorf(pos, "%s needs %d bytes, got %d", t.text, it.size, n.size)
+	}
+	a := n.eval
+	return &exprNode{typ: typeNumber, pos: n.pos, eval: func(env *exprEnv) exprValue {
+		x := a(env)
+		if !x.ok || len(x.bytes) != it.size {
+			return unknown
+		}
+		var b [8]byte
+		copy(b[8-it.size:], x.bytes)
+		u := binary.BigEndian.Uint64(b[:])
+		if it.signed {
+			// sign extension
+			shift := 64 - 8*it.size
+			return exprValue{ok: true, num: float64(int64(u<<shift) >> shift)}
+		}
+		return exprValue{ok: true, num: float64(u)}
+	}}, nil
+}
+
+func (p *exprParser) primary() (*exprNode, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.next++
+		v := exprValue{ok: true, num: t.num}
+		return &exprNode{typ: typeNumber, pos: t.pos, constant: true, num: t.num, eval: func(*exprEnv) exprValue {
+			return v
+		}}, nil
+	case tokIdent:
+		p.next++
+		if t.text == "true" || t.text == "false" {
+			v := exprValue{ok: true, b: t.text == "true"}
+			return &exprNode{typ: typeBool, pos: t.pos, eval: func(*exprEnv) exprValue {
+				return v
+			}}, nil
+		}
+		if p.peek().text != "(" {
+			return nil, p.errorf(t.pos, "unknown name %q", t.text)
+		}
+		return p.call(t)
+	case tokOp:
+		if p.accept("(") {
+			n, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		}
+	}
+	return nil, p.errorf(t.pos, "unexpected %s", t)
+}
+
+func (p *exprParser) call(name exprToken) (*exprNode, error) {
+	p.next++ // (
+	node := &exprNode{pos: name.pos}
+	var err error
+	switch name.text {
+	case "port":
+		node.typ, node.size = typeBytes, -1
+		node.eval, err = p.portArg()
+	case "var":
+		node.typ = typeBytes
+		node.eval, node.size, err = p.varArg()
+	case "bit":
+		node.typ = typeNumber
+		node.eval, err = p.bitArgs()
+	case "len":
+		node.typ = typeNumber
+		node.eval, err = p.lenArg()
+	case "rate":
+		node.typ = typeNumber
+		node.eval, err = p.rateArg()
+	case "error":
+		node.typ = typeBool
+		node.eval, err = p.errorArg()
+	case "fcode", "address", "missing":
+		node.typ = typeNumber
+		if name.text == "missing" {
+			node.typ = typeBool
+		}
+		node.eval = telegramFuncs[name.text]
+	default:
+		return nil, p.errorf(name.pos, "unknown function %q", name.text)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+var telegramFuncs = map[string]func(env *exprEnv) exprValue{
+	"fcode": func(env *exprEnv) exprValue {
+		if t, ok := env.ev.(*Telegram); ok {
+			return exprValue{ok: true, num: float64(t.Master.FCode)}
+		}
+		return unknown
+	},
+	"address": func(env *exprEnv) exprValue {
+		if t, ok := env.ev.(*Telegram); ok {
+			return exprValue{ok: true, num: float64(t.Master.Address)}
+		}
+		return unknown
+	},
+	"missing": func(env *exprEnv) exprValue {
+		if t, ok := env.ev.(*Telegram); ok {
+			return exprValue{ok: true, b: t.Slave == nil && fcodes[t.Master.FCode].SlaveFrameSource != SFS_NONE}
+		}
+		return exprValue{ok: true}
+	},
+}
+
+func (p *exprParser) port(port uint16) func(env *exprEnv) exprValue {
+	p.ports = append(p.ports, port)
+	return func(env *exprEnv) exprValue {
+		v, ok := env.s.Vars[port]
+		return exprValue{ok: ok, bytes: v}
+	}
+}
+
+func (p *exprParser) portArg() (func(env *exprEnv) exprValue, error) {
+	port, err := p.constant("the port", 0, 0xfff)
+	if err != nil {
+		return nil, err
+	}
+	return p.port(uint16(port)), nil
+}
+
+func (p *exprParser) varArg() (func(env *exprEnv) exprValue, int, error) {
+	t := p.peek()
+	if t.kind != tokString {
+		return nil, 0, p.errorf(t.pos, "var needs the description of a watched variable, in quotes")
+	}
+	p.next++
+	var descs, ports []string
+	var found *RecorderPortSpec
+	for k := range p.vars {
+		spec := &p.vars[k]
+		if spec.Desc == t.text {
+			found = spec
+			ports = append(ports, fmt.Sprintf("%03x", spec.Port))
+		}
+		if q := strconv.Quote(spec.Desc); !containsString(descs, q) {
+			descs = append(descs, q)
+		}
+	}
+	if len(ports) > 1 {
+		return nil, 0, p.errorf(t.pos, "ambiguous variable %q, watched on ports %s", t.text, strings.Join(ports, ", "))
+	}
+	if found != nil {
+		port := p.port(found.Port)
+		i, j := found.I, found.J
+		size := -1
+		if i != -1 {
+			size = j - i
+		}
+		return func(env *exprEnv) exprValue {
+			v := port(env)
+			if !v.ok || i != -1 && len(v.bytes) < j {
+				return unknown
+			}
+			v.bytes = slice(v.bytes, i, j)
+			return v
+		}, size, nil
+	}
+	if len(descs) == 0 {
+		return nil, 0, p.errorf(t.pos, "unknown variable %q, no variables are watched", t.text)
+	}
+	return nil, 0, p.errorf(t.pos, "unknown variable %q, expected one of %s", t.text, strings.Join(descs, ", "))
+}
+
+func (p *exprParser) bitArgs() (func(env *exprEnv) exprValue, error) {
+	data, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	var value func(env *exprEnv) exprValue
+	max := 1<<12*8 - 1
+	switch {
+	case data.typ == typeNumber && data.constant:
+		if data.num < 0 || data.num > 0xfff || data.num != math.Trunc(data.num) {
+			return nil, p.errorf(data.pos, "the port must be between 0 and 4095")
+		}
+		value = p.port(uint16(data.num))
+	case data.typ == typeBytes:
+		value = data.eval
+		if data.size >= 0 {
+			max = data.size*8 - 1
+		}
+	default:
+		return nil, p.errorf(data.pos, "bit needs bytes or a port number")
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	bit, err := p.constant("the bit", 0, max)
+	if err != nil {
+		return nil, err
+	}
+	return func(env *exprEnv) exprValue {
+		x := value(env)
+		if !x.ok || len(x.bytes) <= bit/8 {
+			return unknown
+		}
+		return exprValue{ok: true, num: float64(x.bytes[bit/8] >> (bit % 8) & 1)}
+	}, nil
+}
+
+func (p *exprParser) lenArg() (func(env *exprEnv) exprValue, error) {
+	data, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkType(data, typeBytes, "len"); err != nil {
+		return nil, err
+	}
+	a := data.eval
+	return func(env *exprEnv) exprValue {
+		x := a(env)
+		return exprValue{ok: x.ok, num: float64(len(x.bytes))}
+	}, nil
+}
+
+func (p *exprParser) rateArg() (func(env *exprEnv) exprValue, error) {
+	t := p.peek()
+	if t.text == ")" {
+		return func(env *exprEnv) exprValue {
+			rate := env.s.Rate()
+			return exprValue{ok: true, num: float64(rate[len(rate)-1])}
+		}, nil
+	}
+	var names []string
+	for mr := MasterRequest(0); mr < MR_AMOUNT; mr++ {
+		if t.kind == tokIdent && t.text == mr.String() {
+			p.next++
+			mr := mr
+			return func(env *exprEnv) exprValue {
+				rate := env.s.MRRate(mr)
+				return exprValue{ok: true, num: float64(rate[len(rate)-1])}
+			}, nil
+		}
+		names = append(names, mr.String())
+	}
+	return nil, p.errorf(t.pos, "rate needs a master request, one of %s", strings.Join(names, ", "))
+}
+
+func (p *exprParser) errorArg() (func(env *exprEnv) exprValue, error) {
+	t := p.peek()
+	class := ""
+	switch {
+	case t.text == ")" && t.kind == tokOp:
+	case t.kind == tokString && isErrorClass(strings.ToLower(t.text)):
+		class = strings.ToLower(t.text)
+		p.next++
+	default:
+		var classes []string
+		for i, c := range errorClasses {
+			if i == 0 || errorClasses[i-1].class != c.class {
+				classes = append(classes, strconv.Quote(c.class))
+			}
+		}
+		classes = append(classes, `"other"`)
+		return nil, p.errorf(t.pos, "error needs a class in quotes, one of %s", strings.Join(classes, ", "))
+	}
+	return func(env *exprEnv) exprValue {
+		err, ok := env.ev.(Error)
+		return exprValue{ok: true, b: ok && (class == "" || err.Class() == class)}
+	}, nil
+}
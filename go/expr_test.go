@@ -0,0 +1,112 @@
+package mvb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExpr(t *testing.T) {
+	vars := []RecorderPortSpec{{0x014, 0, 2, "speed"}, {0x025, -1, -1, "doors"}}
+	s := NewStats()
+	s.CountTelegram(testTelegram(1, 0x014, "ffc00001"))
+	s.CountTelegram(testTelegram(2, 0x025, "0801"))
+	s.CountTelegram(testTelegram(3, 0x0ee, ""))
+	s.Tick()
+	tg := testTelegram(4, 0x014, "ffc00001")
+	crc := Error{error: errors.New("CRC mismatch"), n: 5}
+
+	for _, c := range []struct {
+		expr string
+		ev   Event
+		want bool
+	}{
+		{"port(0x014)[0:2] as int16 < -50", nil, true},
+		{"port(0x014)[0:2] as int16 == -64", nil, true},
+		{"port(0x014)[0:2] as uint16 == 0xffc0", nil, true},
+		{"port(0x014) as uint32 == 0xffc00001", nil, true},
+		{"var(\"speed\") as int16 < -50", nil, true},
+		{"var(\"doors\")[1:2] as uint8 == 1", nil, true},
+		{"port(0x014)[2:4] == port(0x025)", nil, false},
+		{"port(0x014)[2:4] != port(0x025)", nil, true},
+		{"bit(0x025, 3) == 1", nil, true},
+		{"bit(0x025, 2) == 1", nil, false},
+		{"bit(0x025, 8) == 1", nil, true},
+		{"bit(var(\"doors\"), 3) == 1", nil, true},
+		{"len(port(0x025)) == 2", nil, true},
+		{"rate(PROCESS_DATA) == 3", nil, true},
+		{"rate(DEVICE_STATUS) < 1 && rate() >= 3", nil, true},
+		{"(1 + 2) * 3 - 8 / 4 == 7 && 7 % 4 == 3", nil, true},
+		{"!(1 > 2) && -1 < 0", nil, true},
+		{"error(\"CRC\")", crc, true},
+		{"error(\"crc\")", tg, false},
+		{"error()", crc, true},
+		{"error(\"bit\")", crc, false},
+		{"fcode() == 1 && address() == 0x014", tg, true},
+		{"fcode() == 1", crc, false},
+		{"missing()", testTelegram(6, 0x0ee, ""), true},
+		{"missing()", tg, false},
+
+		// unknown values make the condition false
+		{"port(0x020) as uint8 == 0", nil, false},
+		{"port(0x025)[2:3] == port(0x025)[2:3]", nil, false},
+		{"port(0x014) as uint16 == 0", nil, false},
+		{"bit(0x025, 16) == 0", nil, false},
+		{"1 / 0 == 0", nil, false},
+		{"!(port(0x020) as uint8 == 0)", nil, false},
+		{"port(0x020) as uint8 == 0 || true", nil, true},
+		{"port(0x020) as uint8 == 0 && false", nil, false},
+	} {
+		e, err := CompileExpr(c.expr, vars)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		if got := e.Eval(c.ev, &s); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	vars := []RecorderPortSpec{{0x014, 0, 2, "speed"}, {0x025, 0, 6, "alarm M1"}, {0x055, 0, 6, "alarm M1"}}
+	for expr, want := range map[string]string{
+		"port(0x014)[0:3] as int16 < -50": "int16 needs 2 bytes, got 3 at column 18",
+		"port(0x014) < -50":               `can't compare bytes with a number, convert the bytes with "as", e.g. as uint16 at column 13`,
+		"port(0x014)[0:2] as int17 < 0":   `expected a type such as uint16 or int16, got "int17" at column 21`,
+		"port(0x1000) == port(1)":         "the port must be between 0 and 4095 at column 6",
+		"port(x) == port(1)":              `unknown name "x" at column 6`,
+		"var(\"brake\") == port(1)":       `unknown variable "brake", expected one of "speed", "alarm M1" at column 5`,
+		"var(\"alarm M1\") == port(1)":    `ambiguous variable "alarm M1", watched on ports 025, 055 at column 5`,
+		"var(\"speed\")[1:3] == port(1)":  "the end of the range must be between 2 and 2 at column 16",
+		"bit(0x025, 3)":                   "the condition is a number, not a bool at column 1",
+		"bit(var(\"speed\"), 16) == 1":    "the bit must be between 0 and 15 at column 19",
+		"rate(FOO) < 1":                   "rate needs a master request, one of PROCESS_DATA, RESERVED",
+		"error(\"parity\")":               `error needs a class in quotes, one of "crc", "start_of_frame"`,
+		"1 < 2 < 3":                       "comparisons can't be chained, use && at column 7",
+		"true < false":                    "bool can't be compared with < at column 6",
+		"1 + true == 2":                   "+ needs a number, got a bool at column 5",
+		"!1":                              "! needs a bool, got a number at column 2",
+		"1 == 1 &&":                       "unexpected end of expression at column 10",
+		"foo(1)":                          `unknown function "foo" at column 1`,
+		"(1 == 1":                         `expected ")", got end of expression at column 8`,
+		"1 == 1 $":                        `unexpected '$' at column 8`,
+		"error(\"crc)":                    "unterminated string at column 7",
+		"0x1g == 1":                       `invalid number "0x1g" at column 1`,
+	} {
+		_, err := CompileExpr(expr, vars)
+		if err == nil {
+			t.Errorf("%s: no error", expr)
+			continue
+		}
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %q, want %q", expr, err, want)
+		}
+	}
+
+	// the position is shown below the expression
+	_, err := CompileExpr("1 < 2 < 3", nil)
+	if want := "\n\t1 < 2 < 3\n\t      ^"; !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("got %q, want suffix %q", err, want)
+	}
+}
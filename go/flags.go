@@ -1,12 +1,38 @@
 package mvb
 
-import "flag"
+import (
+	"flag"
+	"log"
+	"os"
+)
 
 var VerboseFlag bool
 
+// file the log messages are written to, instead of stderr
+var LogFlag string
+
 func InitFlags() {
 	initInputFlags()
 	initDashboardFlags()
+	initDiffFlags()
+	initReplayFlags()
+	initCaptureFlags()
+	initTriggerFlags()
+	initRecorderFlags()
+	initAlarmFlags()
+	initMetricsFlags()
+	initServerFlags()
+	initGRPCFlags()
+	initBusFlags()
 	flag.BoolVar(&VerboseFlag, "v", false, "verbose")
+	flag.StringVar(&LogFlag, "log", "", "write log messages to this file instead of stderr")
 	flag.Parse()
+
+	if LogFlag != "" {
+		fp, err := os.OpenFile(LogFlag, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.SetOutput(fp)
+	}
 }
@@ -0,0 +1,182 @@
+// Package gen encodes MVB frames into Manchester-coded sample streams, one
+// byte per sample, as read by the decoder.
+package gen
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"math/rand"
+	"time"
+
+	"mvb"
+)
+
+// Encoder writes the samples of a signal at any sample rate. Levels are
+// positioned in time and rounded to the nearest sample, so that bit times
+// that are not a whole number of samples don't drift.
+type Encoder struct {
+	w    *bufio.Writer
+	rate float64
+
+	// byte values written for each level
+	High byte
+	Low  byte
+
+	// idle time between the end of a master frame and its slave frame
+	ReplyGap time.Duration
+
+	// seconds since the start
+	t float64
+	// samples written
+	n uint64
+
+	// maximum displacement of each level change, in seconds
+	jitter float64
+	rnd    *rand.Rand
+}
+
+func NewEncoder(w io.Writer, sampleRate float64) *Encoder {
+	return &Encoder{
+		w:        bufio.NewWriterSize(w, 1<<16),
+		rate:     sampleRate,
+		High:     0xff,
+		Low:      0xfe,
+		ReplyGap: 5 * time.Microsecond,
+	}
+}
+
+// N returns the number of samples written.
+func (e *Encoder) N() uint64 {
+	return e.n
+}
+
+// Time returns the duration of the signal written.
+func (e *Encoder) Time() time.Duration {
+	return time.Duration(e.t * float64(time.Second))
+}
+
+// Level holds the signal at v for d seconds.
+func (e *Encoder) Level(v bool, d float64) {
+	b := e.Low
+	if v {
+		b = e.High
+	}
+	e.t += d
+	t := e.t
+	if e.jitter > 0 {
+		t += (2*e.rnd.Float64() - 1) * e.jitter
+	}
+	end := uint64(math.Round(t * e.rate))
+	for ; e.n < end; e.n++ {
+		// write errors are kept by the bufio.Writer, and returned by Flush
+		e.w.WriteByte(b)
+	}
+}
+
+// SetJitter displaces each following level change by a random time of up to
+// max, without accumulating. A zero max disables the jitter.
+func (e *Encoder) SetJitter(max time.Duration, rnd *rand.Rand) {
+	e.jitter = max.Seconds()
+	e.rnd = rnd
+}
+
+// Idle holds the bus idle (HIGH) for d.
+func (e *Encoder) Idle(d time.Duration) {
+	e.Level(mvb.HIGH, d.Seconds())
+}
+
+// 3.3.1.2 Bit encoding
+func (e *Encoder) Symbol(s mvb.Symbol) {
+	const half = mvb.BT / 2
+	switch s {
+	case mvb.BIT_0:
+		e.Level(mvb.LOW, half)
+		e.Level(mvb.HIGH, half)
+	case mvb.BIT_1:
+		e.Level(mvb.HIGH, half)
+		e.Level(mvb.LOW, half)
+	case mvb.NH:
+		e.Level(mvb.HIGH, 2*half)
+	case mvb.NL:
+		e.Level(mvb.LOW, 2*half)
+	}
+}
+
+func (e *Encoder) Byte(b byte) {
+	for i := 7; i >= 0; i-- {
+		e.Symbol(mvb.Symbol(b >> i & 1))
+	}
+}
+
+// Symbols writes a sequence of symbols.
+func (e *Encoder) Symbols(symbols []mvb.Symbol) {
+	for _, s := range symbols {
+		e.Symbol(s)
+	}
+}
+
+// Frame writes the start bit, the start delimiter, the raw bytes of a frame
+// (check sequences included) and the end delimiter.
+func (e *Encoder) Frame(master bool, raw []byte) {
+	e.Symbols(FrameSymbols(master, raw))
+}
+
+// FrameSymbols returns the symbols of a frame, as written by Frame.
+func FrameSymbols(master bool, raw []byte) []mvb.Symbol {
+	// 3.3.1.4 Start Bit
+	symbols := []mvb.Symbol{mvb.BIT_1}
+	symbols = append(symbols, mvb.StartDelimiter(master)...)
+	for _, b := range raw {
+		for i := 7; i >= 0; i-- {
+			symbols = append(symbols, mvb.Symbol(b>>i&1))
+		}
+	}
+	// 3.3.1.6 End Delimiter
+	return append(symbols, mvb.NL, mvb.NH)
+}
+
+// WithCheckSequences returns data with a check sequence after every 8 bytes,
+// as sent on the bus.
+func WithCheckSequences(data []byte) []byte {
+	var raw []byte
+	for i := 0; i < len(data); i += 8 {
+		chunk := data[i:]
+		if len(chunk) > 8 {
+			chunk = chunk[:8]
+		}
+		raw = append(raw, chunk...)
+		raw = append(raw, mvb.CheckSequence(chunk))
+	}
+	return raw
+}
+
+// MasterBytes returns the two bytes of a master frame, without the check
+// sequence.
+func MasterBytes(m *mvb.MasterFrame) []byte {
+	return []byte{m.FCode<<4 | byte(m.Address>>8&0xf), byte(m.Address)}
+}
+
+// 3.4.1.1 Master Frame format
+func (e *Encoder) Master(m *mvb.MasterFrame) {
+	e.Frame(true, WithCheckSequences(MasterBytes(m)))
+}
+
+// 3.4.1.2 Slave Frame format
+func (e *Encoder) Slave(s *mvb.SlaveFrame) {
+	e.Frame(false, WithCheckSequences(s.Data()))
+}
+
+// Telegram writes the master frame and, after ReplyGap, the slave frame if
+// there is one.
+func (e *Encoder) Telegram(t *mvb.Telegram) {
+	e.Master(t.Master)
+	if t.Slave != nil {
+		e.Idle(e.ReplyGap)
+		e.Slave(t.Slave)
+	}
+}
+
+func (e *Encoder) Flush() error {
+	return e.w.Flush()
+}
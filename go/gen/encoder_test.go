@@ -0,0 +1,67 @@
+package gen
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"mvb"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	telegrams, err := ParseTelegrams(strings.NewReader(DefaultTelegrams))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a telegram without reply is reported with the next master frame
+	telegrams = append(telegrams, &mvb.Telegram{Master: &mvb.MasterFrame{FCode: 2, Address: 0x123}}, telegrams[0])
+
+	var samples bytes.Buffer
+	e := NewEncoder(&samples, mvb.SampleRate)
+	for _, tg := range telegrams {
+		e.Idle(20 * time.Microsecond)
+		e.Telegram(tg)
+	}
+	e.Idle(20 * time.Microsecond)
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if e.N() != uint64(samples.Len()) {
+		t.Errorf("N() = %d, wrote %d samples", e.N(), samples.Len())
+	}
+
+	events := make(chan mvb.Event)
+	go mvb.NewDecoder(mvb.NewMVBStreamReader(&samples)).Loop(events)
+	for i, want := range telegrams {
+		ev := <-events
+		got, ok := ev.(*mvb.Telegram)
+		if !ok {
+			t.Fatalf("telegram %d: got %v", i, ev)
+		}
+		if *got.Master != *want.Master || got.Slave.String() != want.Slave.String() {
+			t.Errorf("telegram %d: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestSampleRate(t *testing.T) {
+	// 1.5 samples per half bit time: levels are rounded without drifting
+	var samples bytes.Buffer
+	e := NewEncoder(&samples, 4_500_000)
+	for i := 0; i < 1000; i++ {
+		e.Byte(0x55)
+	}
+	e.Flush()
+	if samples.Len() != 1000*8*3 {
+		t.Errorf("got %d samples, want %d", samples.Len(), 1000*8*3)
+	}
+}
+
+func TestParseTelegrams(t *testing.T) {
+	for _, line := range []string{"4 390 00", "x 390", "4 1000", "4 390 zz", "4"} {
+		if _, err := ParseTelegrams(strings.NewReader(line)); err == nil {
+			t.Errorf("%q: no error", line)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package gen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mvb"
+)
+
+type FaultKind string
+
+const (
+	// one bit of the frame (check sequences included) is inverted
+	BitFlip FaultKind = "bit_flip"
+	// every level change of the telegram is displaced by a random time; the
+	// errors are not predicted, as the decoder loses the bit timing as soon
+	// as an edge moves by a whole sample
+	Jitter FaultKind = "jitter"
+	// the frame is cut at a symbol boundary, and the bus goes idle
+	Truncate FaultKind = "truncate"
+	// a LOW pulse shorter than BT/4 in the idle time before the telegram
+	Glitch FaultKind = "glitch"
+	// the slave frame is not sent
+	MissingReply FaultKind = "missing_reply"
+	// the master frame is sent twice
+	DuplicateMaster FaultKind = "duplicate_master"
+)
+
+var FaultKinds = []FaultKind{BitFlip, Jitter, Truncate, Glitch, MissingReply, DuplicateMaster}
+
+func parseFaultKind(s string) (FaultKind, error) {
+	for _, k := range FaultKinds {
+		if string(k) == s {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("invalid fault %q", s)
+}
+
+// Fault is the ground truth of a fault written by an Injector.
+type Fault struct {
+	Kind FaultKind `json:"fault"`
+	// index of the telegram, counting from 0
+	Telegram int `json:"telegram"`
+	// first sample of the telegram, glitch included
+	N    uint64  `json:"n"`
+	Time float64 `json:"time"`
+	// master or slave, for faults that affect one frame
+	Frame  string `json:"frame,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	// classes of the errors the decoder should report, as returned by
+	// mvb.Error.Class, or nil if they can't be predicted
+	Expect []string `json:"expect"`
+}
+
+// ScriptedFault injects a fault in the given telegram. An empty Frame picks
+// the frame at random.
+type ScriptedFault struct {
+	Telegram int
+	Kind     FaultKind
+	Frame    string
+}
+
+// Injector writes telegrams with faults, either scripted or at random with
+// the probabilities in Rates, and reports each fault to OnFault.
+//
+// The expected errors assume that the decoder is not waiting for a reply
+// when the telegram starts, i.e. that the previous telegram was complete.
+// Random faults are never injected in two telegrams in a row for this reason.
+type Injector struct {
+	e   *Encoder
+	rnd *rand.Rand
+
+	// probability of each fault, per telegram
+	Rates map[FaultKind]float64
+	// scripted faults, sorted by telegram
+	Script []ScriptedFault
+	// maximum displacement of the level changes with Jitter
+	JitterMax time.Duration
+	// idle time after a glitch, long enough for the decoder to recover
+	GlitchGap time.Duration
+
+	OnFault func(f *Fault)
+
+	telegrams int
+	previous  bool
+}
+
+func NewInjector(e *Encoder, seed int64) *Injector {
+	return &Injector{
+		e:         e,
+		rnd:       rand.New(rand.NewSource(seed)),
+		Rates:     make(map[FaultKind]float64),
+		JitterMax: time.Duration(math.Round(mvb.BT / 8 * float64(time.Second))),
+		GlitchGap: time.Duration(math.Round(8 * mvb.BT * float64(time.Second))),
+	}
+}
+
+// next returns the fault to inject in the telegram, if any.
+func (in *Injector) next(t *mvb.Telegram) *ScriptedFault {
+	for len(in.Script) > 0 && in.Script[0].Telegram < in.telegrams {
+		in.Script = in.Script[1:]
+	}
+	if len(in.Script) > 0 && in.Script[0].Telegram == in.telegrams {
+		f := in.Script[0]
+		in.Script = in.Script[1:]
+		return &f
+	}
+	if in.previous {
+		return nil
+	}
+	x := in.rnd.Float64()
+	for _, k := range FaultKinds {
+		x -= in.Rates[k]
+		if x < 0 {
+			if k == MissingReply && t.Slave == nil {
+				return nil
+			}
+			return &ScriptedFault{Telegram: in.telegrams, Kind: k}
+		}
+	}
+	return nil
+}
+
+// Telegram writes t, with a fault if one is scripted or drawn for it.
+func (in *Injector) Telegram(t *mvb.Telegram) {
+	sf := in.next(t)
+	in.telegrams++
+	in.previous = sf != nil
+	if sf == nil {
+		in.e.Telegram(t)
+		return
+	}
+
+	f := &Fault{
+		Kind:     sf.Kind,
+		Telegram: sf.Telegram,
+		N:        in.e.N(),
+		Time:     in.e.Time().Seconds(),
+		Expect:   []string{},
+	}
+	master := WithCheckSequences(MasterBytes(t.Master))
+	var slave []byte
+	if t.Slave != nil {
+		slave = WithCheckSequences(t.Slave.Data())
+	}
+	// frame affected by bit flips and truncation
+	f.Frame = sf.Frame
+	if f.Frame == "" {
+		f.Frame = "master"
+		if slave != nil && in.rnd.Intn(2) == 1 {
+			f.Frame = "slave"
+		}
+	}
+	if f.Frame == "slave" && slave == nil {
+		f.Frame = "master"
+	}
+
+	switch f.Kind {
+	case BitFlip:
+		raw := master
+		if f.Frame == "slave" {
+			raw = slave
+		}
+		raw = append([]byte(nil), raw...)
+		bit := in.rnd.Intn(len(raw) * 8)
+		raw[bit/8] ^= 0x80 >> (bit % 8)
+		f.Detail = fmt.Sprintf("bit %d", bit)
+		// the parity bit of the check sequences is not checked
+		if bit%8 != 7 || !isCheckSequence(bit/8, len(raw)) {
+			f.Expect = append(f.Expect, "crc")
+		}
+		if f.Frame == "master" {
+			in.e.Frame(true, raw)
+			in.reply(f, slave)
+		} else {
+			in.e.Frame(true, master)
+			in.reply(nil, raw)
+		}
+
+	case Jitter:
+		f.Frame = ""
+		f.Detail = fmt.Sprintf("max %v", in.JitterMax)
+		f.Expect = nil
+		in.e.SetJitter(in.JitterMax, in.rnd)
+		in.e.Telegram(t)
+		in.e.SetJitter(0, nil)
+
+	case Truncate:
+		raw := master
+		if f.Frame == "slave" {
+			raw = slave
+		}
+		symbols := FrameSymbols(f.Frame == "master", raw)
+		// at least the start bit, at most up to the end delimiter
+		cut := 1 + in.rnd.Intn(len(symbols)-2)
+		f.Detail = fmt.Sprintf("%d of %d symbols", cut, len(symbols))
+		f.Expect = append(f.Expect, truncatedError(f.Frame == "master", cut, len(symbols)))
+		if f.Frame == "master" {
+			in.e.Symbols(symbols[:cut])
+			in.reply(f, slave)
+		} else {
+			in.e.Frame(true, master)
+			in.e.Idle(in.e.ReplyGap)
+			in.e.Symbols(symbols[:cut])
+		}
+
+	case Glitch:
+		f.Frame = ""
+		// whole samples, so that rounding doesn't make it longer
+		max := int(math.Ceil(mvb.BT/4*in.e.rate)) - 1
+		if max < 1 {
+			max = 1
+		}
+		width := 1 + in.rnd.Intn(max)
+		f.Detail = fmt.Sprintf("%d samples", width)
+		f.Expect = append(f.Expect, "start_delimiter")
+		in.e.Level(mvb.LOW, float64(width)/in.e.rate)
+		in.e.Idle(in.GlitchGap)
+		in.e.Telegram(t)
+
+	case MissingReply:
+		f.Frame = ""
+		in.e.Master(t.Master)
+
+	case DuplicateMaster:
+		f.Frame = ""
+		in.e.Master(t.Master)
+		in.e.Idle(in.e.ReplyGap)
+		in.e.Telegram(t)
+	}
+
+	if in.OnFault != nil {
+		in.OnFault(f)
+	}
+}
+
+// reply writes the slave frame, if any. If f is not nil, the master frame
+// carried the fault f; when the decoder rejected it, the reply is reported
+// as an unexpected slave frame.
+func (in *Injector) reply(f *Fault, slave []byte) {
+	if slave == nil {
+		return
+	}
+	in.e.Idle(in.e.ReplyGap)
+	in.e.Frame(false, slave)
+	if f != nil && len(f.Expect) > 0 {
+		f.Expect = append(f.Expect, "unexpected_slave")
+	}
+}
+
+func isCheckSequence(i, size int) bool {
+	return i%9 == 8 || i == size-1
+}
+
+// truncatedError returns the class of the error reported when a frame of
+// size symbols is cut after cut symbols: the bus then reads as NH.
+func truncatedError(master bool, cut, size int) string {
+	delimiter := mvb.StartDelimiter(master)
+	switch {
+	case cut == 1:
+		// NH selects the master start delimiter
+		delimiter = mvb.StartDelimiter(true)
+	case cut == size-2:
+		return "end_delimiter"
+	}
+	for i := cut - 1; i < len(delimiter); i++ {
+		if delimiter[i] != mvb.NH {
+			return "start_delimiter"
+		}
+	}
+	return "bit"
+}
+
+// ParseFaultRates parses comma-separated kind=probability pairs, e.g.
+// "bit_flip=0.01,glitch=0.001".
+func ParseFaultRates(s string) (map[FaultKind]float64, error) {
+	rates := make(map[FaultKind]float64)
+	total := 0.0
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid fault rate %q, want kind=probability", pair)
+		}
+		k, err := parseFaultKind(kv[0])
+		if err != nil {
+			return nil, err
+		}
+		p, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil || p < 0 || p > 1 {
+			return nil, fmt.Errorf("invalid probability %q for %s", kv[1], k)
+		}
+		rates[k] = p
+		total += p
+	}
+	if total > 1 {
+		return nil, fmt.Errorf("fault probabilities add up to %g, more than 1", total)
+	}
+	return rates, nil
+}
+
+// ParseFaultScript reads one fault per line: the index of the telegram, the
+// kind of fault and optionally the frame, master or slave. Empty lines and
+// lines starting with # are ignored.
+func ParseFaultScript(r io.Reader) ([]ScriptedFault, error) {
+	var script []ScriptedFault
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: want telegram, fault and optional frame", line)
+		}
+		var f ScriptedFault
+		var err error
+		f.Telegram, err = strconv.Atoi(fields[0])
+		if err != nil || f.Telegram < 0 {
+			return nil, fmt.Errorf("line %d: invalid telegram %q", line, fields[0])
+		}
+		f.Kind, err = parseFaultKind(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(fields) == 3 {
+			f.Frame = fields[2]
+			if f.Frame != "master" && f.Frame != "slave" {
+				return nil, fmt.Errorf("line %d: invalid frame %q", line, f.Frame)
+			}
+		}
+		script = append(script, f)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(script, func(i, j int) bool {
+		return script[i].Telegram < script[j].Telegram
+	})
+	return script, nil
+}
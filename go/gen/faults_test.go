@@ -0,0 +1,145 @@
+package gen
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"mvb"
+)
+
+// injectAll writes the telegrams through an injector, and returns the faults
+// and the first sample of each telegram.
+func injectAll(t *testing.T, in *Injector, e *Encoder, telegrams []*mvb.Telegram, n int) ([]*Fault, []uint64) {
+	var faults []*Fault
+	in.OnFault = func(f *Fault) { faults = append(faults, f) }
+	starts := make([]uint64, 0, n+1)
+	for i := 0; i < n; i++ {
+		e.Idle(20 * time.Microsecond)
+		starts = append(starts, e.N())
+		in.Telegram(telegrams[i%len(telegrams)])
+	}
+	e.Idle(20 * time.Microsecond)
+	starts = append(starts, e.N())
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return faults, starts
+}
+
+// decodeErrors returns the decoding errors, up to the end of the samples.
+func decodeErrors(samples io.Reader) []mvb.Error {
+	events := make(chan mvb.Event)
+	go mvb.NewDecoder(mvb.NewMVBStreamReader(samples)).Loop(events)
+	var errs []mvb.Error
+	for ev := range events {
+		if err, ok := ev.(mvb.Error); ok {
+			if err.Error() == io.EOF.Error() {
+				break
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// score checks that the errors reported within each telegram are those
+// expected by the ground truth, if predicted.
+func score(t *testing.T, faults []*Fault, starts []uint64, errs []mvb.Error) {
+	expect := make(map[int][]string)
+	unknown := make(map[int]bool)
+	for _, f := range faults {
+		expect[f.Telegram] = f.Expect
+		unknown[f.Telegram] = f.Expect == nil
+	}
+	got := make(map[int][]string)
+	for _, err := range errs {
+		i := sort.Search(len(starts), func(i int) bool { return starts[i] > err.N() }) - 1
+		got[i] = append(got[i], err.Class())
+	}
+	for i := 0; i < len(starts)-1; i++ {
+		if unknown[i] {
+			continue
+		}
+		want := strings.Join(expect[i], " ")
+		if g := strings.Join(got[i], " "); g != want {
+			t.Errorf("telegram %d: got errors %q, want %q", i, g, want)
+		}
+	}
+}
+
+func TestInjectScripted(t *testing.T) {
+	telegrams, err := ParseTelegrams(strings.NewReader(DefaultTelegrams))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every fault in every frame, with a clean telegram in between
+	var script []ScriptedFault
+	i := 0
+	for seed := 0; seed < 20; seed++ {
+		for _, k := range FaultKinds {
+			for _, frame := range []string{"master", "slave"} {
+				script = append(script, ScriptedFault{Telegram: i, Kind: k, Frame: frame})
+				i += 2
+			}
+		}
+	}
+
+	var samples bytes.Buffer
+	e := NewEncoder(&samples, mvb.SampleRate)
+	in := NewInjector(e, 1)
+	in.Script = script
+	faults, starts := injectAll(t, in, e, telegrams, i)
+	if len(faults) != len(script) {
+		t.Fatalf("got %d faults, want %d", len(faults), len(script))
+	}
+	score(t, faults, starts, decodeErrors(&samples))
+}
+
+func TestInjectRandom(t *testing.T) {
+	telegrams, err := ParseTelegrams(strings.NewReader(DefaultTelegrams))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var samples bytes.Buffer
+	e := NewEncoder(&samples, mvb.SampleRate)
+	in := NewInjector(e, 2)
+	in.Rates, err = ParseFaultRates("bit_flip=0.1,jitter=0.1,truncate=0.1,glitch=0.05,missing_reply=0.05,duplicate_master=0.05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	faults, starts := injectAll(t, in, e, telegrams, 2000)
+	kinds := make(map[FaultKind]int)
+	for _, f := range faults {
+		kinds[f.Kind]++
+	}
+	for _, k := range FaultKinds {
+		if kinds[k] == 0 {
+			t.Errorf("no %s faults injected", k)
+		}
+	}
+	score(t, faults, starts, decodeErrors(&samples))
+}
+
+func TestParseFaults(t *testing.T) {
+	for _, s := range []string{"bit_flip", "flip=0.1", "glitch=2", "glitch=0.6,jitter=0.6"} {
+		if _, err := ParseFaultRates(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+	for _, s := range []string{"1", "x glitch", "1 flip", "1 truncate both"} {
+		if _, err := ParseFaultScript(strings.NewReader(s)); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+	script, err := ParseFaultScript(strings.NewReader("# telegram fault frame\n9 truncate slave\n3 glitch\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(script) != 2 || script[0] != (ScriptedFault{3, Glitch, ""}) || script[1] != (ScriptedFault{9, Truncate, "slave"}) {
+		t.Errorf("got %v", script)
+	}
+}
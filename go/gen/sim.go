@@ -0,0 +1,409 @@
+package gen
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mvb"
+)
+
+// SimPort is a port polled periodically by the simulated bus administrator.
+type SimPort struct {
+	Port   uint16
+	FCode  uint8
+	Period time.Duration
+	// slave data before the fields are applied
+	Data   []byte
+	Fields []*Field
+
+	// cycle of the first poll, to spread the ports with the same period
+	offset int
+	polls  uint64
+}
+
+// Field is a part of the slave data of a port that changes over time.
+type Field struct {
+	// bytes I to J, or bit Bit of byte I if Bit >= 0 (0 is the least
+	// significant bit)
+	I, J int
+	Bit  int
+	Type string
+
+	// value at a time since the start, and whether the port is answered
+	value func(t time.Duration, p *SimPort) ([]byte, bool)
+}
+
+// Schedule is the configuration of the simulated bus: the ports, their poll
+// periods and the evolution of their values.
+type Schedule struct {
+	// the poll periods are multiples of the basic period
+	BasicPeriod time.Duration
+	Ports       []*SimPort
+}
+
+var fieldTypes = map[string]struct {
+	size     int
+	min, max float64
+}{
+	"bit":    {1, 0, 1},
+	"uint8":  {1, 0, math.MaxUint8},
+	"int8":   {1, math.MinInt8, math.MaxInt8},
+	"uint16": {2, 0, math.MaxUint16},
+	"int16":  {2, math.MinInt16, math.MaxInt16},
+	"uint32": {4, 0, math.MaxUint32},
+	"int32":  {4, math.MinInt32, math.MaxInt32},
+}
+
+// ParseSchedule reads a schedule: a line for each port, with the port
+// address and fcode in hexadecimal, the poll period and optionally the slave
+// data in hexadecimal, followed by a line for each field of the port that
+// changes over time, e.g.
+//
+//	basic 1ms
+//	port 014 3 32ms
+//	0:2 int16 sine 100 10s
+//	2:4 uint16 ramp 0 1000 1m
+//	4.3 bit square 0 1 2s
+//	8:10 csv csv/2024-01-31/014-8-10-pressure.csv
+//
+// Relative CSV paths are relative to dir. Empty lines and lines starting
+// with # are ignored.
+func ParseSchedule(r io.Reader, dir string, seed int64) (*Schedule, error) {
+	s := &Schedule{BasicPeriod: time.Millisecond}
+	rnd := rand.New(rand.NewSource(seed))
+	var port *SimPort
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var err error
+		switch fields[0] {
+		case "basic":
+			if len(fields) != 2 {
+				err = fmt.Errorf("want basic period")
+				break
+			}
+			s.BasicPeriod, err = time.ParseDuration(fields[1])
+			if err == nil && s.BasicPeriod <= 0 {
+				err = fmt.Errorf("invalid basic period %q", fields[1])
+			}
+		case "port":
+			port, err = parseSimPort(fields[1:])
+			if err == nil {
+				s.Ports = append(s.Ports, port)
+			}
+		default:
+			if port == nil {
+				err = fmt.Errorf("field before the first port")
+				break
+			}
+			var f *Field
+			f, err = parseField(fields, port, dir, rnd)
+			if err == nil {
+				port.Fields = append(port.Fields, f)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	count := make(map[time.Duration]int)
+	for _, p := range s.Ports {
+		cycles := int(p.Period / s.BasicPeriod)
+		if p.Period%s.BasicPeriod != 0 || cycles == 0 {
+			return nil, fmt.Errorf("port %03x: period %v is not a multiple of the basic period %v", p.Port, p.Period, s.BasicPeriod)
+		}
+		p.offset = count[p.Period] % cycles
+		count[p.Period]++
+	}
+	return s, nil
+}
+
+func parseSimPort(fields []string) (*SimPort, error) {
+	if len(fields) < 3 || len(fields) > 4 {
+		return nil, fmt.Errorf("want port, fcode, period and optional data")
+	}
+	port, err := strconv.ParseUint(fields[0], 16, 12)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", fields[0])
+	}
+	code, err := strconv.ParseUint(fields[1], 16, 4)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fcode %q", fields[1])
+	}
+	fcode := mvb.LookupFCode(uint8(code))
+	if fcode.SlaveFrameSize == 0 {
+		return nil, fmt.Errorf("fcode %x has no slave frame", code)
+	}
+	period, err := time.ParseDuration(fields[2])
+	if err != nil || period <= 0 {
+		return nil, fmt.Errorf("invalid period %q", fields[2])
+	}
+	p := &SimPort{
+		Port:   uint16(port),
+		FCode:  uint8(code),
+		Period: period,
+		Data:   make([]byte, fcode.SlaveFrameSize/8),
+	}
+	if len(fields) == 4 {
+		data, err := hex.DecodeString(fields[3])
+		if err != nil || len(data) != len(p.Data) {
+			return nil, fmt.Errorf("invalid data %q: want %d bytes", fields[3], len(p.Data))
+		}
+		p.Data = data
+	}
+	return p, nil
+}
+
+func parseField(fields []string, p *SimPort, dir string, rnd *rand.Rand) (*Field, error) {
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("want range, type or csv, and function")
+	}
+	f := &Field{Bit: -1}
+	var err error
+	if i := strings.IndexByte(fields[0], '.'); i >= 0 {
+		f.I, err = strconv.Atoi(fields[0][:i])
+		if err == nil {
+			f.Bit, err = strconv.Atoi(fields[0][i+1:])
+		}
+		f.J = f.I + 1
+		if err != nil || f.Bit < 0 || f.Bit > 7 {
+			return nil, fmt.Errorf("invalid bit %q", fields[0])
+		}
+	} else {
+		i, j, ok := strings.Cut(fields[0], ":")
+		if ok {
+			f.I, err = strconv.Atoi(i)
+			if err == nil {
+				f.J, err = strconv.Atoi(j)
+			}
+		}
+		if !ok || err != nil || f.I < 0 || f.J <= f.I {
+			return nil, fmt.Errorf("invalid range %q", fields[0])
+		}
+	}
+	if f.J > len(p.Data) {
+		return nil, fmt.Errorf("range %q out of the %d bytes of port %03x", fields[0], len(p.Data), p.Port)
+	}
+
+	f.Type = fields[1]
+	if f.Type == "csv" {
+		if len(fields) != 3 || f.Bit >= 0 {
+			return nil, fmt.Errorf("want byte range, csv and file")
+		}
+		path := fields[2]
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		f.value, err = historyValue(path, f.J-f.I)
+		return f, err
+	}
+
+	t, ok := fieldTypes[f.Type]
+	if !ok {
+		return nil, fmt.Errorf("invalid type %q", f.Type)
+	}
+	if (f.Bit >= 0) != (f.Type == "bit") || t.size != f.J-f.I {
+		return nil, fmt.Errorf("type %s does not match %q", f.Type, fields[0])
+	}
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("want function")
+	}
+	fn, err := parseFunction(fields[2], fields[3:], t.min, t.max, rnd)
+	if err != nil {
+		return nil, err
+	}
+	f.value = func(at time.Duration, p *SimPort) ([]byte, bool) {
+		v := math.Round(fn(at, p.polls))
+		v = math.Max(t.min, math.Min(t.max, v))
+		// big-endian, as the rest of the MVB data
+		b := make([]byte, t.size)
+		x := uint64(int64(v))
+		for i := t.size - 1; i >= 0; i-- {
+			b[i] = byte(x)
+			x >>= 8
+		}
+		return b, true
+	}
+	return f, nil
+}
+
+// function arguments: numbers, and durations for the periods
+var functions = map[string][]string{
+	"const":   {"value"},
+	"sine":    {"amplitude", "period", "offset"},
+	"ramp":    {"from", "to", "period"},
+	"square":  {"low", "high", "period"},
+	"counter": {"step"},
+	"random":  {"min", "max"},
+}
+
+// parseFunction returns a function of the time and the amount of previous
+// polls, with values between min and max.
+func parseFunction(name string, args []string, min, max float64, rnd *rand.Rand) (func(t time.Duration, polls uint64) float64, error) {
+	names, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid function %q", name)
+	}
+	// the offset of sine is optional
+	if len(args) != len(names) && !(name == "sine" && len(args) == len(names)-1) {
+		return nil, fmt.Errorf("%s: want %s", name, strings.Join(names, ", "))
+	}
+	x := make([]float64, len(names))
+	for i, arg := range args {
+		var err error
+		if names[i] == "period" {
+			var d time.Duration
+			d, err = time.ParseDuration(arg)
+			if d <= 0 {
+				err = fmt.Errorf("not positive")
+			}
+			x[i] = d.Seconds()
+		} else {
+			x[i], err = strconv.ParseFloat(arg, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid %s %q", name, names[i], arg)
+		}
+	}
+
+	switch name {
+	case "const":
+		return func(time.Duration, uint64) float64 { return x[0] }, nil
+	case "sine":
+		return func(t time.Duration, _ uint64) float64 {
+			return x[2] + x[0]*math.Sin(2*math.Pi*t.Seconds()/x[1])
+		}, nil
+	case "ramp":
+		return func(t time.Duration, _ uint64) float64 {
+			phase := math.Mod(t.Seconds(), x[2]) / x[2]
+			return x[0] + (x[1]-x[0])*phase
+		}, nil
+	case "square":
+		return func(t time.Duration, _ uint64) float64 {
+			if math.Mod(t.Seconds(), x[2]) < x[2]/2 {
+				return x[0]
+			}
+			return x[1]
+		}, nil
+	case "counter":
+		// wraps around like the life sign counters of the devices
+		return func(_ time.Duration, polls uint64) float64 {
+			span := max - min + 1
+			return min + math.Mod(math.Mod(x[0]*float64(polls)-min, span)+span, span)
+		}, nil
+	}
+	return func(time.Duration, uint64) float64 {
+		return x[0] + rnd.Float64()*(x[1]-x[0])
+	}, nil
+}
+
+// historyValue replays the rows of a CSV file written by the Recorder, from
+// the first row and over again when the file ends. The port is not answered
+// while the file has it as stale.
+func historyValue(path string, size int) (func(time.Duration, *SimPort) ([]byte, bool), error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	rows, err := mvb.ReadHistory(fp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%s: no values", path)
+	}
+	for _, r := range rows {
+		if r.Value != nil && len(r.Value) != size {
+			return nil, fmt.Errorf("%s: value %x does not have %d bytes", path, r.Value, size)
+		}
+	}
+	start := rows[0].T
+	length := rows[len(rows)-1].T - start + time.Second
+	return func(t time.Duration, _ *SimPort) ([]byte, bool) {
+		t = start + t%length
+		i := sort.Search(len(rows), func(i int) bool { return rows[i].T > t }) - 1
+		return rows[i].Value, rows[i].Value != nil
+	}, nil
+}
+
+// Simulator is a bus administrator that polls the ports of a schedule.
+type Simulator struct {
+	schedule *Schedule
+	cycle    int
+	pending  []*mvb.Telegram
+}
+
+func NewSimulator(s *Schedule) *Simulator {
+	return &Simulator{schedule: s, cycle: -1}
+}
+
+// Next returns the next telegram and the start of its basic period, since
+// the start of the simulation. The telegrams of a basic period are meant to
+// be sent one after the other from its start.
+func (s *Simulator) Next() (time.Duration, *mvb.Telegram) {
+	for len(s.pending) == 0 {
+		s.cycle++
+		for _, p := range s.schedule.Ports {
+			cycles := int(p.Period / s.schedule.BasicPeriod)
+			if s.cycle%cycles == p.offset {
+				s.pending = append(s.pending, p.poll(s.start()))
+			}
+		}
+	}
+	t := s.pending[0]
+	s.pending[0] = nil
+	s.pending = s.pending[1:]
+	return s.start(), t
+}
+
+func (s *Simulator) start() time.Duration {
+	return time.Duration(s.cycle) * s.schedule.BasicPeriod
+}
+
+// poll returns the telegram of a poll at t.
+func (p *SimPort) poll(t time.Duration) *mvb.Telegram {
+	tg := &mvb.Telegram{Master: &mvb.MasterFrame{FCode: p.FCode, Address: p.Port}}
+	data := append([]byte(nil), p.Data...)
+	answered := true
+	for _, f := range p.Fields {
+		v, ok := f.value(t, p)
+		if !ok {
+			answered = false
+			continue
+		}
+		if f.Bit >= 0 {
+			mask := byte(1) << f.Bit
+			data[f.I] &^= mask
+			if v[0] != 0 {
+				data[f.I] |= mask
+			}
+			continue
+		}
+		copy(data[f.I:f.J], v)
+	}
+	p.polls++
+	if answered {
+		tg.Slave = mvb.NewSlaveFrame(data)
+	}
+	return tg
+}
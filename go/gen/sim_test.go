@@ -0,0 +1,143 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mvb"
+)
+
+const testSchedule = `
+basic 1ms
+port 014 1 2ms 00000000
+0:2 int16 ramp -100 100 10ms
+2.0 bit square 0 1 4ms
+3:4 uint8 counter 100
+port 015 0 2ms
+0:2 csv speed.csv
+port 020 2 4ms 1122334455667788
+`
+
+// 23:59:59.990 is the start of the simulation; the second row is after
+// midnight
+const testHistory = `23:59:59.990,0001
+00:00:00.002,stale
+00:00:00.004,0003
+00:00:00.00`
+
+func TestSimulator(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "speed.csv"), []byte(testHistory), 0666); err != nil {
+		t.Fatal(err)
+	}
+	s, err := ParseSchedule(strings.NewReader(testSchedule), dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := NewSimulator(s)
+
+	var got []string
+	for i := 0; i < 12; i++ {
+		at, tg := sim.Next()
+		got = append(got, fmt.Sprintf("%v %03x %v", at, tg.Master.Address, tg.Slave))
+	}
+	want := []string{
+		"0s 014 ff9c0000",
+		"0s 020 1122334455667788",
+		"1ms 015 0001",
+		"2ms 014 ffc40164",
+		"3ms 015 0001",
+		"4ms 014 ffec00c8",
+		"4ms 020 1122334455667788",
+		"5ms 015 0001",
+		"6ms 014 0014012c",
+		"7ms 015 0001",
+		"8ms 014 003c0090",
+		"8ms 020 1122334455667788",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSimulatorHistory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "speed.csv"), []byte(testHistory), 0666); err != nil {
+		t.Fatal(err)
+	}
+	s, err := ParseSchedule(strings.NewReader("basic 1ms\nport 015 0 1ms\n0:2 csv speed.csv\n"), dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := NewSimulator(s)
+	var got []string
+	for i := 0; i < 16; i++ {
+		_, tg := sim.Next()
+		got = append(got, tg.Slave.String())
+	}
+	// not answered while stale
+	want := "0001 0001 0001 0001 0001 0001 0001 0001 0001 0001 0001 0001 - - 0003 0003"
+	if strings.Join(got, " ") != want {
+		t.Errorf("got %s, want %s", strings.Join(got, " "), want)
+	}
+}
+
+func TestSimulatorDecode(t *testing.T) {
+	schedule := "port 014 3 1ms\n0:2 int16 sine 1000 5ms\n15.7 bit square 0 1 2ms\nport 0ee f 4ms 5708\n"
+	s, err := ParseSchedule(strings.NewReader(schedule), ".", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := NewSimulator(s)
+	var samples bytes.Buffer
+	e := NewEncoder(&samples, mvb.SampleRate)
+	var sent []*mvb.Telegram
+	for i := 0; i < 50; i++ {
+		at, tg := sim.Next()
+		if d := at - e.Time(); d > 0 {
+			e.Idle(d)
+		}
+		e.Idle(20 * time.Microsecond)
+		e.Telegram(tg)
+		sent = append(sent, tg)
+	}
+	e.Idle(20 * time.Microsecond)
+	e.Flush()
+
+	events := make(chan mvb.Event)
+	go mvb.NewDecoder(mvb.NewMVBStreamReader(&samples)).Loop(events)
+	for i, want := range sent {
+		got, ok := (<-events).(*mvb.Telegram)
+		if !ok || *got.Master != *want.Master || got.Slave.String() != want.Slave.String() {
+			t.Fatalf("telegram %d: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	for _, s := range []string{
+		"0:2 int16 const 1",
+		"port 014 3",
+		"port 014 5 1ms",
+		"port 014 3 1ms 00",
+		"port 014 3 1500us",
+		"basic 0s",
+		"port 014 1 1ms\n0:2 int8 const 1",
+		"port 014 1 1ms\n0:4 int16 const 1",
+		"port 014 1 1ms\n0:1 bit const 1",
+		"port 014 1 1ms\n0.8 bit const 1",
+		"port 014 1 1ms\n0:2 int16 sine 1",
+		"port 014 1 1ms\n0:2 int16 ramp 0 1 0s",
+		"port 014 1 1ms\n0:2 int16 foo 1",
+		"port 014 1 1ms\n0:2 csv missing.csv",
+	} {
+		if _, err := ParseSchedule(strings.NewReader(s), t.TempDir(), 1); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
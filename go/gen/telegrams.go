@@ -0,0 +1,116 @@
+package gen
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"mvb"
+)
+
+// DefaultTelegrams are the telegrams of gen/gen.c, in the format read by
+// ParseTelegrams.
+const DefaultTelegrams = `# fcode port slave data
+4 390 971e0000008214061e0b310f0017058c000000000000034d119411a811a80405
+4 31b 30000f0c0110000000000000000011a800000000000000000000000000000000
+0 001 971e
+4 010 04004830580048803bf000001bf91bf92b000000000000000000000000000000
+0 1e9 0000
+4 020 0000000000000000000000000000000000000000000000000000000000000000
+0 2e9 0000
+4 030 04006030580068803bfa00001bfa3bfa2c000001000000000000000000000000
+0 3e9 0001
+f 0ee 5708
+3 042 00000000000000000000000000000000
+3 052 00000000000000000000000000000000
+3 062 00000000000000000000000000000000
+3 072 a000a000a000a000a000a00000000000
+3 082 a000a000a000a000a000a00000000000
+3 092 a000a000a000a000a000a00000000000
+3 013 00000000000000000000000000000000
+3 023 00000000000000000000000000000000
+3 033 00000000000000000000000000000000
+3 043 00000000000000000000000000000000
+3 053 00000000000000000000000000000000
+3 063 00000000000000000000000000000000
+0 2e4 0300
+0 3e4 0300
+0 12e c043
+0 1e1 3bfa
+0 1e5 d691
+0 1b1 0400
+0 1b2 6030
+0 1b5 d677
+0 1c1 5800
+0 1c2 6880
+0 1c5 d677
+0 2e1 1bfa
+0 2e5 d675
+0 3e1 3bfa
+0 3e5 d671
+0 120 0e4a
+0 121 388d
+0 12f d59f
+4 110 971f7400001e00001ca71ca70000000000000000119411a811a8000700000000
+4 119 0004020000000000037a0379010401670000036f000000000000000000000000
+4 219 0005020000000000037d0377011d013d00060000000000000000000000000000
+4 319 00050200000000000379037a011d013500000000000000000000000000000000
+4 299 7f360c0000000000000000000000000000000000000000001ca7000000000000
+4 399 7fb80c0000000000000000000000000000000000000000001ca7000000000000
+4 290 971f0000008214061e0b310f0017058c0000000000000393119411a811a80405
+4 29a 7f37000000000000000000000000008000a600c6013900000000000000000000
+4 39a 7fb8000000000000000000000000008000a600c6013900000000000000000000
+`
+
+// ParseTelegrams reads one telegram per line: the fcode and the port or
+// device address in hexadecimal, and the slave data in hexadecimal, without
+// check sequences. A telegram without slave data has no reply. Empty lines
+// and lines starting with # are ignored.
+func ParseTelegrams(r io.Reader) ([]*mvb.Telegram, error) {
+	var telegrams []*mvb.Telegram
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		t, err := parseTelegram(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		telegrams = append(telegrams, t)
+	}
+	return telegrams, s.Err()
+}
+
+func parseTelegram(fields []string) (*mvb.Telegram, error) {
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, fmt.Errorf("expected fcode, port and optional data")
+	}
+	fcode, err := strconv.ParseUint(fields[0], 16, 4)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fcode: %q", fields[0])
+	}
+	port, err := strconv.ParseUint(strings.TrimPrefix(fields[1], "0x"), 16, 12)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port: %q", fields[1])
+	}
+	t := &mvb.Telegram{Master: &mvb.MasterFrame{FCode: uint8(fcode), Address: uint16(port)}}
+	if len(fields) == 3 {
+		data, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid data: %q", fields[2])
+		}
+		size := int(mvb.LookupFCode(uint8(fcode)).SlaveFrameSize / 8)
+		if len(data) != size {
+			return nil, fmt.Errorf("fcode %d expects %d bytes of data, got %d", fcode, size, len(data))
+		}
+		t.Slave = mvb.NewSlaveFrame(data)
+	}
+	return t, nil
+}
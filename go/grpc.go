@@ -0,0 +1,383 @@
+package mvb
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"mvb/mvbpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var grpcAddr = ""
+
+func initGRPCFlags() {
+	flag.StringVar(&grpcAddr, "grpc", grpcAddr, "serve the gRPC API on this address, e.g. :7001")
+}
+
+// changes buffered per subscription; a subscriber that falls further behind
+// is disconnected with RESOURCE_EXHAUSTED
+const grpcSubscriptionQueue = 1024
+
+// GRPCServer implements the gRPC API defined in mvbpb/mvb.proto, over its
+// own copy of Stats.
+type GRPCServer struct {
+	mvbpb.UnimplementedMVBServer
+
+	watched []RecorderPortSpec
+	// set by StartGRPC
+	listener net.Listener
+	server   *grpc.Server
+
+	mu    sync.Mutex
+	stats Stats
+	subs  map[*grpcSubscription]struct{}
+}
+
+type grpcSubscription struct {
+	// nil for all ports
+	ports map[uint16]bool
+	// for variable subscriptions, the indexes of the watched variables
+	vars    []int
+	isVar   bool
+	changes chan interface{}
+	// closed when the subscriber falls behind
+	overflow chan struct{}
+}
+
+func NewGRPCServer(watched []RecorderPortSpec) *GRPCServer {
+	return &GRPCServer{
+		watched: watched,
+		stats:   NewStats(),
+		subs:    make(map[*grpcSubscription]struct{}),
+	}
+}
+
+// StartGRPC serves the gRPC API on the address given with -grpc. It returns
+// nil if the flag was not set. The server is never stopped, so a failure to
+// serve after listening is fatal.
+func StartGRPC(watched []RecorderPortSpec) (*GRPCServer, error) {
+	if grpcAddr == "" {
+		return nil, nil
+	}
+	l, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		return nil, fmt.Errorf("grpc: %w", err)
+	}
+	g := NewGRPCServer(watched)
+	g.listener = l
+	g.server = grpc.NewServer()
+	mvbpb.RegisterMVBServer(g.server, g)
+	go func() {
+		if err := g.server.Serve(l); err != nil {
+			log.Fatalf("grpc: %v", err)
+		}
+	}()
+	return g, nil
+}
+
+// Loop updates the state with the events received from mvbEvents until it
+// is closed.
+func (g *GRPCServer) Loop(mvbEvents chan Event) {
+	secondsTicker := time.NewTicker(1 * time.Second)
+	defer secondsTicker.Stop()
+	for {
+		select {
+		case ev, ok := <-mvbEvents:
+			if !ok {
+				return
+			}
+			g.update(ev)
+		case <-secondsTicker.C:
+			g.mu.Lock()
+			g.stats.Tick()
+			g.mu.Unlock()
+		}
+	}
+}
+
+func (g *GRPCServer) update(ev Event) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch ev := ev.(type) {
+	case *Telegram:
+		port := ev.Master.Address
+		old, seen := g.stats.Vars[port]
+		g.stats.CountTelegram(ev)
+		if fcodes[ev.Master.FCode].MasterRequest != MR_PROCESS_DATA || ev.Slave == nil {
+			return
+		}
+		for sub := range g.subs {
+			g.notify(sub, ev, old, seen)
+		}
+	case Error:
+		g.stats.CountError(ev)
+	}
+}
+
+func (g *GRPCServer) notify(sub *grpcSubscription, t *Telegram, old []byte, seen bool) {
+	port := t.Master.Address
+	value := t.Slave.data
+	if sub.ports != nil && !sub.ports[port] {
+		return
+	}
+	if !sub.isVar {
+		if !seen || !bytes.Equal(old, value) {
+			g.send(sub, &mvbpb.PortChange{
+				N:    t.N(),
+				Time: sampleTimestamp(t.N()).Seconds(),
+				Port: &mvbpb.Port{Port: uint32(port), Value: value, Seen: true},
+			})
+		}
+		return
+	}
+	for _, i := range sub.vars {
+		w := &g.watched[i]
+		if w.Port != port {
+			continue
+		}
+		v := slice(value, w.I, w.J)
+		if !seen || !bytes.Equal(slice(old, w.I, w.J), v) {
+			g.send(sub, &mvbpb.VariableChange{
+				N:        t.N(),
+				Time:     sampleTimestamp(t.N()).Seconds(),
+				Variable: newVariable(w, v, true),
+			})
+		}
+	}
+}
+
+func (g *GRPCServer) send(sub *grpcSubscription, change interface{}) {
+	select {
+	case sub.changes <- change:
+	default:
+		delete(g.subs, sub)
+		close(sub.overflow)
+	}
+}
+
+func newVariable(w *RecorderPortSpec, value []byte, seen bool) *mvbpb.Variable {
+	return &mvbpb.Variable{
+		Port:  uint32(w.Port),
+		I:     int32(w.I),
+		J:     int32(w.J),
+		Desc:  w.Desc,
+		Value: value,
+		Seen:  seen,
+	}
+}
+
+func checkPort(port uint32) error {
+	if port >= 1<<12 {
+		return status.Errorf(codes.InvalidArgument, "invalid port: %x", port)
+	}
+	return nil
+}
+
+func (g *GRPCServer) GetPort(ctx context.Context, req *mvbpb.GetPortRequest) (*mvbpb.Port, error) {
+	if err := checkPort(req.Port); err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	value, seen := g.stats.Vars[uint16(req.Port)]
+	return &mvbpb.Port{Port: req.Port, Value: value, Seen: seen}, nil
+}
+
+func (g *GRPCServer) ListPorts(ctx context.Context, req *mvbpb.ListPortsRequest) (*mvbpb.ListPortsResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	resp := &mvbpb.ListPortsResponse{}
+	for port, value := range g.stats.Vars {
+		resp.Ports = append(resp.Ports, &mvbpb.Port{Port: uint32(port), Value: value, Seen: true})
+	}
+	sort.Slice(resp.Ports, func(i, j int) bool { return resp.Ports[i].Port < resp.Ports[j].Port })
+	return resp, nil
+}
+
+func (g *GRPCServer) ListVariables(ctx context.Context, req *mvbpb.ListVariablesRequest) (*mvbpb.ListVariablesResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	resp := &mvbpb.ListVariablesResponse{}
+	for i := range g.watched {
+		w := &g.watched[i]
+		value, seen := g.stats.Vars[w.Port]
+		resp.Variables = append(resp.Variables, newVariable(w, slice(value, w.I, w.J), seen))
+	}
+	return resp, nil
+}
+
+func (g *GRPCServer) GetDeviceStatus(ctx context.Context, req *mvbpb.GetDeviceStatusRequest) (*mvbpb.DeviceStatus, error) {
+	if err := checkPort(req.Address); err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	status, seen := g.stats.Devices[uint16(req.Address)]
+	return &mvbpb.DeviceStatus{Address: req.Address, Status: status, Seen: seen}, nil
+}
+
+func (g *GRPCServer) GetRates(ctx context.Context, req *mvbpb.GetRatesRequest) (*mvbpb.Rates, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	rates := &mvbpb.Rates{
+		Total:     g.stats.Total,
+		Telegrams: append([]uint64(nil), g.stats.Rate()...),
+		Errors:    append([]uint64(nil), g.stats.ErrorRate()...),
+	}
+	for i := 0; i < int(MR_AMOUNT); i++ {
+		mr := MasterRequest(i)
+		rates.Requests = append(rates.Requests, &mvbpb.RequestRate{
+			Request: mr.String(),
+			Rate:    append([]uint64(nil), g.stats.MRRate(mr)...),
+		})
+	}
+	return rates, nil
+}
+
+func (g *GRPCServer) ListErrors(ctx context.Context, req *mvbpb.ListErrorsRequest) (*mvbpb.ListErrorsResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	resp := &mvbpb.ListErrorsResponse{}
+	for _, err := range g.stats.ErrorLog {
+		resp.Errors = append(resp.Errors, &mvbpb.Error{
+			N:       err.N(),
+			Time:    sampleTimestamp(err.N()).Seconds(),
+			Class:   err.Class(),
+			Message: err.Error(),
+		})
+	}
+	return resp, nil
+}
+
+func portSet(ports []uint32) (map[uint16]bool, error) {
+	if len(ports) == 0 {
+		return nil, nil
+	}
+	set := make(map[uint16]bool)
+	for _, p := range ports {
+		if err := checkPort(p); err != nil {
+			return nil, err
+		}
+		set[uint16(p)] = true
+	}
+	return set, nil
+}
+
+// subscribe registers the subscription, and returns the initial changes if
+// requested, while holding the lock so that no change is missed.
+func (g *GRPCServer) subscribe(sub *grpcSubscription, initial bool) []interface{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.subs[sub] = struct{}{}
+	if !initial {
+		return nil
+	}
+	var changes []interface{}
+	if !sub.isVar {
+		for port, value := range g.stats.Vars {
+			if sub.ports == nil || sub.ports[port] {
+				changes = append(changes, &mvbpb.PortChange{
+					Port: &mvbpb.Port{Port: uint32(port), Value: value, Seen: true},
+				})
+			}
+		}
+		return changes
+	}
+	for _, i := range sub.vars {
+		w := &g.watched[i]
+		if value, seen := g.stats.Vars[w.Port]; seen {
+			changes = append(changes, &mvbpb.VariableChange{
+				Variable: newVariable(w, slice(value, w.I, w.J), true),
+			})
+		}
+	}
+	return changes
+}
+
+func (g *GRPCServer) unsubscribe(sub *grpcSubscription) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.subs, sub)
+}
+
+func (g *GRPCServer) stream(ctx context.Context, sub *grpcSubscription, initial bool, send func(interface{}) error) error {
+	sub.changes = make(chan interface{}, grpcSubscriptionQueue)
+	sub.overflow = make(chan struct{})
+	for _, change := range g.subscribe(sub, initial) {
+		if err := send(change); err != nil {
+			g.unsubscribe(sub)
+			return err
+		}
+	}
+	defer g.unsubscribe(sub)
+	for {
+		select {
+		case change := <-sub.changes:
+			if err := send(change); err != nil {
+				return err
+			}
+		case <-sub.overflow:
+			return status.Error(codes.ResourceExhausted, "subscriber too slow")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (g *GRPCServer) SubscribePorts(req *mvbpb.SubscribePortsRequest, stream mvbpb.MVB_SubscribePortsServer) error {
+	ports, err := portSet(req.Ports)
+	if err != nil {
+		return err
+	}
+	sub := &grpcSubscription{ports: ports}
+	return g.stream(stream.Context(), sub, req.Initial, func(change interface{}) error {
+		return stream.Send(change.(*mvbpb.PortChange))
+	})
+}
+
+func (g *GRPCServer) SubscribeVariables(req *mvbpb.SubscribeVariablesRequest, stream mvbpb.MVB_SubscribeVariablesServer) error {
+	ports, err := portSet(req.Ports)
+	if err != nil {
+		return err
+	}
+	sub := &grpcSubscription{isVar: true}
+	for i, w := range g.watched {
+		if ports != nil && !ports[w.Port] {
+			continue
+		}
+		if len(req.Descs) > 0 && !containsString(req.Descs, w.Desc) {
+			continue
+		}
+		sub.vars = append(sub.vars, i)
+	}
+	if len(sub.vars) == 0 {
+		return status.Error(codes.NotFound, "no watched variable matches the filter")
+	}
+	sub.ports = make(map[uint16]bool)
+	for _, i := range sub.vars {
+		sub.ports[g.watched[i].Port] = true
+	}
+	return g.stream(stream.Context(), sub, req.Initial, func(change interface{}) error {
+		return stream.Send(change.(*mvbpb.VariableChange))
+	})
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
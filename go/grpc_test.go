@@ -0,0 +1,80 @@
+package mvb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"mvb/client"
+	"mvb/mvbpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPC(t *testing.T) {
+	defer func(addr string) { grpcAddr = addr }(grpcAddr)
+	grpcAddr = "127.0.0.1:0"
+	g, err := StartGRPC([]RecorderPortSpec{{0x014, 0, 2, "motor current"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.server.Stop()
+	g.update(testTelegram(100, 0x014, "012c00"))
+	g.update(testTelegram(200, 0x020, "01"))
+
+	c, err := client.Dial(g.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	value, seen, err := c.Port(ctx, 0x014)
+	if err != nil || !seen || fmt.Sprintf("%x", value) != "012c00" {
+		t.Errorf("got %x %v %v, want 012c00 true", value, seen, err)
+	}
+	if _, seen, err := c.Port(ctx, 0x030); err != nil || seen {
+		t.Errorf("got %v %v for an unseen port", seen, err)
+	}
+	if _, _, err := c.Port(ctx, 0x1000); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got %v for an invalid port", err)
+	}
+	ports, err := c.Ports(ctx)
+	if err != nil || len(ports) != 2 || fmt.Sprintf("%x", ports[0x020]) != "01" {
+		t.Errorf("got %x %v", ports, err)
+	}
+	vars, err := c.Variables(ctx)
+	if err != nil || len(vars) != 1 || vars[0].Desc != "motor current" || fmt.Sprintf("%x", vars[0].Value) != "012c" {
+		t.Errorf("got %v %v", vars, err)
+	}
+
+	// the current value, then the changes
+	stop := errors.New("stop")
+	var changes []string
+	err = c.WatchVariables(ctx, nil, func(change *mvbpb.VariableChange) error {
+		changes = append(changes, fmt.Sprintf("%d %x", change.N, change.Variable.Value))
+		if len(changes) == 2 {
+			return stop
+		}
+		// the last byte is outside of the variable
+		g.update(testTelegram(300, 0x014, "012cff"))
+		g.update(testTelegram(400, 0x014, "0190ff"))
+		return nil
+	})
+	if err != stop {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(changes), "[0 012c 400 0190]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// the address is in use
+	grpcAddr = g.listener.Addr().String()
+	if _, err := StartGRPC(nil); err == nil {
+		t.Errorf("listening twice on %s", grpcAddr)
+	}
+}
@@ -0,0 +1,61 @@
+package mvb
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// HistoryRow is a line of a CSV file written by the Recorder.
+type HistoryRow struct {
+	// time of day; rows after midnight are 24h later than the previous ones
+	T time.Duration
+	// nil if the port was stale
+	Value []byte
+}
+
+// ReadHistory reads the rows of a CSV file written by the Recorder, i.e.
+// lines with the time of day and the value in hexadecimal or "stale". A
+// partial last line, as left by a crash, is ignored.
+func ReadHistory(r io.Reader) ([]HistoryRow, error) {
+	var rows []HistoryRow
+	var day time.Duration
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		s, err := br.ReadString('\n')
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		s = strings.TrimSuffix(s, "\n")
+		i := strings.IndexByte(s, ',')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected time and value", line)
+		}
+		tod, err := time.Parse("15:04:05.000", s[:i])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid time %q", line, s[:i])
+		}
+		row := HistoryRow{T: day +
+			time.Duration(tod.Hour())*time.Hour +
+			time.Duration(tod.Minute())*time.Minute +
+			time.Duration(tod.Second())*time.Second +
+			time.Duration(tod.Nanosecond())}
+		if len(rows) > 0 && row.T < rows[len(rows)-1].T {
+			day += 24 * time.Hour
+			row.T += 24 * time.Hour
+		}
+		if s[i+1:] != "stale" {
+			row.Value, err = hex.DecodeString(s[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid value %q", line, s[i+1:])
+			}
+		}
+		rows = append(rows, row)
+	}
+}
@@ -3,6 +3,7 @@ package mvb
 import (
 	"bytes"
 	"flag"
+	"fmt"
 	"io"
 	"os"
 	"strconv"
@@ -20,6 +21,10 @@ var (
 	signalHigh = byte(0xff)
 	signalLow  = byte(0xfe)
 	annotate   = false
+	parallel   = false
+
+	inputFormat = "samples"
+	vcdSignal   = ""
 )
 
 func initInputFlags() {
@@ -32,6 +37,16 @@ func initInputFlags() {
 		return
 	})
 	flag.BoolVar(&annotate, "annotate", annotate, "activate annotations")
+	flag.BoolVar(&parallel, "parallel", parallel, "scan the input for edges in a separate goroutine (ignored with -annotate)")
+	flag.Func("input", "input format: samples (one byte per sample), runs (see runs/main.go) or vcd (default samples)", func(s string) error {
+		switch s {
+		case "samples", "runs", "vcd":
+			inputFormat = s
+			return nil
+		}
+		return fmt.Errorf("unknown input format: %s", s)
+	})
+	flag.StringVar(&vcdSignal, "vcd-signal", vcdSignal, "name of the signal to decode with -input=vcd (default: the first one-bit signal)")
 }
 
 func decodeByte(s string) (byte, error) {
@@ -175,14 +190,60 @@ func (d *BufferedReader) DiscardUntil(b byte) error {
 	}
 }
 
+// Stream is the input of MVBDecoder: a binary signal, read one sample at a
+// time or up to the next edge.
+type Stream interface {
+	WaitUntilElapsed(samples int) (bool, error)
+	WaitUntilElapsedOrEdge(samples int, v1 bool) (bool, error)
+	WaitUntilIdle(samples int) (bool, error)
+	WaitUntil(v bool) (bool, error)
+	V() bool
+	N() uint64
+	Annotate(text string)
+	GetSamples() []Sample
+}
+
+// NewInputStream returns the stream for stdin, in the format given with
+// -input. Samples are decoded with an EdgeStream with -parallel, unless
+// annotations are enabled, or else with an MVBStream.
+func NewInputStream() (Stream, error) {
+	if inputFormat == "samples" {
+		if parallel && !annotate {
+			return NewEdgeStream(os.Stdin), nil
+		}
+		return NewMVBStream(), nil
+	}
+	r, err := NewInputRunReader()
+	if err != nil {
+		return nil, err
+	}
+	return NewRunStream(r), nil
+}
+
+// NewInputRunReader returns the runs of stdin, in the format given with
+// -input.
+func NewInputRunReader() (RunReader, error) {
+	switch inputFormat {
+	case "runs":
+		return NewRunFileReader(os.Stdin)
+	case "vcd":
+		return NewVCDRunReader(os.Stdin, vcdSignal)
+	}
+	return NewSampleRunReader(os.Stdin), nil
+}
+
 type MVBStream struct {
 	r *BufferedReader
 	v bool
 }
 
 func NewMVBStream() *MVBStream {
+	return NewMVBStreamReader(os.Stdin)
+}
+
+func NewMVBStreamReader(r io.Reader) *MVBStream {
 	return &MVBStream{
-		r: NewDoubleBufferedReader(os.Stdin),
+		r: NewDoubleBufferedReader(r),
 	}
 }
 
@@ -0,0 +1,235 @@
+package mvb
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type rotation string
+
+const (
+	rotateHourly = rotation("hourly")
+	rotateDaily  = rotation("daily")
+	rotateBySize = rotation("size")
+)
+
+var (
+	outDir       = "csv"
+	layoutFlag   = "{date}/{spec}.csv"
+	rotationFlag = rotateDaily
+	rotateSize   = int64(64 << 20)
+	vehicle      = ""
+)
+
+func initLayoutFlags() {
+	flag.StringVar(&outDir, "out", outDir, "output directory")
+	flag.StringVar(&layoutFlag, "layout", layoutFlag, "file layout inside the output directory; placeholders: "+
+		"{date} {hour} {port} {i} {j} {desc} {spec} {vehicle} {seq}")
+	flag.Func("rotate", "start a new file: hourly, daily or size", func(s string) error {
+		switch r := rotation(s); r {
+		case rotateHourly, rotateDaily, rotateBySize:
+			rotationFlag = r
+			return nil
+		}
+		return fmt.Errorf("unknown rotation: %s", s)
+	})
+	flag.Func("rotate-size", "maximum file size with -rotate=size, e.g. 64M", func(s string) (err error) {
+		rotateSize, err = parseSize(s)
+		return
+	})
+	flag.StringVar(&vehicle, "vehicle", vehicle, "vehicle identifier, for the {vehicle} placeholder")
+}
+
+var placeholderRegexp = regexp.MustCompile(`\{[^}]*\}`)
+
+var placeholders = map[string]bool{
+	"{date}":    true,
+	"{hour}":    true,
+	"{port}":    true,
+	"{i}":       true,
+	"{j}":       true,
+	"{desc}":    true,
+	"{spec}":    true,
+	"{vehicle}": true,
+	"{id}":      true,
+	"{seq}":     true,
+}
+
+// layout maps each recorded port and point in time to a file path.
+type layout struct {
+	dir      string
+	template string
+	rotation rotation
+}
+
+// newLayout parses a layout template. If the template does not contain the
+// placeholders that change with each rotation ({date}, {hour} or {seq}),
+// they are added as a suffix to the file name.
+func newLayout(dir string, template string, r rotation) (*layout, error) {
+	if err := checkTemplate(template); err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(template, "/") || filepath.IsAbs(template) {
+		return nil, fmt.Errorf("invalid layout %q", template)
+	}
+
+	required := map[rotation][]string{
+		rotateHourly: {"{date}", "{hour}"},
+		rotateDaily:  {"{date}"},
+		rotateBySize: {"{seq}"},
+	}[r]
+	for _, p := range required {
+		if !strings.Contains(template, p) {
+			ext := filepath.Ext(template)
+			template = strings.TrimSuffix(template, ext) + "-" + p + ext
+		}
+	}
+
+	return &layout{dir: dir, template: template, rotation: r}, nil
+}
+
+func (l *layout) path(spec *RecorderPortSpec, t time.Time, seq int) string {
+	return filepath.Join(l.dir, expandTemplate(l.template, spec, t, seq, nil))
+}
+
+// expandTemplate replaces the placeholders of a layout or topic template.
+// The values that come from the user, the description and the vehicle, are
+// passed through escape, if not nil.
+func expandTemplate(template string, spec *RecorderPortSpec, t time.Time, seq int, escape *strings.Replacer) string {
+	i, j := "", ""
+	if spec.I != -1 {
+		i, j = strconv.Itoa(spec.I), strconv.Itoa(spec.J)
+	}
+	desc, specName, v := slug(spec.Desc), spec.String(), slug(vehicle)
+	if escape != nil {
+		desc, specName, v = escape.Replace(desc), escape.Replace(specName), escape.Replace(v)
+	}
+	return strings.NewReplacer(
+		"{date}", t.Format("2006-01-02"),
+		"{hour}", t.Format("15"),
+		"{port}", fmt.Sprintf("%03x", spec.Port),
+		"{i}", i,
+		"{j}", j,
+		"{desc}", desc,
+		"{spec}", specName,
+		"{vehicle}", v,
+		"{id}", v,
+		"{seq}", strconv.Itoa(seq),
+	).Replace(template)
+}
+
+// checkTemplate verifies that the template only contains known placeholders.
+func checkTemplate(template string) error {
+	for _, p := range placeholderRegexp.FindAllString(template, -1) {
+		if !placeholders[p] {
+			return fmt.Errorf("invalid template %q: unknown placeholder %s", template, p)
+		}
+	}
+	return nil
+}
+
+// check verifies that no two ports are recorded into the same file.
+func (l *layout) check(ports []RecorderPortSpec) error {
+	t := time.Now()
+	seen := make(map[string]*RecorderPortSpec)
+	for i := range ports {
+		p := l.path(&ports[i], t, 0)
+		if other, ok := seen[p]; ok {
+			return fmt.Errorf("layout %q records %s and %s into the same file", l.template, other, &ports[i])
+		}
+		seen[p] = &ports[i]
+	}
+	return nil
+}
+
+// patterns matched by each placeholder in the paths of the files
+var placeholderPatterns = map[string]string{
+	"{date}": `\d{4}-\d{2}-\d{2}`,
+	"{hour}": `\d{2}`,
+	"{port}": `[0-9a-f]{3}`,
+	"{i}":    `\d*`,
+	"{j}":    `\d*`,
+	"{desc}": `[^/]*`,
+	"{spec}": `[0-9a-f]{3}-[^/]*`,
+	"{seq}":  `\d+`,
+}
+
+// layoutMatcher recognizes the paths of the files of a layout, compressed
+// or not.
+type layoutMatcher struct {
+	re *regexp.Regexp
+	// placeholder of each group
+	names []string
+}
+
+// matcher returns a layoutMatcher for the paths relative to the output
+// directory. The {vehicle} and {id} placeholders match the vehicle of this
+// recorder, or any one if anyVehicle is set.
+func (l *layout) matcher(anyVehicle bool) *layoutMatcher {
+	template := l.template
+	if !anyVehicle {
+		v := slug(vehicle)
+		template = strings.NewReplacer("{vehicle}", v, "{id}", v).Replace(template)
+		// as filepath.Join does when the vehicle is empty
+		template = path.Clean(template)
+	}
+	return newLayoutMatcher(template, `^`)
+}
+
+// suffixMatcher returns a layoutMatcher for the end of any path, with the
+// files of every vehicle.
+func (l *layout) suffixMatcher() *layoutMatcher {
+	return newLayoutMatcher(l.template, `(?:^|/)`)
+}
+
+func newLayoutMatcher(template string, prefix string) *layoutMatcher {
+	m := &layoutMatcher{}
+	var sb strings.Builder
+	last := 0
+	for _, loc := range placeholderRegexp.FindAllStringIndex(template, -1) {
+		sb.WriteString(regexp.QuoteMeta(template[last:loc[0]]))
+		p := template[loc[0]:loc[1]]
+		pattern, ok := placeholderPatterns[p]
+		if !ok {
+			// {vehicle} or {id}
+			pattern = `[^/]+`
+		}
+		sb.WriteString("(" + pattern + ")")
+		m.names = append(m.names, p)
+		last = loc[1]
+	}
+	sb.WriteString(regexp.QuoteMeta(template[last:]))
+	var exts []string
+	for _, c := range compressors {
+		exts = append(exts, regexp.QuoteMeta(c.ext))
+	}
+	sort.Strings(exts)
+	m.re = regexp.MustCompile(prefix + sb.String() + `(` + strings.Join(exts, "|") + `)?$`)
+	return m
+}
+
+// match returns the values of the placeholders in a path, and the extension
+// of its compression, if any.
+func (m *layoutMatcher) match(rel string) (values map[string]string, ext string, ok bool) {
+	groups := m.re.FindStringSubmatch(filepath.ToSlash(rel))
+	if groups == nil {
+		return nil, "", false
+	}
+	values = make(map[string]string)
+	for i, name := range m.names {
+		v := groups[1+i]
+		// a placeholder used more than once has the same value
+		if prev, ok := values[name]; ok && prev != v {
+			return nil, "", false
+		}
+		values[name] = v
+	}
+	return values, groups[len(groups)-1], true
+}
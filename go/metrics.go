@@ -0,0 +1,172 @@
+package mvb
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var metricsAddr = ""
+
+func initMetricsFlags() {
+	flag.StringVar(&metricsAddr, "metrics", metricsAddr, "serve Prometheus metrics on this address, e.g. :9100")
+}
+
+// Metrics keeps the counters exported on the /metrics endpoint, in the
+// Prometheus text format.
+type Metrics struct {
+	mu        sync.Mutex
+	telegrams [16]uint64
+	errors    map[string]uint64
+	watched   []RecorderPortSpec
+	values    [][]byte
+	bus       *Bus
+}
+
+func NewMetrics(watched []RecorderPortSpec) *Metrics {
+	return &Metrics{
+		errors:  make(map[string]uint64),
+		watched: watched,
+		values:  make([][]byte, len(watched)),
+	}
+}
+
+// StartMetrics serves the metrics on the address given with -metrics. It
+// returns nil if the flag was not set.
+func StartMetrics(watched []RecorderPortSpec) *Metrics {
+	if metricsAddr == "" {
+		return nil
+	}
+	m := NewMetrics(watched)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go func() {
+		err := http.ListenAndServe(metricsAddr, mux)
+		logEvent("metrics_error", "addr", metricsAddr, "err", err)
+	}()
+	return m
+}
+
+// Loop counts the events received from mvbEvents until it is closed.
+func (m *Metrics) Loop(mvbEvents chan Event) {
+	for ev := range mvbEvents {
+		m.Count(ev)
+	}
+}
+
+// WatchBus exports the events dropped by each subscriber of b. It does
+// nothing if m is nil.
+func (m *Metrics) WatchBus(b *Bus) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bus = b
+}
+
+func (m *Metrics) Count(ev Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch ev := ev.(type) {
+	case *Telegram:
+		m.telegrams[ev.Master.FCode]++
+		if fcodes[ev.Master.FCode].MasterRequest != MR_PROCESS_DATA || ev.Slave == nil {
+			return
+		}
+		for i, w := range m.watched {
+			if w.Port == ev.Master.Address {
+				m.values[i] = slice(ev.Slave.data, w.I, w.J)
+			}
+		}
+	case Error:
+		m.errors[ev.Class()]++
+	}
+}
+
+// ServeHTTP writes a copy of the counters, so that a slow client doesn't
+// hold the lock that Count needs.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	telegrams := m.telegrams
+	errors := make(map[string]uint64, len(m.errors))
+	for c, n := range m.errors {
+		errors[c] = n
+	}
+	values := append([][]byte(nil), m.values...)
+	bus := m.bus
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP mvb_telegrams_total Decoded telegrams, by fcode.")
+	fmt.Fprintln(w, "# TYPE mvb_telegrams_total counter")
+	for fcode, n := range telegrams {
+		fmt.Fprintf(w, "mvb_telegrams_total{fcode=\"%d\",request=\"%s\"} %d\n",
+			fcode, fcodes[uint8(fcode)].MasterRequest, n)
+	}
+
+	fmt.Fprintln(w, "# HELP mvb_errors_total Decoding errors, by class.")
+	fmt.Fprintln(w, "# TYPE mvb_errors_total counter")
+	classes := make([]string, 0, len(errorClasses)+1)
+	for _, c := range errorClasses {
+		classes = append(classes, c.class)
+	}
+	classes = append(classes, "other")
+	for c := range errors {
+		classes = append(classes, c)
+	}
+	sort.Strings(classes)
+	for i, c := range classes {
+		if i > 0 && classes[i-1] == c {
+			continue
+		}
+		fmt.Fprintf(w, "mvb_errors_total{class=\"%s\"} %d\n", c, errors[c])
+	}
+
+	fmt.Fprintln(w, "# HELP mvb_var_value Current value of the watched variables, as a big-endian unsigned integer.")
+	fmt.Fprintln(w, "# TYPE mvb_var_value gauge")
+	for i, spec := range m.watched {
+		v := values[i]
+		if v == nil || len(v) > 8 {
+			continue
+		}
+		var buf [8]byte
+		copy(buf[8-len(v):], v)
+		fmt.Fprintf(w, "mvb_var_value{port=\"%03x\",range=\"%s\",desc=\"%s\"} %d\n",
+			spec.Port, specRange(&spec), escapeLabel(spec.Desc), binary.BigEndian.Uint64(buf[:]))
+	}
+
+	if bus == nil {
+		return
+	}
+	subs := bus.Subscribers()
+	fmt.Fprintln(w, "# HELP mvb_dropped_events_total Events dropped because a consumer fell behind the decoder.")
+	fmt.Fprintln(w, "# TYPE mvb_dropped_events_total counter")
+	for _, s := range subs {
+		fmt.Fprintf(w, "mvb_dropped_events_total{consumer=\"%s\"} %d\n", escapeLabel(s.Name), s.Dropped())
+	}
+	fmt.Fprintln(w, "# HELP mvb_stalled_events_total Events for which a blocking consumer stalled the decoder.")
+	fmt.Fprintln(w, "# TYPE mvb_stalled_events_total counter")
+	for _, s := range subs {
+		fmt.Fprintf(w, "mvb_stalled_events_total{consumer=\"%s\"} %d\n", escapeLabel(s.Name), s.Stalls())
+	}
+}
+
+func specRange(s *RecorderPortSpec) string {
+	if s.I == -1 {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", s.I, s.J)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
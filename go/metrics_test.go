@@ -0,0 +1,58 @@
+package mvb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingWriter blocks each write until unblocked.
+type blockingWriter struct {
+	httptest.ResponseRecorder
+	writing chan bool
+	unblock chan bool
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	select {
+	case w.writing <- true:
+	default:
+	}
+	<-w.unblock
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics([]RecorderPortSpec{{0x014, 0, 2, "motor current"}})
+	m.Count(testTelegram(1, 0x014, "012c00"))
+	m.Count(testTelegram(2, 0x020, "01"))
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`mvb_telegrams_total{fcode="1",request="PROCESS_DATA"} 2`,
+		`mvb_var_value{port="014",range="0-2",desc="motor current"} 300`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("missing %s in:\n%s", want, rec.Body)
+		}
+	}
+
+	// a slow client doesn't hold the decoder
+	w := &blockingWriter{*httptest.NewRecorder(), make(chan bool, 1), make(chan bool)}
+	go m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	<-w.writing
+	counted := make(chan bool)
+	go func() {
+		m.Count(testTelegram(3, 0x014, "0001"))
+		counted <- true
+	}()
+	select {
+	case <-counted:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Count blocked by ServeHTTP")
+	}
+	close(w.unblock)
+}
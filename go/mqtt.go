@@ -0,0 +1,232 @@
+package mvb
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	mqttBroker   = ""
+	mqttTopic    = "mvb/{port}/{desc}"
+	mqttQoS      = 1
+	mqttRetain   = true
+	mqttClientID = ""
+	mqttUser     = ""
+	mqttPassword = ""
+	mqttQueue    = 10000
+)
+
+func initMQTTFlags() {
+	flag.StringVar(&mqttBroker, "mqtt", mqttBroker, "publish changes to this MQTT broker, e.g. tcp://localhost:1883")
+	flag.StringVar(&mqttTopic, "mqtt-topic", mqttTopic, "MQTT topic template, with the same placeholders as -layout")
+	flag.IntVar(&mqttQoS, "mqtt-qos", mqttQoS, "MQTT QoS level (0, 1 or 2)")
+	flag.BoolVar(&mqttRetain, "mqtt-retain", mqttRetain, "publish retained messages, so that the broker keeps the last value")
+	flag.StringVar(&mqttClientID, "mqtt-client-id", mqttClientID, "MQTT client id (default: mvb-<hostname>)")
+	flag.StringVar(&mqttUser, "mqtt-user", mqttUser, "MQTT user name")
+	flag.StringVar(&mqttPassword, "mqtt-password", mqttPassword, "MQTT password")
+	flag.IntVar(&mqttQueue, "mqtt-queue", mqttQueue, "maximum amount of messages queued while the broker is unreachable")
+}
+
+const (
+	mqttPublishTimeout = 10 * time.Second
+	mqttCloseTimeout   = 5 * time.Second
+	mqttRetryInterval  = 1 * time.Second
+)
+
+// mqttTopicEscaper replaces the wildcards and the level separator in the
+// values of the placeholders of a topic.
+var mqttTopicEscaper = strings.NewReplacer("+", "-", "#", "-", "/", "-")
+
+// mqttClient is the part of mqtt.Client used by MQTTSink.
+type mqttClient interface {
+	Connect() mqtt.Token
+	IsConnectionOpen() bool
+	Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token
+	Disconnect(quiesce uint)
+}
+
+// MQTTSink publishes each change of the recorded variables as a JSON message.
+// Messages are queued in memory while the broker is unreachable, dropping the
+// oldest ones when the queue is full.
+type MQTTSink struct {
+	client mqttClient
+
+	mu      sync.Mutex
+	queue   []*mqttMessage
+	dropped int
+
+	wake    chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+}
+
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+type mqttPayload struct {
+	Port    string `json:"port"`
+	Range   string `json:"range,omitempty"`
+	Desc    string `json:"desc"`
+	Vehicle string `json:"vehicle,omitempty"`
+	Time    string `json:"time"`
+	Value   string `json:"value"`
+}
+
+func NewMQTTSink(broker string) (*MQTTSink, error) {
+	if err := checkTemplate(mqttTopic); err != nil {
+		return nil, err
+	}
+	if mqttQoS < 0 || mqttQoS > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS: %d", mqttQoS)
+	}
+	clientID := mqttClientID
+	if clientID == "" {
+		hostname, _ := os.Hostname()
+		clientID = "mvb-" + hostname
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(mqttUser).
+		SetPassword(mqttPassword).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(mqttRetryInterval).
+		SetOnConnectHandler(func(mqtt.Client) {
+			logEvent("mqtt_connected", "broker", broker)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logEvent("mqtt_connection_lost", "broker", broker, "err", err)
+		})
+
+	return newMQTTSink(mqtt.NewClient(opts)), nil
+}
+
+func newMQTTSink(client mqttClient) *MQTTSink {
+	s := &MQTTSink{
+		client:  client,
+		wake:    make(chan struct{}, 1),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	// with SetConnectRetry, Connect keeps retrying in the background
+	s.client.Connect()
+	go s.loop()
+	return s
+}
+
+func (s *MQTTSink) Write(spec *RecorderPortSpec, t time.Time, value []byte) {
+	payload, err := json.Marshal(&mqttPayload{
+		Port:    fmt.Sprintf("%03x", spec.Port),
+		Range:   specRange(spec),
+		Desc:    spec.Desc,
+		Vehicle: vehicle,
+		Time:    t.Format(time.RFC3339Nano),
+		Value:   hex.EncodeToString(value),
+	})
+	if err != nil {
+		panic(err)
+	}
+	m := &mqttMessage{topic: expandTemplate(mqttTopic, spec, t, 0, mqttTopicEscaper), payload: payload}
+
+	s.mu.Lock()
+	s.queue = append(s.queue, m)
+	if len(s.queue) > mqttQueue {
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.dropped++
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *MQTTSink) front() *mqttMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return nil
+	}
+	return s.queue[0]
+}
+
+func (s *MQTTSink) pop(m *mqttMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the message may have been dropped meanwhile
+	if len(s.queue) > 0 && s.queue[0] == m {
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+	}
+	if len(s.queue) == 0 && s.dropped > 0 {
+		logEvent("mqtt_dropped", "messages", s.dropped)
+		s.dropped = 0
+	}
+}
+
+// publishQueued publishes the queued messages in order, and returns false
+// if the broker is unreachable.
+func (s *MQTTSink) publishQueued() bool {
+	for m := s.front(); m != nil; m = s.front() {
+		if !s.client.IsConnectionOpen() {
+			return false
+		}
+		token := s.client.Publish(m.topic, byte(mqttQoS), mqttRetain, m.payload)
+		if !token.WaitTimeout(mqttPublishTimeout) {
+			logEvent("mqtt_error", "topic", m.topic, "err", "publish timeout")
+			return false
+		}
+		if err := token.Error(); err != nil {
+			logEvent("mqtt_error", "topic", m.topic, "err", err)
+			return false
+		}
+		s.pop(m)
+	}
+	return true
+}
+
+func (s *MQTTSink) loop() {
+	defer close(s.done)
+	retry := time.NewTicker(mqttRetryInterval)
+	defer retry.Stop()
+	for {
+		select {
+		case <-s.wake:
+		case <-retry.C:
+		case <-s.closing:
+			s.publishQueued()
+			return
+		}
+		s.publishQueued()
+	}
+}
+
+// Close publishes the queued messages, waiting up to a few seconds for the
+// broker, and disconnects.
+func (s *MQTTSink) Close() {
+	close(s.closing)
+	select {
+	case <-s.done:
+	case <-time.After(mqttCloseTimeout):
+	}
+	s.mu.Lock()
+	if len(s.queue) > 0 {
+		logEvent("mqtt_lost", "messages", len(s.queue))
+	}
+	s.mu.Unlock()
+	s.client.Disconnect(250)
+}
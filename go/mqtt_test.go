@@ -0,0 +1,147 @@
+package mvb
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// doneToken is a token of a completed operation.
+type doneToken struct {
+	err error
+}
+
+func (t *doneToken) Wait() bool                     { return true }
+func (t *doneToken) WaitTimeout(time.Duration) bool { return true }
+func (t *doneToken) Error() error                   { return t.err }
+
+func (t *doneToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// fakeMQTTClient sends the published messages to published, as "topic
+// payload".
+type fakeMQTTClient struct {
+	mu        sync.Mutex
+	connected bool
+	published chan string
+}
+
+func (c *fakeMQTTClient) Connect() mqtt.Token {
+	return &doneToken{}
+}
+
+func (c *fakeMQTTClient) IsConnectionOpen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+func (c *fakeMQTTClient) setConnected(connected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = connected
+}
+
+func (c *fakeMQTTClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.published <- topic + " " + string(payload.([]byte))
+	return &doneToken{}
+}
+
+func (c *fakeMQTTClient) Disconnect(quiesce uint) {
+	c.setConnected(false)
+}
+
+func TestMQTTSink(t *testing.T) {
+	checkLog := captureLog(t)
+	defer func(topic string, queue int) { mqttTopic, mqttQueue = topic, queue }(mqttTopic, mqttQueue)
+	mqttTopic, mqttQueue = "mvb/{port}/{desc}", 2
+
+	client := &fakeMQTTClient{published: make(chan string, 10)}
+	s := newMQTTSink(client)
+	speed := &RecorderPortSpec{0x014, 0, 2, "speed"}
+	at := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	// queued while the broker is unreachable, dropping the oldest
+	for i := byte(1); i <= 3; i++ {
+		s.Write(speed, at, []byte{0, i})
+	}
+	// published on the next retry
+	client.setConnected(true)
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-client.published:
+			if got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("not published: %s", want)
+		}
+	}
+	expect(`mvb/014/speed {"port":"014","range":"0-2","desc":"speed","time":"2026-10-18T12:00:00Z","value":"0002"}`)
+	expect(`mvb/014/speed {"port":"014","range":"0-2","desc":"speed","time":"2026-10-18T12:00:00Z","value":"0003"}`)
+
+	// the topic has a level for each placeholder, without wildcards
+	s.Write(&RecorderPortSpec{0x020, -1, -1, "doors 1/2 + 3/4 #"}, at, []byte{1})
+	expect(`mvb/020/doors-1-2---3-4-- {"port":"020","desc":"doors 1/2 + 3/4 #","time":"2026-10-18T12:00:00Z","value":"01"}`)
+	s.Close()
+	checkLog("event=mqtt_dropped messages=1")
+}
+
+// TestMQTTBroker publishes to the broker in $MVB_MQTT_BROKER, by default a
+// local one such as mosquitto, if there is any.
+func TestMQTTBroker(t *testing.T) {
+	broker := os.Getenv("MVB_MQTT_BROKER")
+	if broker == "" {
+		broker = "tcp://localhost:1883"
+	}
+	u, err := url.Parse(broker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, time.Second)
+	if err != nil {
+		t.Skipf("no MQTT broker: %v", err)
+	}
+	conn.Close()
+
+	sub := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker).SetClientID("mvb-test-sub"))
+	if token := sub.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	defer sub.Disconnect(250)
+	got := make(chan mqtt.Message, 10)
+	token := sub.Subscribe("mvb-test/#", 1, func(_ mqtt.Client, m mqtt.Message) { got <- m })
+	if token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	defer func(topic string, retain bool, id string) {
+		mqttTopic, mqttRetain, mqttClientID = topic, retain, id
+	}(mqttTopic, mqttRetain, mqttClientID)
+	mqttTopic, mqttRetain, mqttClientID = "mvb-test/{port}/{desc}", false, "mvb-test-pub"
+	s, err := NewMQTTSink(broker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Write(&RecorderPortSpec{0x014, 0, 2, "motor current"}, time.Now(), []byte{0x01, 0x2c})
+
+	select {
+	case m := <-got:
+		if m.Topic() != "mvb-test/014/motor-current" || !strings.Contains(string(m.Payload()), `"value":"012c"`) {
+			t.Errorf("got %s %s", m.Topic(), m.Payload())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("not received")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"math/bits"
+	"strings"
 )
 
 // 3.2.3.1 Signalling speed (bit period in seconds)
@@ -160,6 +161,15 @@ type SlaveFrame struct {
 	data []byte
 }
 
+func NewSlaveFrame(data []byte) *SlaveFrame {
+	return &SlaveFrame{data}
+}
+
+// Data returns the contents of the frame, without the check sequences.
+func (s *SlaveFrame) Data() []byte {
+	return s.data
+}
+
 func (s *SlaveFrame) String() string {
 	if s == nil {
 		return "-"
@@ -206,6 +216,30 @@ func (err Error) IsError() bool {
 	return true
 }
 
+var errorClasses = []struct {
+	prefix string
+	class  string
+}{
+	{"CRC mismatch", "crc"},
+	{"invalid start of frame", "start_of_frame"},
+	{"invalid start delimiter", "start_delimiter"},
+	{"expected symbol", "start_delimiter"},
+	{"failed reading end delimiter", "end_delimiter"},
+	{"expected bit", "bit"},
+	{"unexpected slave frame", "unexpected_slave"},
+}
+
+// Class returns a short name for the kind of decoding error.
+func (err Error) Class() string {
+	msg := err.Error()
+	for _, c := range errorClasses {
+		if strings.HasPrefix(msg, c.prefix) {
+			return c.class
+		}
+	}
+	return "other"
+}
+
 type Event interface {
 	N() uint64
 	IsError() bool
@@ -251,6 +285,12 @@ func calcCRC(message []byte) byte {
 	return ^crc
 }
 
+// CheckSequence returns the check sequence sent after data, of up to 8
+// bytes.
+func CheckSequence(data []byte) byte {
+	return calcCRC(data)
+}
+
 func checkCRC(data []byte, cs byte) error {
 	// 3.4.1.3 Check Sequence
 	calculated := calcCRC(data)
@@ -269,18 +309,32 @@ var (
 	slaveStartDelimiter  = []Symbol{BIT_1, BIT_1, BIT_1, NL, NH, BIT_1, NL, NH}
 )
 
+// StartDelimiter returns the start delimiter of master or slave frames.
+func StartDelimiter(master bool) []Symbol {
+	if master {
+		return append([]Symbol(nil), masterStartDelimiter...)
+	}
+	return append([]Symbol(nil), slaveStartDelimiter...)
+}
+
+// LookupFCode returns the description of a function code, or nil if fcode
+// is not a 4-bit value.
+func LookupFCode(fcode uint8) *FCode {
+	return fcodes[fcode]
+}
+
 type MVBDecoder struct {
-	stream *MVBStream
+	stream Stream
 }
 
-func NewDecoder(stream *MVBStream) *MVBDecoder {
+func NewDecoder(stream Stream) *MVBDecoder {
 	return &MVBDecoder{
 		stream: stream,
 	}
 }
 
 func (d *MVBDecoder) N() uint64 {
-	return d.stream.r.n
+	return d.stream.N()
 }
 
 func (d *MVBDecoder) ReadSymbol() (Symbol, error) {
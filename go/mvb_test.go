@@ -0,0 +1,207 @@
+package mvb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// Some of the telegrams of gen/gen.c, with their check sequences.
+var genTelegrams = []struct {
+	master []byte
+	slave  []byte
+}{
+	{[]byte{0x43, 0x90, 0xd6}, []byte{0x97, 0x1e, 0x00, 0x00, 0x00, 0x82, 0x14, 0x06, 0xdf, 0x1e, 0x0b, 0x31, 0x0f, 0x00, 0x17, 0x05, 0x8c, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x4d, 0xc9, 0x11, 0x94, 0x11, 0xa8, 0x11, 0xa8, 0x04, 0x05, 0x88}},
+	{[]byte{0x00, 0x01, 0x34}, []byte{0x97, 0x1e, 0x07}},
+	{[]byte{0xf0, 0xee, 0x24}, []byte{0x57, 0x08, 0x3c}},
+	{[]byte{0x30, 0x72, 0xf5}, []byte{0xa0, 0x00, 0xa0, 0x00, 0xa0, 0x00, 0xa0, 0x00, 0x60, 0xa0, 0x00, 0xa0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	{[]byte{0x02, 0xe4, 0x2c}, []byte{0x03, 0x00, 0xeb}},
+	{[]byte{0x41, 0x19, 0xb0}, []byte{0x00, 0x04, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x12, 0x03, 0x7a, 0x03, 0x79, 0x01, 0x04, 0x01, 0x67, 0x82, 0x00, 0x00, 0x03, 0x6f, 0x00, 0x00, 0x00, 0x00, 0xa6, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff}},
+	{[]byte{0x42, 0x9a, 0x36}, []byte{0x7f, 0x37, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x71, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0xf8, 0x00, 0xa6, 0x00, 0xc6, 0x01, 0x39, 0x00, 0x00, 0xb0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff}},
+}
+
+var streams = []struct {
+	name string
+	new  func(io.Reader) Stream
+}{
+	{"MVBStream", mvbStreamOf},
+	{"EdgeStream", edgeStreamOf},
+	{"RunStream", runStreamOf},
+}
+
+// symbolsEnd returns the position of the decoder after reading the given
+// number of symbols, counting the start bit, of a frame starting at start:
+// a quarter of a bit time into the next symbol.
+func symbolsEnd(start int, symbols int) uint64 {
+	return uint64(start + symbols*BT_SAMPLES + BT4_SAMPLES + 1)
+}
+
+// frameEnd returns the position of the decoder after reading a whole frame of
+// n bytes, including the check sequences.
+func frameEnd(start int, n int) uint64 {
+	return symbolsEnd(start, 1+8+8*n+1)
+}
+
+// stripCRC removes the check sequence after every 8 bytes of data.
+func stripCRC(frame []byte) []byte {
+	var data []byte
+	for i := 0; i < len(frame); i += 9 {
+		end := i + 9
+		if end > len(frame) {
+			end = len(frame)
+		}
+		data = append(data, frame[i:end-1]...)
+	}
+	return data
+}
+
+func telegramSummary(n uint64, master []byte, slave []byte) string {
+	s := fmt.Sprintf("%d: telegram %x %03x", n, master[0]>>4, uint16(master[0]&0xf)<<8|uint16(master[1]))
+	if slave != nil {
+		s += fmt.Sprintf(" %x", slave)
+	}
+	return s
+}
+
+func summarize(ev Event) string {
+	switch ev := ev.(type) {
+	case *Telegram:
+		s := fmt.Sprintf("%d: telegram %x %03x", ev.N(), ev.Master.FCode, ev.Master.Address)
+		if ev.Slave != nil {
+			s += fmt.Sprintf(" %x", ev.Slave.data)
+		}
+		return s
+	case Error:
+		return fmt.Sprintf("%d: error %s", ev.N(), ev.Class())
+	}
+	return fmt.Sprint(ev)
+}
+
+// checkDecode decodes the samples with every Stream, and compares the events
+// up to the end of the input.
+func checkDecode(t *testing.T, samples []byte, want []string) {
+	t.Helper()
+	for _, stream := range streams {
+		events := decodeAll(stream.new(bytes.NewReader(samples)))
+		// the last event is the end of the input
+		events = events[:len(events)-1]
+		var got []string
+		for _, ev := range events {
+			got = append(got, summarize(ev))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s:\ngot  %q\nwant %q", stream.name, got, want)
+		}
+	}
+}
+
+func TestDecodeGenTelegrams(t *testing.T) {
+	var s synth
+	var want []string
+	for _, tg := range genTelegrams {
+		s.level(HIGH, 40)
+		s.frame(true, tg.master)
+		s.level(HIGH, 60)
+		start := s.buf.Len()
+		s.frame(false, tg.slave)
+		want = append(want, telegramSummary(frameEnd(start, len(tg.slave)), tg.master, stripCRC(tg.slave)))
+	}
+	s.level(HIGH, 100)
+	checkDecode(t, s.buf.Bytes(), want)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	good := genTelegrams[1]
+	master := genTelegrams[2].master
+
+	tests := []struct {
+		name string
+		// writes a faulty frame, and returns the expected events
+		fault func(s *synth) []string
+	}{
+		{"crc", func(s *synth) []string {
+			start := s.buf.Len()
+			s.frame(true, []byte{master[0], master[1], master[2] ^ 0x10})
+			return []string{fmt.Sprintf("%d: error crc", symbolsEnd(start, 1+8+24))}
+		}},
+		{"unexpected slave", func(s *synth) []string {
+			start := s.buf.Len()
+			s.frame(false, good.slave)
+			return []string{fmt.Sprintf("%d: error unexpected_slave", symbolsEnd(start, 1+8))}
+		}},
+		{"start delimiter", func(s *synth) []string {
+			start := s.buf.Len()
+			s.symbol(BIT_1)
+			for _, sym := range []Symbol{NH, NL, BIT_0, BIT_1} {
+				s.symbol(sym)
+			}
+			s.symbol(NH)
+			return []string{fmt.Sprintf("%d: error start_delimiter", symbolsEnd(start, 1+4))}
+		}},
+		{"bit", func(s *synth) []string {
+			start := s.buf.Len()
+			s.symbol(BIT_1)
+			for _, sym := range masterStartDelimiter {
+				s.symbol(sym)
+			}
+			s.symbol(BIT_0)
+			s.symbol(NH)
+			s.symbol(NH)
+			return []string{fmt.Sprintf("%d: error bit", symbolsEnd(start, 1+8+2))}
+		}},
+		{"end delimiter", func(s *synth) []string {
+			start := s.buf.Len()
+			s.symbol(BIT_1)
+			for _, sym := range masterStartDelimiter {
+				s.symbol(sym)
+			}
+			for _, b := range master {
+				for i := 7; i >= 0; i-- {
+					s.symbol(Symbol(b >> i & 1))
+				}
+			}
+			s.symbol(NH)
+			return []string{fmt.Sprintf("%d: error end_delimiter", frameEnd(start, len(master)))}
+		}},
+		{"missing slave reply", func(s *synth) []string {
+			s.frame(true, master)
+			s.level(HIGH, 60)
+			// the master frame without reply is reported with the next one
+			start := s.buf.Len()
+			s.frame(true, good.master)
+			s.level(HIGH, 60)
+			slaveStart := s.buf.Len()
+			s.frame(false, good.slave)
+			return []string{
+				telegramSummary(frameEnd(start, 3), master, nil),
+				telegramSummary(frameEnd(slaveStart, len(good.slave)), good.master, stripCRC(good.slave)),
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s synth
+			s.level(HIGH, 40)
+			want := tt.fault(&s)
+			// the decoder recovers at the next telegram
+			s.level(HIGH, 100)
+			s.frame(true, good.master)
+			s.level(HIGH, 60)
+			start := s.buf.Len()
+			s.frame(false, good.slave)
+			s.level(HIGH, 100)
+			want = append(want, telegramSummary(frameEnd(start, len(good.slave)), good.master, stripCRC(good.slave)))
+			checkDecode(t, s.buf.Bytes(), want)
+		})
+	}
+}
+
+func TestCalcCRC(t *testing.T) {
+	for _, tg := range genTelegrams {
+		if err := checkCRC(tg.master[:2], tg.master[2]); err != nil {
+			t.Errorf("master %x: %v", tg.master, err)
+		}
+	}
+}
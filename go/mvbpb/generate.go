@@ -0,0 +1,4 @@
+// Package mvbpb contains the code generated from mvb.proto.
+package mvbpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative mvb.proto
@@ -0,0 +1,412 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.21.12
+// source: mvb.proto
+
+package mvbpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// MVBClient is the client API for MVB service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MVBClient interface {
+	GetPort(ctx context.Context, in *GetPortRequest, opts ...grpc.CallOption) (*Port, error)
+	ListPorts(ctx context.Context, in *ListPortsRequest, opts ...grpc.CallOption) (*ListPortsResponse, error)
+	ListVariables(ctx context.Context, in *ListVariablesRequest, opts ...grpc.CallOption) (*ListVariablesResponse, error)
+	GetDeviceStatus(ctx context.Context, in *GetDeviceStatusRequest, opts ...grpc.CallOption) (*DeviceStatus, error)
+	GetRates(ctx context.Context, in *GetRatesRequest, opts ...grpc.CallOption) (*Rates, error)
+	ListErrors(ctx context.Context, in *ListErrorsRequest, opts ...grpc.CallOption) (*ListErrorsResponse, error)
+	SubscribePorts(ctx context.Context, in *SubscribePortsRequest, opts ...grpc.CallOption) (MVB_SubscribePortsClient, error)
+	SubscribeVariables(ctx context.Context, in *SubscribeVariablesRequest, opts ...grpc.CallOption) (MVB_SubscribeVariablesClient, error)
+}
+
+type mVBClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMVBClient(cc grpc.ClientConnInterface) MVBClient {
+	return &mVBClient{cc}
+}
+
+func (c *mVBClient) GetPort(ctx context.Context, in *GetPortRequest, opts ...grpc.CallOption) (*Port, error) {
+	out := new(Port)
+	err := c.cc.Invoke(ctx, "/mvb.v1.MVB/GetPort", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mVBClient) ListPorts(ctx context.Context, in *ListPortsRequest, opts ...grpc.CallOption) (*ListPortsResponse, error) {
+	out := new(ListPortsResponse)
+	err := c.cc.Invoke(ctx, "/mvb.v1.MVB/ListPorts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mVBClient) ListVariables(ctx context.Context, in *ListVariablesRequest, opts ...grpc.CallOption) (*ListVariablesResponse, error) {
+	out := new(ListVariablesResponse)
+	err := c.cc.Invoke(ctx, "/mvb.v1.MVB/ListVariables", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mVBClient) GetDeviceStatus(ctx context.Context, in *GetDeviceStatusRequest, opts ...grpc.CallOption) (*DeviceStatus, error) {
+	out := new(DeviceStatus)
+	err := c.cc.Invoke(ctx, "/mvb.v1.MVB/GetDeviceStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mVBClient) GetRates(ctx context.Context, in *GetRatesRequest, opts ...grpc.CallOption) (*Rates, error) {
+	out := new(Rates)
+	err := c.cc.Invoke(ctx, "/mvb.v1.MVB/GetRates", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mVBClient) ListErrors(ctx context.Context, in *ListErrorsRequest, opts ...grpc.CallOption) (*ListErrorsResponse, error) {
+	out := new(ListErrorsResponse)
+	err := c.cc.Invoke(ctx, "/mvb.v1.MVB/ListErrors", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mVBClient) SubscribePorts(ctx context.Context, in *SubscribePortsRequest, opts ...grpc.CallOption) (MVB_SubscribePortsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MVB_ServiceDesc.Streams[0], "/mvb.v1.MVB/SubscribePorts", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &mVBSubscribePortsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MVB_SubscribePortsClient interface {
+	Recv() (*PortChange, error)
+	grpc.ClientStream
+}
+
+type mVBSubscribePortsClient struct {
+	grpc.ClientStream
+}
+
+func (x *mVBSubscribePortsClient) Recv() (*PortChange, error) {
+	m := new(PortChange)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *mVBClient) SubscribeVariables(ctx context.Context, in *SubscribeVariablesRequest, opts ...grpc.CallOption) (MVB_SubscribeVariablesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MVB_ServiceDesc.Streams[1], "/mvb.v1.MVB/SubscribeVariables", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &mVBSubscribeVariablesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MVB_SubscribeVariablesClient interface {
+	Recv() (*VariableChange, error)
+	grpc.ClientStream
+}
+
+type mVBSubscribeVariablesClient struct {
+	grpc.ClientStream
+}
+
+func (x *mVBSubscribeVariablesClient) Recv() (*VariableChange, error) {
+	m := new(VariableChange)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// MVBServer is the server API for MVB service.
+// All implementations must embed UnimplementedMVBServer
+// for forward compatibility
+type MVBServer interface {
+	GetPort(context.Context, *GetPortRequest) (*Port, error)
+	ListPorts(context.Context, *ListPortsRequest) (*ListPortsResponse, error)
+	ListVariables(context.Context, *ListVariablesRequest) (*ListVariablesResponse, error)
+	GetDeviceStatus(context.Context, *GetDeviceStatusRequest) (*DeviceStatus, error)
+	GetRates(context.Context, *GetRatesRequest) (*Rates, error)
+	ListErrors(context.Context, *ListErrorsRequest) (*ListErrorsResponse, error)
+	SubscribePorts(*SubscribePortsRequest, MVB_SubscribePortsServer) error
+	SubscribeVariables(*SubscribeVariablesRequest, MVB_SubscribeVariablesServer) error
+	mustEmbedUnimplementedMVBServer()
+}
+
+// UnimplementedMVBServer must be embedded to have forward compatible implementations.
+type UnimplementedMVBServer struct {
+}
+
+func (UnimplementedMVBServer) GetPort(context.Context, *GetPortRequest) (*Port, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPort not implemented")
+}
+func (UnimplementedMVBServer) ListPorts(context.Context, *ListPortsRequest) (*ListPortsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPorts not implemented")
+}
+func (UnimplementedMVBServer) ListVariables(context.Context, *ListVariablesRequest) (*ListVariablesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVariables not implemented")
+}
+func (UnimplementedMVBServer) GetDeviceStatus(context.Context, *GetDeviceStatusRequest) (*DeviceStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDeviceStatus not implemented")
+}
+func (UnimplementedMVBServer) GetRates(context.Context, *GetRatesRequest) (*Rates, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRates not implemented")
+}
+func (UnimplementedMVBServer) ListErrors(context.Context, *ListErrorsRequest) (*ListErrorsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListErrors not implemented")
+}
+func (UnimplementedMVBServer) SubscribePorts(*SubscribePortsRequest, MVB_SubscribePortsServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribePorts not implemented")
+}
+func (UnimplementedMVBServer) SubscribeVariables(*SubscribeVariablesRequest, MVB_SubscribeVariablesServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeVariables not implemented")
+}
+func (UnimplementedMVBServer) mustEmbedUnimplementedMVBServer() {}
+
+// UnsafeMVBServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MVBServer will
+// result in compilation errors.
+type UnsafeMVBServer interface {
+	mustEmbedUnimplementedMVBServer()
+}
+
+func RegisterMVBServer(s grpc.ServiceRegistrar, srv MVBServer) {
+	s.RegisterService(&MVB_ServiceDesc, srv)
+}
+
+func _MVB_GetPort_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPortRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MVBServer).GetPort(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mvb.v1.MVB/GetPort",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MVBServer).GetPort(ctx, req.(*GetPortRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MVB_ListPorts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPortsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MVBServer).ListPorts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mvb.v1.MVB/ListPorts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MVBServer).ListPorts(ctx, req.(*ListPortsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MVB_ListVariables_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVariablesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MVBServer).ListVariables(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mvb.v1.MVB/ListVariables",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MVBServer).ListVariables(ctx, req.(*ListVariablesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MVB_GetDeviceStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDeviceStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MVBServer).GetDeviceStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mvb.v1.MVB/GetDeviceStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MVBServer).GetDeviceStatus(ctx, req.(*GetDeviceStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MVB_GetRates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MVBServer).GetRates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mvb.v1.MVB/GetRates",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MVBServer).GetRates(ctx, req.(*GetRatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MVB_ListErrors_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListErrorsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MVBServer).ListErrors(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mvb.v1.MVB/ListErrors",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MVBServer).ListErrors(ctx, req.(*ListErrorsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MVB_SubscribePorts_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribePortsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MVBServer).SubscribePorts(m, &mVBSubscribePortsServer{stream})
+}
+
+type MVB_SubscribePortsServer interface {
+	Send(*PortChange) error
+	grpc.ServerStream
+}
+
+type mVBSubscribePortsServer struct {
+	grpc.ServerStream
+}
+
+func (x *mVBSubscribePortsServer) Send(m *PortChange) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MVB_SubscribeVariables_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeVariablesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MVBServer).SubscribeVariables(m, &mVBSubscribeVariablesServer{stream})
+}
+
+type MVB_SubscribeVariablesServer interface {
+	Send(*VariableChange) error
+	grpc.ServerStream
+}
+
+type mVBSubscribeVariablesServer struct {
+	grpc.ServerStream
+}
+
+func (x *mVBSubscribeVariablesServer) Send(m *VariableChange) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// MVB_ServiceDesc is the grpc.ServiceDesc for MVB service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MVB_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "mvb.v1.MVB",
+	HandlerType: (*MVBServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetPort",
+			Handler:    _MVB_GetPort_Handler,
+		},
+		{
+			MethodName: "ListPorts",
+			Handler:    _MVB_ListPorts_Handler,
+		},
+		{
+			MethodName: "ListVariables",
+			Handler:    _MVB_ListVariables_Handler,
+		},
+		{
+			MethodName: "GetDeviceStatus",
+			Handler:    _MVB_GetDeviceStatus_Handler,
+		},
+		{
+			MethodName: "GetRates",
+			Handler:    _MVB_GetRates_Handler,
+		},
+		{
+			MethodName: "ListErrors",
+			Handler:    _MVB_ListErrors_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribePorts",
+			Handler:       _MVB_SubscribePorts_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SubscribeVariables",
+			Handler:       _MVB_SubscribeVariables_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "mvb.proto",
+}
@@ -20,10 +20,46 @@ func main() {
 	if err != nil {
 		usage()
 	}
+	if err := mvb.InitConditions(ports); err != nil {
+		log.Fatal(err)
+	}
+
+	recorder, err := mvb.NewRecorder(ports)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	alarms, err := mvb.NewAlarms(ports)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stream, err := mvb.NewInputStream()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	events := make(chan mvb.Event)
-	decoder := mvb.NewDecoder(mvb.NewMVBStream())
+	decoder := mvb.NewDecoder(stream)
 	go decoder.Loop(events)
+	metrics := mvb.StartMetrics(ports)
+	grpc, err := mvb.StartGRPC(ports)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	mvb.NewRecorder(ports).Loop(events)
+	bus := mvb.NewBus()
+	metrics.WatchBus(bus)
+	if metrics != nil {
+		bus.Go("metrics", mvb.OverflowFlag, metrics.Loop)
+	}
+	if grpc != nil {
+		bus.Go("grpc", mvb.OverflowFlag, grpc.Loop)
+	}
+	bus.Go("recorder", mvb.Block, recorder.Loop)
+	if alarms != nil {
+		bus.Go("alarms", mvb.OverflowFlag, alarms.Loop)
+	}
+	go bus.Loop(events)
+	bus.Wait()
 }
@@ -4,15 +4,48 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 )
 
+var (
+	// maximum amount of bytes per port kept in memory while the disk is not
+	// writable
+	bufferLimit = 1 << 20
+
+	// interval between writes to disk; each write is followed by a fsync
+	fsyncInterval = 1 * time.Second
+
+	// repeat the current value if it did not change in this interval
+	keepAlive time.Duration
+
+	// mark a port as stale if it is not polled in this interval
+	staleTimeout time.Duration
+
+	// record only while this condition holds, compiled by InitConditions
+	recordIfFlag string
+	recordIf     *Expr
+)
+
+func initRecorderFlags() {
+	flag.IntVar(&bufferLimit, "buffer", bufferLimit, "bytes per port to buffer in memory when the disk is not writable")
+	flag.DurationVar(&fsyncInterval, "fsync", fsyncInterval, "interval between writes to disk (0: write and sync every change)")
+	flag.DurationVar(&keepAlive, "keepalive", keepAlive, "repeat unchanged values with this interval (0: disabled)")
+	flag.DurationVar(&staleTimeout, "stale", staleTimeout, "write a stale marker for ports not polled in this interval (0: disabled)")
+	flag.StringVar(&recordIfFlag, "record-if", "", "record only while this condition holds, e.g. 'port(0x014)[0:2] as uint16 > 0'")
+	initLayoutFlags()
+	initRetentionFlags()
+	initMQTTFlags()
+}
+
 type RecorderPortSpec struct {
 	Port uint16
 	I    int
@@ -33,18 +66,60 @@ func slug(s string) string {
 	return s
 }
 
+// RecorderSink receives the changes of the recorded variables, in addition
+// to the CSV files.
+type RecorderSink interface {
+	Write(spec *RecorderPortSpec, t time.Time, value []byte)
+	Close()
+}
+
 type Recorder struct {
-	ports []*portRecorder
+	ports       []*portRecorder
+	layout      *layout
+	openFiles   *openFiles
+	sinks       []RecorderSink
+	maintenance chan struct{}
+	// events dropped since the last heartbeat, see Overrun
+	dropped uint64
+
+	// -record-if, evaluated over stats
+	cond      *Expr
+	stats     Stats
+	recording bool
 }
 
-func NewRecorder(ports []RecorderPortSpec) *Recorder {
-	r := &Recorder{}
+func NewRecorder(ports []RecorderPortSpec) (*Recorder, error) {
+	l, err := newLayout(outDir, layoutFlag, rotationFlag)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.check(ports); err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		layout:      l,
+		openFiles:   newOpenFiles(),
+		maintenance: make(chan struct{}, 1),
+		cond:        recordIf,
+		stats:       NewStats(),
+		recording:   true,
+	}
+	if mqttBroker != "" {
+		sink, err := NewMQTTSink(mqttBroker)
+		if err != nil {
+			return nil, err
+		}
+		r.sinks = append(r.sinks, sink)
+	}
 	for _, portSpec := range ports {
 		r.ports = append(r.ports, &portRecorder{
 			RecorderPortSpec: portSpec,
+			layout:           l,
+			openFiles:        r.openFiles,
+			sinks:            r.sinks,
 		})
 	}
-	return r
+	return r, nil
 }
 
 func (r *Recorder) logError(err Error) {
@@ -55,23 +130,68 @@ func (r *Recorder) Loop(mvbEvents chan Event) {
 	sigint := make(chan os.Signal, 1)
 	signal.Notify(sigint, os.Interrupt)
 
+	flushInterval := fsyncInterval
+	if flushInterval <= 0 {
+		flushInterval = retryInterval
+	}
+	flushTicker := time.NewTicker(flushInterval)
+	defer flushTicker.Stop()
+	heartbeatTicker := time.NewTicker(heartbeatInterval)
+	defer heartbeatTicker.Stop()
+	maintenanceTicker := time.NewTicker(maintenanceInterval)
+	defer maintenanceTicker.Stop()
+	r.maintain(time.Now())
+
+	for _, p := range r.ports {
+		p.lastPolled = time.Now()
+		p.recover(p.lastPolled)
+	}
+
 	done := false
 	for !done {
 		select {
-		case ev := <-mvbEvents:
+		case ev, ok := <-mvbEvents:
+			if !ok {
+				done = true
+				break
+			}
 			switch t := ev.(type) {
 			case *Telegram:
+				record := r.check(t)
 				fcode := fcodes[t.Master.FCode]
 				if fcode.MasterRequest == MR_PROCESS_DATA && t.Slave != nil {
 					for _, p := range r.ports {
-						if t.Master.Address == p.Port {
+						if t.Master.Address != p.Port {
+							continue
+						}
+						if record {
 							p.write(time.Now(), slice(t.Slave.data, p.I, p.J))
+						} else {
+							p.skip(time.Now())
 						}
 					}
 				}
 			case Error:
 				r.logError(t)
+				r.check(t)
+			case *Overrun:
+				r.dropped += t.Dropped
+			}
+		case now := <-flushTicker.C:
+			for _, p := range r.ports {
+				p.flush(now)
+			}
+		case now := <-heartbeatTicker.C:
+			r.stats.Tick()
+			for _, p := range r.ports {
+				p.heartbeat(now)
+			}
+			if r.dropped > 0 {
+				logEvent("overrun", "dropped", r.dropped)
+				r.dropped = 0
 			}
+		case now := <-maintenanceTicker.C:
+			r.maintain(now)
 		case <-sigint:
 			log.Printf("interrupt - quitting...")
 			done = true
@@ -81,67 +201,462 @@ func (r *Recorder) Loop(mvbEvents chan Event) {
 	for _, p := range r.ports {
 		p.close()
 	}
+	for _, s := range r.sinks {
+		s.Close()
+	}
+	// wait for the maintenance in progress, so that no compression is left
+	// halfway
+	r.maintenance <- struct{}{}
+}
+
+// check reports whether to record after ev, according to -record-if.
+func (r *Recorder) check(ev Event) bool {
+	if r.cond == nil {
+		return true
+	}
+	switch ev := ev.(type) {
+	case *Telegram:
+		r.stats.CountTelegram(ev)
+	case Error:
+		r.stats.CountError(ev)
+	}
+	if record := r.cond.Eval(ev, &r.stats); record != r.recording {
+		r.recording = record
+		logEvent("record_if", "condition", r.cond, "recording", record)
+	}
+	return r.recording
+}
+
+// maintain applies the retention policy in the background, unless the
+// previous run is still in progress.
+func (r *Recorder) maintain(now time.Time) {
+	select {
+	case r.maintenance <- struct{}{}:
+		go func() {
+			maintainDays(r.layout, r.openFiles, now)
+			<-r.maintenance
+		}()
+	default:
+	}
+}
+
+const (
+	heartbeatInterval = 1 * time.Second
+	retryInterval     = 1 * time.Second
+	minBackoff        = 1 * time.Second
+	maxBackoff        = 5 * time.Minute
+)
+
+// recorderFile is the part of *os.File used by the recorder.
+type recorderFile interface {
+	io.Writer
+	Sync() error
+	Close() error
+	Stat() (os.FileInfo, error)
+}
+
+// openRecorderFile opens a file for appending; the tests replace it to
+// inject failures.
+var openRecorderFile = func(path string) (recorderFile, error) {
+	fp, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return fp, nil
 }
 
 type portRecorder struct {
 	RecorderPortSpec
-	fp       *os.File
-	date     string
-	lastSeen []byte
+	layout    *layout
+	openFiles *openFiles
+	sinks     []RecorderSink
+	fp        recorderFile
+	fpPath    string
+	fpSize    int64
+	seq       int
+	first     string
+	file      string
+	lastSeen  []byte
+
+	// for keepalive and stale markers
+	polled      bool
+	stale       bool
+	lastPolled  time.Time
+	lastWritten time.Time
+
+	// lines not yet written to disk
+	pending      []*pendingLine
+	pendingBytes int
+	dropped      int
+	batch        []byte
+	// written but not yet synced
+	unsynced bool
+
+	// consecutive I/O failures; the recorder is degraded while > 0
+	failures int
+	retryAt  time.Time
+}
+
+type pendingLine struct {
+	t    time.Time
+	line []byte
 }
 
 func (r *portRecorder) write(t time.Time, value []byte) {
-	now := time.Now().Format("2006-01-02")
-	if r.fp == nil || now != r.date {
-		r.fp = r.rotate(r.fp, now)
-		r.date = now
-		r.lastSeen = nil
+	r.polled = true
+	r.stale = false
+	r.lastPolled = t
+
+	// each new file starts with the current value, unless it was already
+	// recorded by a previous run
+	if r.layout.path(&r.RecorderPortSpec, t, 0) != r.file {
+		r.recover(t)
 	}
 
 	if bytes.Equal(r.lastSeen, value) {
 		return
 	}
 	r.lastSeen = value
+	r.writeRow(t, hex.EncodeToString(value))
+	for _, s := range r.sinks {
+		s.Write(&r.RecorderPortSpec, t, value)
+	}
+}
+
+// skip notes that the port was polled while not recording, so that it's not
+// marked stale, and that its value must be written again when recording
+// resumes.
+func (r *portRecorder) skip(t time.Time) {
+	r.polled = false
+	r.stale = false
+	r.lastPolled = t
+	r.lastSeen = nil
+}
+
+// heartbeat writes the stale marker when the port is no longer polled, or
+// else repeats the current value when the keepalive interval is due and the
+// port is still being polled.
+func (r *portRecorder) heartbeat(now time.Time) {
+	if r.stale {
+		return
+	}
+	if staleTimeout > 0 && now.Sub(r.lastPolled) > staleTimeout {
+		r.stale = true
+		r.polled = false
+		// write the value again as soon as the port is back
+		r.lastSeen = nil
+		r.writeRow(now, "stale")
+		return
+	}
+	if keepAlive > 0 && r.polled && now.Sub(r.lastPolled) < keepAlive && now.Sub(r.lastWritten) >= keepAlive {
+		r.writeRow(now, hex.EncodeToString(r.lastSeen))
+	}
+}
 
+func (r *portRecorder) writeRow(t time.Time, value string) {
+	r.lastWritten = t
 	ts := t.Format("15:04:05.000")
 	if VerboseFlag {
-		log.Printf("%s %32s %x\n", ts, &r.RecorderPortSpec, value)
+		log.Printf("%s %32s %s\n", ts, &r.RecorderPortSpec, value)
+	}
+	r.enqueue(&pendingLine{t: t, line: []byte(ts + "," + value + "\n")})
+	if fsyncInterval <= 0 {
+		r.flush(t)
+	}
+}
+
+// enqueue appends a line to the pending queue, and drops the oldest lines
+// when the queue exceeds bufferLimit, degraded or not. Otherwise the queue is
+// emptied by each flush, so that mostly happens while degraded.
+func (r *portRecorder) enqueue(l *pendingLine) {
+	r.pending = append(r.pending, l)
+	r.pendingBytes += len(l.line)
+	for r.pendingBytes > bufferLimit && len(r.pending) > 1 {
+		r.pendingBytes -= len(r.pending[0].line)
+		r.pending[0] = nil
+		r.pending = r.pending[1:]
+		r.dropped++
+	}
+}
+
+// flush writes the pending lines to disk and syncs the file. If either
+// fails, the port enters degraded mode and keeps the lines not written in
+// memory until the next retry.
+func (r *portRecorder) flush(now time.Time) {
+	if len(r.pending) == 0 && !r.unsynced {
+		return
+	}
+	if r.failures > 0 && now.Before(r.retryAt) {
+		return
+	}
+	for len(r.pending) > 0 {
+		n, err := r.writeBatch()
+		r.consume(n)
+		if err != nil {
+			r.fail(now, "write_error", err)
+			return
+		}
+	}
+	if err := r.fp.Sync(); err != nil {
+		r.fail(now, "sync_error", err)
+		return
 	}
-	if _, err := r.fp.Write([]byte(fmt.Sprintf("%s,%x\n", ts, value))); err != nil {
-		panic(err)
+	r.unsynced = false
+	if r.failures > 0 {
+		logEvent("recovered",
+			"port", &r.RecorderPortSpec,
+			"failures", r.failures,
+			"dropped", r.dropped,
+		)
+		r.failures = 0
+		r.dropped = 0
 	}
 }
 
-const baseDir = "csv"
+// writeBatch writes the first pending lines that belong to the same file
+// with a single call, and returns the amount of bytes written.
+func (r *portRecorder) writeBatch() (int, error) {
+	t := r.pending[0].t
 
-func (r *portRecorder) rotate(fp *os.File, date string) *os.File {
-	if fp != nil {
-		if err := fp.Close(); err != nil {
-			panic(err)
+	// with size rotation, restart the sequence when any other placeholder
+	// changes, e.g. on a new day
+	if first := r.layout.path(&r.RecorderPortSpec, t, 0); first != r.first {
+		r.first = first
+		r.seq = 0
+	}
+	for {
+		path := r.layout.path(&r.RecorderPortSpec, t, r.seq)
+		if r.fp == nil || r.fpPath != path {
+			if err := r.open(path); err != nil {
+				return 0, err
+			}
 		}
+		if r.layout.rotation != rotateBySize || r.fpSize < rotateSize {
+			break
+		}
+		r.seq++
 	}
 
-	dir := baseDir + "/" + date
-	err := os.MkdirAll(dir, os.ModePerm)
+	r.batch = r.batch[:0]
+	for _, l := range r.pending {
+		if len(r.batch) > 0 {
+			if r.layout.path(&r.RecorderPortSpec, l.t, r.seq) != r.fpPath {
+				break
+			}
+			if r.layout.rotation == rotateBySize && r.fpSize+int64(len(r.batch)+len(l.line)) > rotateSize {
+				break
+			}
+		}
+		r.batch = append(r.batch, l.line...)
+	}
+
+	n, err := r.fp.Write(r.batch)
+	r.fpSize += int64(n)
+	if n > 0 {
+		r.unsynced = true
+	}
 	if err != nil {
-		panic(err)
+		// reopen the file on the next attempt, in case the filesystem
+		// was remounted or the directory removed
+		r.closeFile()
 	}
+	return n, err
+}
 
-	fp, err = os.OpenFile(
-		fmt.Sprintf("%s/%s.csv", dir, &r.RecorderPortSpec),
-		os.O_APPEND|os.O_WRONLY|os.O_CREATE,
-		0666,
+// consume removes n written bytes from the pending lines.
+func (r *portRecorder) consume(n int) {
+	r.pendingBytes -= n
+	for n > 0 {
+		l := r.pending[0]
+		if n < len(l.line) {
+			l.line = l.line[n:]
+			return
+		}
+		n -= len(l.line)
+		r.pending[0] = nil
+		r.pending = r.pending[1:]
+	}
+}
+
+func (r *portRecorder) fail(now time.Time, event string, err error) {
+	r.failures++
+	backoff := maxBackoff
+	if r.failures < 32 && minBackoff<<(r.failures-1) < maxBackoff {
+		backoff = minBackoff << (r.failures - 1)
+	}
+	r.retryAt = now.Add(backoff)
+	logEvent(event,
+		"port", &r.RecorderPortSpec,
+		"err", err,
+		"failures", r.failures,
+		"retry_in", backoff,
+		"pending", len(r.pending),
+		"dropped", r.dropped,
 	)
+}
+
+// recover sets the current file and the last value written to it by a
+// previous run, truncating the partial line left by a crash, if any.
+func (r *portRecorder) recover(t time.Time) {
+	r.file = r.layout.path(&r.RecorderPortSpec, t, 0)
+	r.lastSeen = r.recoverLastValue(t)
+}
+
+func (r *portRecorder) recoverLastValue(t time.Time) []byte {
+	path := r.layout.path(&r.RecorderPortSpec, t, 0)
+	if r.layout.rotation == rotateBySize {
+		for seq := 1; ; seq++ {
+			next := r.layout.path(&r.RecorderPortSpec, t, seq)
+			if _, err := os.Stat(next); err != nil {
+				break
+			}
+			path = next
+		}
+	}
+	value, err := recoverFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		logEvent("recover_error", "file", path, "err", err)
+	}
+	return value
+}
+
+// longest line expected in a recorder file
+const maxLineSize = 4096
+
+func recoverFile(path string) ([]byte, error) {
+	fp, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	info, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	nl, err := lastNewline(fp, size)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return fp
+	end := nl + 1
+	if end < size {
+		if err := fp.Truncate(end); err != nil {
+			return nil, err
+		}
+		logEvent("truncated", "file", path, "bytes", size-end)
+	}
+	if end == 0 {
+		return nil, nil
+	}
+
+	nl, err = lastNewline(fp, end-1)
+	if err != nil {
+		return nil, err
+	}
+	start := nl + 1
+	if end-1-start > maxLineSize {
+		return nil, nil
+	}
+	last := make([]byte, end-1-start)
+	if _, err := fp.ReadAt(last, start); err != nil {
+		return nil, err
+	}
+	i := bytes.IndexByte(last, ',')
+	if i < 0 || string(last[i+1:]) == "stale" {
+		return nil, nil
+	}
+	return hex.DecodeString(string(last[i+1:]))
+}
+
+// lastNewline returns the offset of the last newline before end, or -1 if
+// there is none.
+func lastNewline(fp *os.File, end int64) (int64, error) {
+	buf := make([]byte, maxLineSize)
+	for end > 0 {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		b := buf[:end-start]
+		if _, err := fp.ReadAt(b, start); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+			return start + int64(i), nil
+		}
+		end = start
+	}
+	return -1, nil
+}
+
+func (r *portRecorder) open(path string) error {
+	r.closeFile()
+
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	fp, err := r.openFiles.open(path)
+	if err != nil {
+		return err
+	}
+	info, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		r.openFiles.close(path)
+		return err
+	}
+	r.fp = fp
+	r.fpPath = path
+	r.fpSize = info.Size()
+	return nil
+}
+
+func (r *portRecorder) closeFile() {
+	if r.fp == nil {
+		return
+	}
+	err := r.fp.Sync()
+	if cerr := r.fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		logEvent("close_error", "port", &r.RecorderPortSpec, "err", err)
+	}
+	r.openFiles.close(r.fpPath)
+	r.unsynced = false
+	r.fp = nil
+	r.fpPath = ""
 }
 
 func (r *portRecorder) close() {
-	if err := r.fp.Close(); err != nil {
-		panic(err)
+	r.failures = 0
+	r.flush(time.Now())
+	if len(r.pending) > 0 {
+		logEvent("lost",
+			"port", &r.RecorderPortSpec,
+			"pending", len(r.pending),
+			"dropped", r.dropped,
+		)
+	}
+	r.closeFile()
+}
+
+// logEvent logs a recorder event as a line of key=value pairs.
+func logEvent(event string, kv ...interface{}) {
+	var sb strings.Builder
+	sb.WriteString("event=")
+	sb.WriteString(event)
+	for i := 0; i+1 < len(kv); i += 2 {
+		v := fmt.Sprint(kv[i+1])
+		if strings.ContainsAny(v, " \"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&sb, " %s=%s", kv[i], v)
 	}
+	log.Println(sb.String())
 }
 
 func slice(v []byte, i, j int) []byte {
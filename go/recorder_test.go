@@ -0,0 +1,206 @@
+package mvb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// faultyFile fails the writes with *writeErr and the syncs with *syncErr,
+// if set.
+type faultyFile struct {
+	recorderFile
+	writeErr, syncErr *error
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	if *f.writeErr != nil {
+		return 0, *f.writeErr
+	}
+	return f.recorderFile.Write(p)
+}
+
+func (f *faultyFile) Sync() error {
+	if *f.syncErr != nil {
+		return *f.syncErr
+	}
+	return f.recorderFile.Sync()
+}
+
+// captureLog returns a function that checks the log messages written since
+// its last call.
+func captureLog(t *testing.T) func(want ...string) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	flags := log.Flags()
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+	return func(want ...string) {
+		t.Helper()
+		got := strings.TrimSuffix(buf.String(), "\n")
+		buf.Reset()
+		if got != strings.Join(want, "\n") {
+			t.Errorf("got log %q, want %q", got, want)
+		}
+	}
+}
+
+func TestRecorderRetry(t *testing.T) {
+	checkLog := captureLog(t)
+	var openErr, writeErr, syncErr error
+	open := openRecorderFile
+	defer func() { openRecorderFile = open }()
+	openRecorderFile = func(path string) (recorderFile, error) {
+		if openErr != nil {
+			return nil, openErr
+		}
+		fp, err := open(path)
+		if err != nil {
+			return nil, err
+		}
+		return &faultyFile{fp, &writeErr, &syncErr}, nil
+	}
+
+	l, err := newLayout(t.TempDir(), "{spec}.csv", rotateDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &portRecorder{RecorderPortSpec: RecorderPortSpec{0x014, 0, 2, "speed"}, layout: l, openFiles: newOpenFiles()}
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	openErr = errors.New("read-only file system")
+	p.write(at(0), []byte{0, 1})
+	p.flush(at(0))
+	checkLog(`event=write_error port=014-0-2-speed err="read-only file system" failures=1 retry_in=1s pending=1 dropped=0`)
+
+	// no retries before the backoff, which doubles with each failure
+	openErr = nil
+	writeErr = errors.New("no space left on device")
+	p.write(at(500*time.Millisecond), []byte{0, 2})
+	p.flush(at(500 * time.Millisecond))
+	checkLog()
+	p.flush(at(time.Second))
+	checkLog(`event=write_error port=014-0-2-speed err="no space left on device" failures=2 retry_in=2s pending=2 dropped=0`)
+	p.flush(at(2 * time.Second))
+	checkLog()
+
+	writeErr = nil
+	p.write(at(2500*time.Millisecond), []byte{0, 3})
+	p.flush(at(3 * time.Second))
+	checkLog("event=recovered port=014-0-2-speed failures=2 dropped=0")
+
+	// the sync is retried too
+	syncErr = errors.New("input/output error")
+	p.write(at(4*time.Second), []byte{0, 4})
+	p.flush(at(4 * time.Second))
+	checkLog(`event=sync_error port=014-0-2-speed err="input/output error" failures=1 retry_in=1s pending=0 dropped=0`)
+	p.flush(at(4500 * time.Millisecond))
+	checkLog()
+	syncErr = nil
+	p.flush(at(5 * time.Second))
+	checkLog("event=recovered port=014-0-2-speed failures=1 dropped=0")
+	p.close()
+
+	data, err := os.ReadFile(l.path(&p.RecorderPortSpec, start, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "12:00:00.000,0001\n12:00:00.500,0002\n12:00:02.500,0003\n12:00:04.000,0004\n"; string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+}
+
+func TestRecorderRecover(t *testing.T) {
+	checkLog := captureLog(t)
+	defer func(dir string) { outDir = dir }(outDir)
+	outDir = t.TempDir()
+	ports := []RecorderPortSpec{{0x014, 0, 2, "speed"}, {0x020, -1, -1, "door"}, {0x030, -1, -1, "brake"}}
+	r, err := NewRecorder(ports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	complete := "10:00:00.000,0001\n10:00:01.000,0002\n"
+	files := []string{
+		// a partial line
+		complete + "10:00:02.000,00",
+		// a partial line longer than maxLineSize
+		complete + strings.Repeat("0", 2*maxLineSize),
+		complete + "10:00:02.000,stale\n",
+	}
+	for i, content := range files {
+		path := r.layout.path(&ports[i], now, 0)
+		writeFiles(t, filepath.Dir(path), now, filepath.Base(path))
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// recovered on start, before any value is received
+	events := make(chan Event)
+	close(events)
+	r.Loop(events)
+	checkLog(
+		"event=truncated file="+r.layout.path(&ports[0], now, 0)+" bytes=15",
+		"event=truncated file="+r.layout.path(&ports[1], now, 0)+" bytes=8192",
+	)
+	for i, want := range []string{"0002", "0002", ""} {
+		p := r.ports[i]
+		if got := hex.EncodeToString(p.lastSeen); got != want {
+			t.Errorf("%s: got last value %q, want %q", p, got, want)
+		}
+		data, err := os.ReadFile(p.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 && string(data) != complete {
+			t.Errorf("%s: got %q", p, data)
+		}
+	}
+}
+
+func TestRecorderHeartbeat(t *testing.T) {
+	defer func(k, s time.Duration) { keepAlive, staleTimeout = k, s }(keepAlive, staleTimeout)
+	keepAlive, staleTimeout = 10*time.Second, 30*time.Second
+
+	l, err := newLayout(t.TempDir(), "{spec}.csv", rotateDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &portRecorder{RecorderPortSpec: RecorderPortSpec{0x014, 0, 2, "speed"}, layout: l, openFiles: newOpenFiles()}
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	p.write(at(0), []byte{0, 1})
+	p.write(at(8*time.Second), []byte{0, 1})
+	// a keepalive row once per interval, while the port is polled
+	for _, d := range []time.Duration{9, 10, 15, 20} {
+		p.heartbeat(at(d * time.Second))
+	}
+	// a single stale marker
+	for _, d := range []time.Duration{39, 45} {
+		p.heartbeat(at(d * time.Second))
+	}
+	// the unchanged value is written again when the port is back
+	p.write(at(50*time.Second), []byte{0, 1})
+	p.write(at(51*time.Second), []byte{0, 1})
+	p.close()
+
+	data, err := os.ReadFile(l.path(&p.RecorderPortSpec, start, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "12:00:00.000,0001\n12:00:10.000,0001\n12:00:39.000,stale\n12:00:50.000,0001\n"; string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,482 @@
+package mvb
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// directory of CSV files written by the recorder, e.g. csv/2024-01-31; if
+// set, the dashboard replays them instead of decoding stdin
+var ReplayFlag string
+
+var (
+	replayStart = ""
+	replaySpeed = 1.0
+)
+
+func initReplayFlags() {
+	flag.StringVar(&ReplayFlag, "replay", "", "replay the CSV files of a day written by the recorder with -layout and -rotate, e.g. csv/2024-01-31, instead of decoding stdin")
+	flag.StringVar(&replayStart, "replay-start", replayStart, "start the replay at this time of day, e.g. 13:45:00")
+	flag.Float64Var(&replaySpeed, "replay-speed", replaySpeed, "initial replay speed")
+}
+
+const (
+	minReplaySpeed = 1.0 / 64
+	maxReplaySpeed = 1024
+)
+
+// Replay feeds the values recorded in the CSV files of a day to Stats, on a
+// virtual clock that can be paused, sped up and moved.
+type Replay struct {
+	// one for each file, as reconstructed from its name
+	Specs []RecorderPortSpec
+
+	changes    []replayChange
+	start, end time.Duration
+
+	// virtual clock: time of day, and next change to apply
+	t      time.Duration
+	next   int
+	speed  float64
+	paused bool
+	last   time.Time
+
+	// data of each port, as far as the recorded ranges go
+	data map[uint16][]byte
+}
+
+type replayChange struct {
+	t     time.Duration
+	spec  int
+	value []byte
+}
+
+// replayFile is a file written by the recorder, with the placeholders that
+// order the files of its spec.
+type replayFile struct {
+	path       string
+	spec       RecorderPortSpec
+	date, hour string
+	seq        int
+	compressed bool
+}
+
+// replayMatcher recognizes the files of the layout given with -layout and
+// -rotate, in any directory.
+func replayMatcher() (*layoutMatcher, error) {
+	l, err := newLayout(outDir, layoutFlag, rotationFlag)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(l.template, "{spec}") && !strings.Contains(l.template, "{port}") {
+		return nil, fmt.Errorf("layout %q: the port of each file is unknown", l.template)
+	}
+	return l.suffixMatcher(), nil
+}
+
+// ParseRecorderFileName returns the spec of a file written by the recorder,
+// from the end of its path and the layout given with -layout and -rotate,
+// e.g. 2024-01-31/014-0-2-speed.csv with the default layout. The spaces of
+// the description were replaced with dashes by the recorder, and stay so,
+// unless the spec is one of ports.
+func ParseRecorderFileName(path string, ports []RecorderPortSpec) (RecorderPortSpec, error) {
+	m, err := replayMatcher()
+	if err != nil {
+		return RecorderPortSpec{}, err
+	}
+	f, err := parseRecorderFile(m, path, ports)
+	return f.spec, err
+}
+
+func parseRecorderFile(m *layoutMatcher, path string, ports []RecorderPortSpec) (replayFile, error) {
+	values, ext, ok := m.match(path)
+	if !ok {
+		return replayFile{}, fmt.Errorf("%s: not a recorder file", path)
+	}
+	f := replayFile{path: path, date: values["{date}"], hour: values["{hour}"], compressed: ext != ""}
+	if seq, ok := values["{seq}"]; ok {
+		var err error
+		if f.seq, err = strconv.Atoi(seq); err != nil {
+			return replayFile{}, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	var err error
+	if name, ok := values["{spec}"]; ok {
+		f.spec, err = parseSpecName(name, ports)
+	} else {
+		f.spec, err = parseSpecValues(values, ports)
+	}
+	if err != nil {
+		return replayFile{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return f, nil
+}
+
+var specNameRegexp = regexp.MustCompile(`^([0-9a-f]{3})-(?:(\d+)-(\d+)-)?(.*)$`)
+
+// parseSpecName parses the {spec} of a file name. A name such as 014-0-2-x
+// is either the range 0-2 described as x or the whole port described as
+// 0-2-x: it is the one in ports, if any, and else the range.
+func parseSpecName(name string, ports []RecorderPortSpec) (RecorderPortSpec, error) {
+	for _, p := range ports {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	m := specNameRegexp.FindStringSubmatch(name)
+	if m == nil {
+		return RecorderPortSpec{}, fmt.Errorf("invalid spec %q", name)
+	}
+	port, _ := strconv.ParseUint(m[1], 16, 12)
+	spec := RecorderPortSpec{Port: uint16(port), I: -1, J: -1, Desc: m[4]}
+	if m[2] != "" {
+		var err1, err2 error
+		spec.I, err1 = strconv.Atoi(m[2])
+		spec.J, err2 = strconv.Atoi(m[3])
+		if err1 != nil || err2 != nil {
+			return RecorderPortSpec{}, fmt.Errorf("invalid spec %q", name)
+		}
+	}
+	return spec, nil
+}
+
+// parseSpecValues returns the spec given by the {port}, {i}, {j} and {desc}
+// of a file name, or the one in ports that has them.
+func parseSpecValues(values map[string]string, ports []RecorderPortSpec) (RecorderPortSpec, error) {
+	port, _ := strconv.ParseUint(values["{port}"], 16, 12)
+	spec := RecorderPortSpec{Port: uint16(port), I: -1, J: -1, Desc: values["{desc}"]}
+	if values["{i}"] != "" && values["{j}"] != "" {
+		var err1, err2 error
+		spec.I, err1 = strconv.Atoi(values["{i}"])
+		spec.J, err2 = strconv.Atoi(values["{j}"])
+		if err1 != nil || err2 != nil {
+			return RecorderPortSpec{}, fmt.Errorf("invalid range %s-%s", values["{i}"], values["{j}"])
+		}
+	}
+	_, hasDesc := values["{desc}"]
+	for _, p := range ports {
+		if p.Port == spec.Port && p.I == spec.I && p.J == spec.J && (!hasDesc || slug(p.Desc) == spec.Desc) {
+			return p, nil
+		}
+	}
+	return spec, nil
+}
+
+// NewReplay reads the CSV files under dir, compressed or not, and starts
+// paused at the time given with -replay-start, or else at the first change.
+// The files are recognized with the layout given with -layout and -rotate,
+// and their specs resolved with ports.
+func NewReplay(dir string, ports []RecorderPortSpec) (*Replay, error) {
+	m, err := replayMatcher()
+	if err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(layoutFlag)
+	files := make(map[RecorderPortSpec][]replayFile)
+	var specs []RecorderPortSpec
+	err = filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil || e.IsDir() {
+			return err
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		f, err := parseRecorderFile(m, abs, ports)
+		if err != nil {
+			// other files are ignored silently
+			name := path
+			for _, c := range compressors {
+				name = strings.TrimSuffix(name, c.ext)
+			}
+			if filepath.Ext(name) == ext {
+				logEvent("replay_skip", "file", path, "err", err)
+			}
+			return nil
+		}
+		f.path = path
+		if files[f.spec] == nil {
+			specs = append(specs, f.spec)
+		}
+		files[f.spec] = append(files[f.spec], f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		a, b := &specs[i], &specs[j]
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		if a.I != b.I || a.J != b.J {
+			return a.I < b.I || a.I == b.I && a.J < b.J
+		}
+		return a.Desc < b.Desc
+	})
+
+	r := &Replay{
+		speed:  replaySpeed,
+		paused: true,
+		data:   make(map[uint16][]byte),
+	}
+	for _, spec := range specs {
+		rows, err := readHistoryFiles(sortReplayFiles(files[spec]))
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			// a stale port keeps its last value
+			if row.Value != nil {
+				r.changes = append(r.changes, replayChange{row.T, len(r.Specs), row.Value})
+			}
+		}
+		r.Specs = append(r.Specs, spec)
+	}
+	if len(r.changes) == 0 {
+		return nil, fmt.Errorf("%s: no recorded values", dir)
+	}
+	sort.SliceStable(r.changes, func(i, j int) bool {
+		return r.changes[i].t < r.changes[j].t
+	})
+	r.start = r.changes[0].t
+	r.end = r.changes[len(r.changes)-1].t
+	r.t = r.start
+	if r.speed < minReplaySpeed || r.speed > maxReplaySpeed {
+		return nil, fmt.Errorf("invalid replay speed %g", r.speed)
+	}
+	if replayStart != "" {
+		t, err := r.parseTime(replayStart)
+		if err != nil {
+			return nil, err
+		}
+		r.t = t
+	}
+	return r, nil
+}
+
+// sortReplayFiles returns the paths of the files of a spec in the order they
+// were written: by date, hour and sequence number, and the compressed file
+// of a name, if any, before the plain one, as written by compressDay.
+func sortReplayFiles(files []replayFile) []string {
+	sort.Slice(files, func(i, j int) bool {
+		a, b := &files[i], &files[j]
+		switch {
+		case a.date != b.date:
+			return a.date < b.date
+		case a.hour != b.hour:
+			return a.hour < b.hour
+		case a.seq != b.seq:
+			return a.seq < b.seq
+		}
+		return a.compressed && !b.compressed
+	})
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths
+}
+
+// readHistoryFiles reads the files of a spec as a single history, so that
+// midnight is noticed across them.
+func readHistoryFiles(paths []string) ([]HistoryRow, error) {
+	var readers []io.Reader
+	for _, path := range paths {
+		fp, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer fp.Close()
+		var in io.Reader = fp
+		for _, c := range compressors {
+			if strings.HasSuffix(path, c.ext) {
+				rc, err := c.newReader(fp)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", path, err)
+				}
+				defer rc.Close()
+				in = rc
+			}
+		}
+		readers = append(readers, in)
+	}
+	rows, err := ReadHistory(io.MultiReader(readers...))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", paths[0], err)
+	}
+	return rows, nil
+}
+
+// parseTime returns the time of a time of day within the replay.
+func (r *Replay) parseTime(s string) (time.Duration, error) {
+	var tod time.Time
+	var err error
+	for _, layout := range []string{"15:04:05.000", "15:04:05", "15:04"} {
+		if tod, err = time.Parse(layout, s); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	t := time.Duration(tod.Hour())*time.Hour +
+		time.Duration(tod.Minute())*time.Minute +
+		time.Duration(tod.Second())*time.Second +
+		time.Duration(tod.Nanosecond())
+	// the replay may go past midnight
+	if t < r.start && t+24*time.Hour <= r.end {
+		t += 24 * time.Hour
+	}
+	return t, nil
+}
+
+// Start sets the values at the current time, as if the replay was sought
+// there.
+func (r *Replay) Start(s *Stats) {
+	r.Seek(r.t, s)
+}
+
+// N returns the virtual clock as a sample number since the first change.
+func (r *Replay) N() uint64 {
+	return uint64((r.t - r.start).Seconds() * SampleRate)
+}
+
+// Advance moves the virtual clock by the time elapsed since the last call,
+// times the speed, and applies the changes up to then. The replay pauses at
+// the last change.
+func (r *Replay) Advance(now time.Time, s *Stats) {
+	last := r.last
+	r.last = now
+	if r.paused || last.IsZero() {
+		return
+	}
+	r.t += time.Duration(float64(now.Sub(last)) * r.speed)
+	if r.t >= r.end {
+		r.t = r.end
+		r.paused = true
+	}
+	for r.next < len(r.changes) && r.changes[r.next].t <= r.t {
+		c := &r.changes[r.next]
+		port := r.apply(c)
+		s.SetVar(r.N(), port, append([]byte(nil), r.data[port]...))
+		r.next++
+	}
+}
+
+// apply writes a change into the data of its port.
+func (r *Replay) apply(c *replayChange) uint16 {
+	spec := &r.Specs[c.spec]
+	data := r.data[spec.Port]
+	if spec.I == -1 {
+		if len(data) > len(c.value) {
+			data = append(append([]byte(nil), c.value...), data[len(c.value):]...)
+		} else {
+			data = append([]byte(nil), c.value...)
+		}
+	} else {
+		if len(data) < spec.I+len(c.value) {
+			data = append(data, make([]byte, spec.I+len(c.value)-len(data))...)
+		}
+		copy(data[spec.I:], c.value)
+	}
+	r.data[spec.Port] = data
+	return spec.Port
+}
+
+// Seek moves the virtual clock to t, within the recorded changes, and sets
+// the values at that time.
+func (r *Replay) Seek(t time.Duration, s *Stats) {
+	if t < r.start {
+		t = r.start
+	}
+	if t > r.end {
+		t = r.end
+	}
+	r.t = t
+	r.next = 0
+	for port := range r.data {
+		delete(r.data, port)
+	}
+	for r.next < len(r.changes) && r.changes[r.next].t <= t {
+		r.apply(&r.changes[r.next])
+		r.next++
+	}
+	for _, spec := range r.Specs {
+		if data, ok := r.data[spec.Port]; ok {
+			s.SetVar(r.N(), spec.Port, append([]byte(nil), data...))
+		} else {
+			delete(s.Vars, spec.Port)
+		}
+	}
+}
+
+// Skip moves the virtual clock by d.
+func (r *Replay) Skip(d time.Duration, s *Stats) {
+	r.Seek(r.t+d, s)
+}
+
+// SeekTo moves the virtual clock to a time of day, e.g. 13:45:00.
+func (r *Replay) SeekTo(tod string, s *Stats) error {
+	t, err := r.parseTime(tod)
+	if err != nil {
+		return err
+	}
+	r.Seek(t, s)
+	return nil
+}
+
+// TogglePause pauses or resumes the replay; at the end, it starts over.
+func (r *Replay) TogglePause(s *Stats) {
+	r.paused = !r.paused
+	if !r.paused && r.t >= r.end {
+		r.Seek(r.start, s)
+	}
+}
+
+// SetSpeed sets the speed, limited to a sensible range.
+func (r *Replay) SetSpeed(speed float64) {
+	switch {
+	case speed < minReplaySpeed:
+		speed = minReplaySpeed
+	case speed > maxReplaySpeed:
+		speed = maxReplaySpeed
+	}
+	r.speed = speed
+}
+
+func (r *Replay) Speed() float64 {
+	return r.speed
+}
+
+func (r *Replay) Paused() bool {
+	return r.paused
+}
+
+// Time returns the virtual clock as a time of day.
+func (r *Replay) Time() string {
+	return formatTimeOfDay(r.t)
+}
+
+func (r *Replay) Status() string {
+	state := "playing"
+	if r.paused {
+		state = "paused"
+	}
+	return fmt.Sprintf("%s x%g %s [%s - %s]", r.Time(), r.speed, state,
+		formatTimeOfDay(r.start), formatTimeOfDay(r.end))
+}
+
+func formatTimeOfDay(t time.Duration) string {
+	t %= 24 * time.Hour
+	return time.Time{}.Add(t).Format("15:04:05.000")
+}
@@ -0,0 +1,170 @@
+package mvb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseRecorderFileName(t *testing.T) {
+	defer func(l string, r rotation) { layoutFlag, rotationFlag = l, r }(layoutFlag, rotationFlag)
+	known := []RecorderPortSpec{{0x1ab, -1, -1, "brake pressure"}, {0x030, -1, -1, "4-8 door"}}
+	for _, test := range []struct {
+		layout string
+		rotate rotation
+		path   string
+		want   RecorderPortSpec
+	}{
+		{"{date}/{spec}.csv", rotateDaily, "csv/2024-01-31/014-0-2-speed.csv", RecorderPortSpec{0x014, 0, 2, "speed"}},
+		{"{date}/{spec}.csv", rotateDaily, "2024-01-31/020-4-8-.csv", RecorderPortSpec{0x020, 4, 8, ""}},
+		{"{date}/{spec}.csv", rotateDaily, "2024-01-31/1ac-brake-pressure.csv", RecorderPortSpec{0x1ac, -1, -1, "brake-pressure"}},
+		// the known ports keep their descriptions, and resolve whether
+		// 4-8 is a range
+		{"{date}/{spec}.csv", rotateDaily, "2024-01-31/1ab-brake-pressure.csv", known[0]},
+		{"{date}/{spec}.csv", rotateDaily, "2024-01-31/030-4-8-door.csv", known[1]},
+		{"{date}/{spec}.csv", rotateDaily, "2024-01-31/020-4-8-door.csv", RecorderPortSpec{0x020, 4, 8, "door"}},
+		// the rotation suffix is not part of the description
+		{"{date}/{spec}.csv", rotateHourly, "2024-01-31/014-0-2-speed-13.csv.gz", RecorderPortSpec{0x014, 0, 2, "speed"}},
+		{"{date}/{spec}.csv", rotateBySize, "2024-01-31/014-speed-2.csv", RecorderPortSpec{0x014, -1, -1, "speed"}},
+		{"{vehicle}/{port}/{date}-{i}-{j}-{desc}.csv", rotateDaily, "/data/tren-1/014/2024-01-31-0-2-motor-current.csv", RecorderPortSpec{0x014, 0, 2, "motor-current"}},
+		{"{vehicle}/{port}/{date}-{i}-{j}-{desc}.csv", rotateDaily, "tren-1/1ab/2024-01-31---brake-pressure.csv", known[0]},
+	} {
+		layoutFlag, rotationFlag = test.layout, test.rotate
+		got, err := ParseRecorderFileName(test.path, known)
+		if err != nil || got != test.want {
+			t.Errorf("%s with %s: got %v, %v, want %v", test.path, test.layout, got, err, test.want)
+		}
+	}
+
+	layoutFlag, rotationFlag = "{date}/{spec}.csv", rotateDaily
+	for _, path := range []string{"2024-01-31/014.txt", "2024-01-31/14-speed.csv", "014-0-2-speed.csv", "record.log"} {
+		if _, err := ParseRecorderFileName(path, nil); err == nil {
+			t.Errorf("%s: no error", path)
+		}
+	}
+	layoutFlag = "{date}/{desc}.csv"
+	if _, err := ParseRecorderFileName("2024-01-31/speed.csv", nil); err == nil {
+		t.Errorf("no error without the port in the layout")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "2024-01-31")
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("23:59:58.000,0001\n23:59:59.000,0002\n"))
+	w.Close()
+	files := map[string]string{
+		// two ranges of the same port, the first one partly compressed by
+		// the retention, and the whole of another one
+		"014-0-2-speed.csv.gz": gz.String(),
+		"014-0-2-speed.csv":    "00:00:01.000,0003\n",
+		"014-4-6-brake.csv":    "23:59:58.500,aaaa\n00:00:00.500,stale\n00:00:02.000,bbbb\n",
+		"020-door.csv":         "23:59:59.500,01\n",
+		"notes.txt":            "ignored",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := NewReplay(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Specs) != 3 {
+		t.Fatalf("got specs %v", r.Specs)
+	}
+
+	s := NewStats()
+	vars := func() string {
+		return fmt.Sprintf("%x %x", s.Vars[0x014], s.Vars[0x020])
+	}
+	r.Start(&s)
+	if got := vars(); got != "0001 " {
+		t.Errorf("at start: got %q", got)
+	}
+
+	// the clock starts on the first call
+	now := time.Now()
+	r.TogglePause(&s)
+	r.Advance(now, &s)
+	r.Advance(now.Add(1500*time.Millisecond), &s)
+	if got := vars(); got != "00020000aaaa 01" || r.Time() != "23:59:59.500" {
+		t.Errorf("at %s: got %q", r.Time(), got)
+	}
+
+	r.SetSpeed(2)
+	r.Advance(now.Add(2500*time.Millisecond), &s)
+	if got := vars(); got != "00030000aaaa 01" || r.Time() != "00:00:01.500" {
+		t.Errorf("at %s: got %q", r.Time(), got)
+	}
+
+	// pauses at the end
+	r.Advance(now.Add(time.Hour), &s)
+	if got := vars(); got != "00030000bbbb 01" || !r.Paused() || r.Time() != "00:00:02.000" {
+		t.Errorf("at %s: got %q", r.Time(), got)
+	}
+
+	// seeking back forgets the values set later
+	if err := r.SeekTo("23:59:58.600", &s); err != nil {
+		t.Fatal(err)
+	}
+	if got := vars(); got != "00010000aaaa " {
+		t.Errorf("at %s: got %q", r.Time(), got)
+	}
+	r.Skip(time.Hour, &s)
+	if r.Time() != "00:00:02.000" {
+		t.Errorf("skipped to %s", r.Time())
+	}
+	if err := r.SeekTo("00:00:01", &s); err != nil || r.Time() != "00:00:01.000" {
+		t.Errorf("sought to %s, %v", r.Time(), err)
+	}
+}
+
+func TestReplayHourly(t *testing.T) {
+	defer func(r rotation) { rotationFlag = r }(rotationFlag)
+	rotationFlag = rotateHourly
+	dir := t.TempDir()
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("23:00:00.000,01\n"))
+	w.Close()
+	files := map[string]string{
+		"2024-01-31/014-speed-22.csv":    "22:59:59.000,00\n",
+		"2024-01-31/014-speed-23.csv.gz": gz.String(),
+		"2024-01-31/014-speed-23.csv":    "23:59:59.000,02\n",
+		"2024-02-01/014-speed-00.csv":    "00:00:01.000,03\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := NewReplay(dir, []RecorderPortSpec{{0x014, -1, -1, "speed"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Specs) != 1 {
+		t.Fatalf("got specs %v", r.Specs)
+	}
+	var got []string
+	for _, c := range r.changes {
+		got = append(got, fmt.Sprintf("%s %x", formatTimeOfDay(c.t), c.value))
+	}
+	want := "[22:59:59.000 00 23:00:00.000 01 23:59:59.000 02 00:00:01.000 03]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,342 @@
+package mvb
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const maintenanceInterval = 1 * time.Minute
+
+var (
+	keepDays    = 0
+	maxSize     = int64(0)
+	compression = "none"
+)
+
+func initRetentionFlags() {
+	flag.IntVar(&keepDays, "keep-days", keepDays, "remove recorded days older than this (0: keep forever)")
+	flag.Func("max-size", "maximum total size of recorded data, e.g. 512M or 4G (0: unlimited)", func(s string) (err error) {
+		maxSize, err = parseSize(s)
+		return
+	})
+	flag.Func("compress", "compress past days: none, gzip or zstd", func(s string) error {
+		if _, ok := compressors[s]; !ok && s != "none" {
+			return fmt.Errorf("unknown compression: %s", s)
+		}
+		compression = s
+		return nil
+	})
+}
+
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * mult, nil
+}
+
+type compressor struct {
+	ext       string
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var compressors = map[string]compressor{
+	"gzip": {".gz", func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	}, func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	}},
+	"zstd": {".zst", func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w)
+	}, func(r io.Reader) (io.ReadCloser, error) {
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}},
+}
+
+type day struct {
+	date  string
+	files []dayFile
+	size  int64
+}
+
+type dayFile struct {
+	path       string
+	compressed bool
+	size       int64
+}
+
+// openFiles are the files being written by the recorder, which the
+// retention leaves alone.
+type openFiles struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+func newOpenFiles() *openFiles {
+	return &openFiles{paths: make(map[string]bool)}
+}
+
+// open opens a file for the recorder, unless the retention is removing it.
+func (o *openFiles) open(path string) (recorderFile, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	fp, err := openRecorderFile(path)
+	if err == nil {
+		o.paths[path] = true
+	}
+	return fp, err
+}
+
+func (o *openFiles) close(path string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.paths, path)
+}
+
+func (o *openFiles) has(path string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.paths[path]
+}
+
+// unlessOpen calls f if the recorder does not have path open, and reports
+// whether it did. The recorder can't open path while f runs.
+func (o *openFiles) unlessOpen(path string, f func() error) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.paths[path] {
+		return false, nil
+	}
+	return true, f()
+}
+
+// maintainDays compresses the files of past days and removes the files of
+// the oldest days until the retention limits are satisfied. Only the files
+// of the layout are considered, and never those open. The day of each file
+// is taken from its {date}, or else from its modification time. The current
+// day is never touched.
+func maintainDays(l *layout, open *openFiles, now time.Time) {
+	today := now.Format("2006-01-02")
+	m := l.matcher(false)
+
+	if c, ok := compressors[compression]; ok {
+		days, err := listDays(l.dir, m)
+		if err != nil {
+			logEvent("retention_error", "err", err)
+			return
+		}
+		for _, d := range days {
+			if d.date < today {
+				compressDay(d, c, open)
+			}
+		}
+	}
+
+	days, err := listDays(l.dir, m)
+	if err != nil {
+		logEvent("retention_error", "err", err)
+		return
+	}
+
+	var removed []string
+	if keepDays > 0 {
+		oldest := now.AddDate(0, 0, -keepDays+1).Format("2006-01-02")
+		for len(days) > 0 && days[0].date < oldest && days[0].date < today {
+			paths, _ := removeDay(days[0], "expired", open)
+			removed = append(removed, paths...)
+			days = days[1:]
+		}
+	}
+
+	if maxSize > 0 {
+		total := int64(0)
+		for _, d := range days {
+			total += d.size
+		}
+		for len(days) > 0 && total > maxSize && days[0].date < today {
+			// the open files stay, and still count
+			paths, size := removeDay(days[0], "quota", open)
+			removed = append(removed, paths...)
+			total -= size
+			days = days[1:]
+		}
+		if total > maxSize {
+			logEvent("quota_exceeded", "size", total, "max_size", maxSize)
+		}
+	}
+
+	removeEmptyDirs(l.dir, removed)
+}
+
+// listDays groups the files of dir matched by m by day, oldest first.
+func listDays(dir string, m *layoutMatcher) ([]*day, error) {
+	byDate := make(map[string]*day)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		values, ext, ok := m.match(rel)
+		if !ok {
+			return nil
+		}
+		date := values["{date}"]
+		if date == "" {
+			date = info.ModTime().Format("2006-01-02")
+		}
+		d, ok := byDate[date]
+		if !ok {
+			d = &day{date: date}
+			byDate[date] = d
+		}
+		d.files = append(d.files, dayFile{path, ext != "", info.Size()})
+		d.size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var days []*day
+	for _, d := range byDate {
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].date < days[j].date })
+	return days, nil
+}
+
+// removeDay removes the files of a day that are not open, and returns them
+// and their total size.
+func removeDay(d *day, reason string, open *openFiles) (removed []string, size int64) {
+	for _, f := range d.files {
+		ok, err := open.unlessOpen(f.path, func() error {
+			return os.Remove(f.path)
+		})
+		switch {
+		case err != nil:
+			logEvent("retention_error", "file", f.path, "err", err)
+		case ok:
+			removed = append(removed, f.path)
+			size += f.size
+		}
+	}
+	logEvent("removed", "day", d.date, "files", len(removed), "reason", reason)
+	return removed, size
+}
+
+// removeEmptyDirs removes the directories of the removed files, and their
+// parents, as long as they are empty, up to dir excluded.
+func removeEmptyDirs(dir string, removed []string) {
+	for _, path := range removed {
+		for d := filepath.Dir(path); d != dir && strings.HasPrefix(d, dir); d = filepath.Dir(d) {
+			if os.Remove(d) != nil {
+				break
+			}
+		}
+	}
+}
+
+func compressDay(d *day, c compressor, open *openFiles) {
+	for _, f := range d.files {
+		if f.compressed || open.has(f.path) {
+			continue
+		}
+		if err := compressFile(f.path, f.path+c.ext, c, open); err != nil {
+			logEvent("compress_error", "file", f.path, "err", err)
+		}
+	}
+}
+
+// compressFile appends the compressed contents of src to dst and removes
+// src. Both gzip and zstd allow concatenated streams, so a day can be
+// compressed more than once if a late write recreated one of its files. On
+// failure, or if the recorder opened src in the meantime, dst is truncated
+// back to its previous size.
+func compressFile(src string, dst string, c compressor, open *openFiles) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	srcInfo, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	info, err := out.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = copyCompressed(out, in, c)
+	if err == nil {
+		err = out.Sync()
+	}
+	removed := false
+	if err == nil {
+		removed, err = open.unlessOpen(src, func() error {
+			return os.Remove(src)
+		})
+	}
+	switch {
+	case (err != nil || !removed) && info.Size() == 0:
+		os.Remove(dst)
+	case err != nil || !removed:
+		out.Truncate(info.Size())
+	default:
+		// the day of a layout without {date} is that of the last change
+		os.Chtimes(dst, srcInfo.ModTime(), srcInfo.ModTime())
+	}
+	return err
+}
+
+func copyCompressed(out io.Writer, in io.Reader, c compressor) error {
+	w, err := c.newWriter(out)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,138 @@
+package mvb
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFiles creates the files, relative to dir, with their own name as
+// contents and modification time mtime.
+func writeFiles(t *testing.T, dir string, mtime time.Time, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name+"\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// listFiles returns the files of dir, relative to it.
+func listFiles(t *testing.T, dir string) string {
+	t.Helper()
+	var files []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return strings.Join(files, " ")
+}
+
+func TestMaintainDays(t *testing.T) {
+	defer func(k int, c, v string) { keepDays, compression, vehicle = k, c, v }(keepDays, compression, vehicle)
+	keepDays, compression, vehicle = 3, "gzip", "tren 1"
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	dir := t.TempDir()
+	l, err := newLayout(dir, "{vehicle}/{date}/{spec}.csv", rotateDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, now,
+		"tren-1/2026-10-10/014-speed.csv",
+		"tren-1/2026-10-17/014-speed.csv",
+		"tren-1/2026-10-17/020-door.csv",
+		"tren-1/2026-10-18/014-speed.csv",
+		// not of this recorder
+		"tren-1/2026-10-10/notes.txt",
+		"tren-2/2026-10-10/014-speed.csv",
+		"2026-10-10/014-speed.csv",
+	)
+	open := newOpenFiles()
+	fp, err := open.open(filepath.Join(dir, "tren-1/2026-10-17/020-door.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	maintainDays(l, open, now)
+	want := "2026-10-10/014-speed.csv " +
+		"tren-1/2026-10-10/notes.txt " +
+		"tren-1/2026-10-17/014-speed.csv.gz " +
+		"tren-1/2026-10-17/020-door.csv " +
+		"tren-1/2026-10-18/014-speed.csv " +
+		"tren-2/2026-10-10/014-speed.csv"
+	if got := listFiles(t, dir); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// without {date}, the day is that of the last change
+	dir = t.TempDir()
+	l, err = newLayout(dir, "{spec}.csv", rotateBySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, now.AddDate(0, 0, -5), "014-speed-0.csv", "014-speed-1.csv", "020-door-0.csv")
+	writeFiles(t, dir, now.AddDate(0, 0, -1), "020-door-1.csv")
+	fp, err = open.open(filepath.Join(dir, "014-speed-1.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	maintainDays(l, open, now)
+	if got, want := listFiles(t, dir), "014-speed-1.csv 020-door-1.csv.gz"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestMaintainDaysQuota(t *testing.T) {
+	checkLog := captureLog(t)
+	defer func(k int, m int64, c string) { keepDays, maxSize, compression = k, m, c }(keepDays, maxSize, compression)
+	keepDays, maxSize, compression = 0, 40, "none"
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	// 25 bytes each
+	dir := t.TempDir()
+	l, err := newLayout(dir, "{date}/{spec}.csv", rotateDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, now,
+		"2026-10-16/014-speed.csv",
+		"2026-10-16/020-doors.csv",
+		"2026-10-17/014-speed.csv",
+		"2026-10-18/014-speed.csv",
+	)
+	open := newOpenFiles()
+	fp, err := open.open(filepath.Join(dir, "2026-10-16/014-speed.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	// the open file still counts, so today and it exceed the quota
+	maintainDays(l, open, now)
+	if got, want := listFiles(t, dir), "2026-10-16/014-speed.csv 2026-10-18/014-speed.csv"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	checkLog(
+		"event=removed day=2026-10-16 files=1 reason=quota",
+		"event=removed day=2026-10-17 files=1 reason=quota",
+		"event=quota_exceeded size=50 max_size=40",
+	)
+}
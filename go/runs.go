@@ -0,0 +1,307 @@
+package mvb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Run is a sequence of samples at the same level. Manchester decoding only
+// needs the position of the edges, so a signal can be stored, replayed or
+// taken from other sources as a sequence of runs, without expanding it to one
+// byte per sample.
+type Run struct {
+	High    bool
+	Samples uint64
+}
+
+// RunReader reads a signal as a sequence of runs, the way io.Reader reads
+// bytes. Consecutive runs may have the same level.
+type RunReader interface {
+	ReadRuns(runs []Run) (n int, err error)
+}
+
+// runs read at a time by NewRunStream
+const runBatchSize = 4096
+
+// NewRunStream returns a Stream for the decoder over the runs read from r,
+// which are converted to edges by a separate goroutine.
+func NewRunStream(r RunReader) *EdgeStream {
+	s := newEdgeStream()
+	go runsLoop(r, s.batches, s.free)
+	return s
+}
+
+func runsLoop(r RunReader, batches chan *edgeBatch, free chan *edgeBatch) {
+	runs := make([]Run, runBatchSize)
+	level := LOW
+	end := uint64(0)
+	for {
+		b := <-free
+		b.edges = b.edges[:0]
+		n, err := r.ReadRuns(runs)
+		for _, run := range runs[:n] {
+			if run.Samples == 0 {
+				continue
+			}
+			if run.High != level {
+				b.edges = append(b.edges, end)
+				level = run.High
+			}
+			end += run.Samples
+		}
+		b.end = end
+		b.err = err
+		batches <- b
+		if err != nil {
+			return
+		}
+	}
+}
+
+// SampleRunReader finds the runs in a stream of samples, one byte per sample.
+type SampleRunReader struct {
+	d   *BufferedReader
+	err error
+}
+
+func NewSampleRunReader(r io.Reader) *SampleRunReader {
+	return &SampleRunReader{d: NewDoubleBufferedReader(r)}
+}
+
+// ReadRuns returns the runs of the samples read so far; a run continued in
+// the next buffer is returned as two runs.
+func (s *SampleRunReader) ReadRuns(runs []Run) (int, error) {
+	n := 0
+	for n < len(runs) {
+		if s.err == nil {
+			s.err = s.d.buffer()
+		}
+		if s.err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, s.err
+		}
+		buf := s.d.cur.buf
+		high := buf[0] == signalHigh
+		var i int
+		if high {
+			i = indexNot(buf, signalHigh)
+		} else {
+			i = bytes.IndexByte(buf, signalHigh)
+		}
+		if i < 0 {
+			i = len(buf)
+		}
+		runs[n] = Run{High: high, Samples: uint64(i)}
+		n++
+		s.d.cur.buf = buf[i:]
+		s.d.n += uint64(i)
+		if len(s.d.cur.buf) == 0 {
+			s.d.disposeBuffer()
+		}
+	}
+	return n, nil
+}
+
+// indexNot returns the index of the first byte of buf different from b, or
+// -1. It compares eight bytes at a time.
+func indexNot(buf []byte, b byte) int {
+	w := uint64(b) * 0x0101010101010101
+	i := 0
+	for ; i+8 <= len(buf); i += 8 {
+		if binary.LittleEndian.Uint64(buf[i:]) != w {
+			break
+		}
+	}
+	for ; i < len(buf); i++ {
+		if buf[i] != b {
+			return i
+		}
+	}
+	return -1
+}
+
+// The run file format is a header followed by one uvarint per run, holding
+// the number of samples shifted left by one, and the level in the lowest
+// bit.
+const runFileHeader = "MVBRUNS1"
+
+// RunWriter writes runs in the run file format.
+type RunWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func NewRunWriter(w io.Writer) (*RunWriter, error) {
+	rw := &RunWriter{w: bufio.NewWriter(w)}
+	if _, err := rw.w.WriteString(runFileHeader); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+func (rw *RunWriter) WriteRuns(runs []Run) error {
+	for _, run := range runs {
+		v := run.Samples << 1
+		if run.High {
+			v |= 1
+		}
+		n := binary.PutUvarint(rw.buf[:], v)
+		if _, err := rw.w.Write(rw.buf[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rw *RunWriter) Flush() error {
+	return rw.w.Flush()
+}
+
+// RunFileReader reads runs written by RunWriter.
+type RunFileReader struct {
+	r *bufio.Reader
+}
+
+func NewRunFileReader(r io.Reader) (*RunFileReader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(runFileHeader))
+	if _, err := io.ReadFull(br, header); err != nil || string(header) != runFileHeader {
+		return nil, errors.New("not a run file")
+	}
+	return &RunFileReader{r: br}, nil
+}
+
+func (f *RunFileReader) ReadRuns(runs []Run) (int, error) {
+	for n := range runs {
+		v, err := binary.ReadUvarint(f.r)
+		if err != nil {
+			if n > 0 && err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		runs[n] = Run{High: v&1 == 1, Samples: v >> 1}
+	}
+	return len(runs), nil
+}
+
+// VCDRunReader reads the runs of a one-bit signal from a Value Change Dump
+// file, as written by logic analyzers and simulators. Times are converted to
+// samples at SampleRate; unknown and high-impedance values are read as HIGH,
+// the idle level of the bus.
+type VCDRunReader struct {
+	s     *bufio.Scanner
+	id    string
+	scale float64 // seconds per time unit
+
+	level bool
+	// position of the last value change, in samples
+	pos uint64
+}
+
+var vcdUnits = map[string]float64{
+	"s":  1,
+	"ms": 1e-3,
+	"us": 1e-6,
+	"ns": 1e-9,
+	"ps": 1e-12,
+	"fs": 1e-15,
+}
+
+// NewVCDRunReader reads the header of a VCD file, looking for the signal with
+// the given name, or the first one-bit signal if name is empty.
+func NewVCDRunReader(r io.Reader, name string) (*VCDRunReader, error) {
+	v := &VCDRunReader{s: bufio.NewScanner(r), scale: 1e-9, level: LOW}
+	v.s.Split(bufio.ScanWords)
+	for v.s.Scan() {
+		switch v.s.Text() {
+		case "$timescale":
+			spec := strings.Join(v.section(), "")
+			i := strings.IndexFunc(spec, func(r rune) bool { return r < '0' || r > '9' })
+			if i <= 0 {
+				return nil, fmt.Errorf("invalid VCD timescale: %q", spec)
+			}
+			n, _ := strconv.Atoi(spec[:i])
+			unit, ok := vcdUnits[spec[i:]]
+			if !ok {
+				return nil, fmt.Errorf("invalid VCD timescale: %q", spec)
+			}
+			v.scale = float64(n) * unit
+		case "$var":
+			// $var type size id reference [range] $end
+			fields := v.section()
+			if len(fields) >= 4 && v.id == "" && fields[1] == "1" && (name == "" || fields[3] == name) {
+				v.id = fields[2]
+			}
+		case "$enddefinitions":
+			v.section()
+			if v.id == "" {
+				if name == "" {
+					return nil, errors.New("no one-bit signal in VCD file")
+				}
+				return nil, fmt.Errorf("signal %q not found in VCD file", name)
+			}
+			return v, nil
+		default:
+			if strings.HasPrefix(v.s.Text(), "$") {
+				v.section()
+			}
+		}
+	}
+	if err := v.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("VCD file without $enddefinitions")
+}
+
+// section returns the words up to the next $end.
+func (v *VCDRunReader) section() []string {
+	var words []string
+	for v.s.Scan() && v.s.Text() != "$end" {
+		words = append(words, v.s.Text())
+	}
+	return words
+}
+
+func (v *VCDRunReader) ReadRuns(runs []Run) (int, error) {
+	n := 0
+	for n < len(runs) && v.s.Scan() {
+		word := v.s.Text()
+		switch word[0] {
+		case '#':
+			t, err := strconv.ParseUint(word[1:], 10, 64)
+			if err != nil {
+				return n, fmt.Errorf("invalid VCD time: %q", word)
+			}
+			pos := uint64(math.Round(float64(t) * v.scale * SampleRate))
+			if pos > v.pos {
+				runs[n] = Run{High: v.level, Samples: pos - v.pos}
+				n++
+				v.pos = pos
+			}
+		case '0', '1', 'x', 'X', 'z', 'Z':
+			if word[1:] == v.id {
+				v.level = word[0] != '0'
+			}
+		case 'b', 'B', 'r', 'R':
+			// vector or real value, followed by its id
+			v.s.Scan()
+		}
+	}
+	if n > 0 {
+		return n, nil
+	}
+	if err := v.s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, io.EOF
+}
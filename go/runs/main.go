@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+	"log"
+	"mvb"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// Converts the signal read from stdin, in the format given with -input, to
+// the compact run file format, which can be replayed with -input=runs.
+func main() {
+	log.SetFlags(0)
+
+	if term.IsTerminal(0) {
+		log.Fatalf("stdin must be a pipe")
+	}
+
+	mvb.InitFlags()
+
+	r, err := mvb.NewInputRunReader()
+	if err != nil {
+		log.Fatal(err)
+	}
+	w, err := mvb.NewRunWriter(os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	runs := make([]mvb.Run, 4096)
+	for {
+		n, err := r.ReadRuns(runs)
+		if werr := w.WriteRuns(runs[:n]); werr != nil {
+			log.Fatal(werr)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
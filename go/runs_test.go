@@ -0,0 +1,100 @@
+package mvb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func readAllRuns(t *testing.T, r RunReader) []Run {
+	var all []Run
+	runs := make([]Run, 100)
+	for {
+		n, err := r.ReadRuns(runs)
+		all = append(all, runs[:n]...)
+		if err == io.EOF {
+			return all
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func compareEvents(t *testing.T, got []Event, want []Event) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if describe(got[i]) != describe(want[i]) {
+			t.Fatalf("event %d: got %s, want %s", i, describe(got[i]), describe(want[i]))
+		}
+	}
+}
+
+func TestRunFileRoundTrip(t *testing.T) {
+	samples := synthTraffic(100, 500)
+	runs := readAllRuns(t, NewSampleRunReader(bytes.NewReader(samples)))
+
+	var file bytes.Buffer
+	w, err := NewRunWriter(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRuns(runs); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if file.Len() >= len(samples)/4 {
+		t.Errorf("run file of %d bytes for %d samples", file.Len(), len(samples))
+	}
+
+	r, err := NewRunFileReader(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := decodeAll(NewMVBStreamReader(bytes.NewReader(samples)))
+	compareEvents(t, decodeAll(NewRunStream(r)), want)
+}
+
+// vcdOf writes the samples as a VCD file with a 1 ns timescale, along with
+// another signal that must be ignored.
+func vcdOf(samples []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("$date today $end\n$timescale 1 ns $end\n$scope module top $end\n")
+	b.WriteString("$var wire 8 # other [7:0] $end\n$var wire 1 ! mvb $end\n$upscope $end\n$enddefinitions $end\n")
+	b.WriteString("#0\n$dumpvars\n0!\nb0 #\n$end\n")
+	level := LOW
+	for i, s := range samples {
+		if (s == signalHigh) != level {
+			level = !level
+			v := 0
+			if level {
+				v = 1
+			}
+			fmt.Fprintf(&b, "#%d\n%d!\nb%b #\n", uint64(i)*1e9/SampleRate, v, i&0xff)
+		}
+	}
+	fmt.Fprintf(&b, "#%d\n", uint64(len(samples))*1e9/SampleRate)
+	return b.Bytes()
+}
+
+func TestVCDRunReader(t *testing.T) {
+	// 12 Msps is not a whole number of nanoseconds per sample: the positions
+	// must be rounded back to the same samples
+	samples := synthTraffic(50, 500)
+	r, err := NewVCDRunReader(bytes.NewReader(vcdOf(samples)), "mvb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := decodeAll(NewMVBStreamReader(bytes.NewReader(samples)))
+	compareEvents(t, decodeAll(NewRunStream(r)), want)
+
+	if _, err := NewVCDRunReader(bytes.NewReader(vcdOf(samples)), "missing"); err == nil {
+		t.Error("no error for a missing signal")
+	}
+}
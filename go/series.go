@@ -0,0 +1,134 @@
+package mvb
+
+import (
+	"encoding/binary"
+	"sort"
+	"time"
+)
+
+// seriesPoint is a change of a watched variable, as a big-endian integer of
+// size bytes.
+type seriesPoint struct {
+	n    uint64
+	raw  uint64
+	size uint8
+}
+
+func (p seriesPoint) value(signed bool) float64 {
+	if signed {
+		shift := 64 - 8*uint(p.size)
+		return float64(int64(p.raw<<shift) >> shift)
+	}
+	return float64(p.raw)
+}
+
+// Series keeps the changes of a watched variable during a window, and the
+// value before it. Values longer than 8 bytes are not kept.
+type Series struct {
+	window uint64
+	points []seriesPoint
+}
+
+func (s *Series) add(n uint64, value []byte) {
+	if len(value) == 0 || len(value) > 8 {
+		return
+	}
+	var b [8]byte
+	copy(b[8-len(value):], value)
+	p := seriesPoint{n, binary.BigEndian.Uint64(b[:]), uint8(len(value))}
+	if len(s.points) > 0 {
+		last := s.points[len(s.points)-1]
+		if n < last.n {
+			// going back, as when seeking a replay, forgets everything
+			s.points = s.points[:0]
+		} else if last.raw == p.raw && last.size == p.size {
+			return
+		}
+	}
+	s.points = append(s.points, p)
+	if n > s.window {
+		if i := s.search(n - s.window); i > 1 {
+			s.points = s.points[i-1:]
+		}
+	}
+}
+
+// search returns the index of the first change at or after n.
+func (s *Series) search(n uint64) int {
+	return sort.Search(len(s.points), func(i int) bool { return s.points[i].n >= n })
+}
+
+// at returns the value at n, if known.
+func (s *Series) at(n uint64, signed bool) (float64, bool) {
+	i := s.search(n + 1)
+	if i == 0 {
+		return 0, false
+	}
+	return s.points[i-1].value(signed), true
+}
+
+// span returns the minimum and maximum values from a to b, excluded, if
+// any is known.
+func (s *Series) span(a, b uint64, signed bool) (min, max float64, ok bool) {
+	i := s.search(a)
+	if i > 0 && (i == len(s.points) || s.points[i].n > a) {
+		// the value at a
+		i--
+	}
+	for ; i < len(s.points) && s.points[i].n < b; i++ {
+		v := s.points[i].value(signed)
+		if !ok || v < min {
+			min = v
+		}
+		if !ok || v > max {
+			max = v
+		}
+		ok = true
+	}
+	return min, max, ok
+}
+
+// spark plots the maximum of each of width intervals of the span ending at
+// end, blank before the first value.
+func (s *Series) spark(end, span uint64, width int, signed bool) string {
+	vs := make([]float64, width)
+	ok := make([]bool, width)
+	start := end - span
+	if span > end {
+		start = 0
+	}
+	for i := range vs {
+		a := start + (end-start)*uint64(i)/uint64(width)
+		b := start + (end-start)*uint64(i+1)/uint64(width)
+		_, vs[i], ok[i] = s.span(a, b, signed)
+	}
+	return sparkFloat(vs, ok)
+}
+
+// VarHistory keeps a Series for each watched variable, as they are set in
+// Stats.
+type VarHistory struct {
+	specs  []RecorderPortSpec
+	Series []*Series
+}
+
+// NewVarHistory returns nil if window is not positive or there are no
+// watched variables.
+func NewVarHistory(specs []RecorderPortSpec, window time.Duration) *VarHistory {
+	if window <= 0 || len(specs) == 0 {
+		return nil
+	}
+	h := &VarHistory{specs: specs}
+	for range specs {
+		h.Series = append(h.Series, &Series{window: uint64(window.Seconds() * SampleRate)})
+	}
+	return h
+}
+
+func (h *VarHistory) SetVar(n uint64, port uint16, value []byte) {
+	for i, spec := range h.specs {
+		if spec.Port == port {
+			h.Series[i].add(n, slice(value, spec.I, spec.J))
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package mvb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeries(t *testing.T) {
+	h := NewVarHistory([]RecorderPortSpec{{0x014, 0, 1, "a"}, {0x014, 1, 3, "b"}}, time.Second)
+	second := uint64(SampleRate)
+	a, b := h.Series[0], h.Series[1]
+
+	h.SetVar(0, 0x014, []byte{1, 0xff, 0xfe})
+	h.SetVar(second/2, 0x014, []byte{1, 0, 2})
+	h.SetVar(second/2+1, 0x014, []byte{1, 0, 2})
+	h.SetVar(2*second, 0x014, []byte{3, 0, 4})
+	// only the changes are kept, and the last one before the window
+	if len(a.points) != 2 || len(b.points) != 2 {
+		t.Errorf("got %d and %d points", len(a.points), len(b.points))
+	}
+
+	if v, ok := b.at(second, false); !ok || v != 2 {
+		t.Errorf("got %v, %v", v, ok)
+	}
+	if min, max, ok := b.span(second, 2*second+1, false); !ok || min != 2 || max != 4 {
+		t.Errorf("got %v, %v, %v", min, max, ok)
+	}
+	if _, _, ok := b.span(0, second/2, false); ok {
+		t.Errorf("value before the first one")
+	}
+	// blank before the first value kept
+	if got := b.spark(2*second+1, 2*second+1, 4, false); got != " ▁▁█" {
+		t.Errorf("got %q", got)
+	}
+
+	// signed values, as two's complement of their size
+	h.SetVar(3*second, 0x014, []byte{0xff, 0xff, 0xfe})
+	if v, _ := a.at(3*second, true); v != -1 {
+		t.Errorf("got %v", v)
+	}
+	if v, _ := b.at(3*second, true); v != -2 {
+		t.Errorf("got %v", v)
+	}
+	if v, _ := b.at(3*second, false); v != 0xfffe {
+		t.Errorf("got %v", v)
+	}
+
+	// going back forgets everything
+	h.SetVar(second, 0x014, []byte{5, 0, 0})
+	if len(a.points) != 1 {
+		t.Errorf("got %d points", len(a.points))
+	}
+
+	if NewVarHistory(nil, time.Second) != nil || NewVarHistory(h.specs, 0) != nil {
+		t.Errorf("history without vars or window")
+	}
+}
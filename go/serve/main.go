@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"mvb"
+
+	"golang.org/x/term"
+)
+
+func main() {
+	log.SetFlags(0)
+
+	if term.IsTerminal(0) {
+		log.Fatalf("stdin must be a pipe")
+	}
+
+	mvb.InitFlags()
+
+	ports, err := mvb.ParseRecorderPortSpecs(flag.CommandLine.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	grpc, err := mvb.StartGRPC(ports)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server, err := mvb.NewServer(nil)
+	if err == mvb.ErrNoAddress && grpc != nil {
+		// only the gRPC API
+		server = nil
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	stream, err := mvb.NewInputStream()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	events := make(chan mvb.Event)
+	decoder := mvb.NewDecoder(stream)
+	go decoder.Loop(events)
+	metrics := mvb.StartMetrics(ports)
+	bus := mvb.NewBus()
+	metrics.WatchBus(bus)
+	if metrics != nil {
+		bus.Go("metrics", mvb.OverflowFlag, metrics.Loop)
+	}
+	if grpc != nil {
+		bus.Go("grpc", mvb.OverflowFlag, grpc.Loop)
+	}
+	if server != nil {
+		bus.Go("server", mvb.OverflowFlag, server.Loop)
+	}
+	go bus.Loop(events)
+	bus.Wait()
+}
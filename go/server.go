@@ -0,0 +1,487 @@
+package mvb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var listenAddrs []string
+
+// ErrNoAddress is returned by NewServer when no address was given, neither
+// as an argument nor with -listen.
+var ErrNoAddress = errors.New("no address to listen on")
+
+func initServerFlags() {
+	flag.Func("listen", "serve decoded events on this address (host:port or unix:/path); can be repeated", func(s string) error {
+		listenAddrs = append(listenAddrs, s)
+		return nil
+	})
+}
+
+const (
+	// events buffered per client before dropping
+	clientQueueSize    = 4096
+	clientWriteTimeout = 10 * time.Second
+)
+
+// Server fans out the decoded events to any number of clients connected over
+// TCP or Unix sockets. Each client receives newline-delimited JSON by
+// default, and may send a subscription line at any time to change the format
+// and filters, e.g.:
+//
+//	{"format":"binary","ports":["002","014"],"fcodes":[0,1],"errors":false}
+//
+// Events are never delayed for a slow client: when its queue is full, events
+// are dropped and the client receives a "dropped" event with the count.
+type Server struct {
+	listeners []net.Listener
+
+	mu      sync.Mutex
+	clients map[*serverClient]struct{}
+	closed  bool
+	// client goroutines
+	wg sync.WaitGroup
+}
+
+func networkAddr(addr string) (network string, address string) {
+	if strings.HasPrefix(addr, "unix:") {
+		return "unix", strings.TrimPrefix(addr, "unix:")
+	}
+	return "tcp", addr
+}
+
+func NewServer(addrs []string) (*Server, error) {
+	if len(addrs) == 0 {
+		addrs = listenAddrs
+	}
+	if len(addrs) == 0 {
+		return nil, ErrNoAddress
+	}
+	s := &Server{clients: make(map[*serverClient]struct{})}
+	for _, addr := range addrs {
+		network, address := networkAddr(addr)
+		if network == "unix" {
+			// remove the stale socket left by a previous run
+			os.Remove(address)
+		}
+		l, err := net.Listen(network, address)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.listeners = append(s.listeners, l)
+	}
+	return s, nil
+}
+
+// Close stops listening and waits until the events queued for each client
+// are written, or its write times out, and the client is disconnected.
+func (s *Server) Close() {
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		for c := range s.clients {
+			close(c.events)
+		}
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) Loop(mvbEvents chan Event) {
+	for _, l := range s.listeners {
+		logEvent("listening", "addr", l.Addr())
+		go s.accept(l)
+	}
+	for ev := range mvbEvents {
+		s.broadcast(ev)
+	}
+	s.Close()
+}
+
+func (s *Server) accept(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			logEvent("accept_error", "addr", l.Addr(), "err", err)
+			return
+		}
+		c := &serverClient{
+			conn:   conn,
+			events: make(chan Event, clientQueueSize),
+			filter: &serverFilter{Format: "json", Errors: true},
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.clients[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		logEvent("client_connected", "addr", conn.RemoteAddr())
+		go c.readFilters()
+		go func() {
+			defer s.wg.Done()
+			err := c.writeLoop()
+			s.mu.Lock()
+			delete(s.clients, c)
+			s.mu.Unlock()
+			conn.Close()
+			logEvent("client_disconnected", "addr", conn.RemoteAddr(), "err", err)
+		}()
+	}
+}
+
+func (s *Server) broadcast(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if o, ok := ev.(*Overrun); ok {
+		// the server itself fell behind: every client missed the events
+		for c := range s.clients {
+			c.mu.Lock()
+			c.dropped += int(o.Dropped)
+			c.mu.Unlock()
+		}
+		return
+	}
+	for c := range s.clients {
+		if !c.getFilter().match(ev) {
+			continue
+		}
+		select {
+		case c.events <- ev:
+		default:
+			c.mu.Lock()
+			c.dropped++
+			c.mu.Unlock()
+		}
+	}
+}
+
+type serverFilter struct {
+	Format string   `json:"format"`
+	Ports  []string `json:"ports"`
+	FCodes []uint8  `json:"fcodes"`
+	Errors bool     `json:"errors"`
+
+	ports map[uint16]bool
+}
+
+func (f *serverFilter) match(ev Event) bool {
+	switch ev := ev.(type) {
+	case *Telegram:
+		if f.ports != nil && !f.ports[ev.Master.Address] {
+			return false
+		}
+		if len(f.FCodes) == 0 {
+			return true
+		}
+		for _, fcode := range f.FCodes {
+			if fcode == ev.Master.FCode {
+				return true
+			}
+		}
+		return false
+	case Error:
+		return f.Errors
+	}
+	return false
+}
+
+func parseServerFilter(line []byte) (*serverFilter, error) {
+	f := &serverFilter{Format: "json", Errors: true}
+	if err := json.Unmarshal(line, f); err != nil {
+		return nil, err
+	}
+	if f.Format != "json" && f.Format != "binary" {
+		return nil, fmt.Errorf("invalid format: %q", f.Format)
+	}
+	if len(f.Ports) > 0 {
+		f.ports = make(map[uint16]bool)
+		for _, p := range f.Ports {
+			port, err := strconv.ParseUint(strings.TrimPrefix(p, "0x"), 16, 12)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port: %q", p)
+			}
+			f.ports[uint16(port)] = true
+		}
+	}
+	return f, nil
+}
+
+type serverClient struct {
+	conn   net.Conn
+	events chan Event
+
+	mu      sync.Mutex
+	filter  *serverFilter
+	dropped int
+}
+
+func (c *serverClient) getFilter() *serverFilter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.filter
+}
+
+// readFilters reads the subscription lines sent by the client.
+func (c *serverClient) readFilters() {
+	scanner := bufio.NewScanner(c.conn)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		f, err := parseServerFilter(line)
+		if err != nil {
+			logEvent("client_error", "addr", c.conn.RemoteAddr(), "err", err)
+			continue
+		}
+		c.mu.Lock()
+		c.filter = f
+		c.mu.Unlock()
+	}
+}
+
+func (c *serverClient) writeLoop() error {
+	w := bufio.NewWriter(c.conn)
+	for ev := range c.events {
+		c.mu.Lock()
+		format := c.filter.Format
+		dropped := c.dropped
+		c.dropped = 0
+		c.mu.Unlock()
+
+		c.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		if dropped > 0 {
+			if err := writeDropped(w, format, dropped); err != nil {
+				return err
+			}
+		}
+		if err := writeEvent(w, format, ev); err != nil {
+			return err
+		}
+		// flush once the queue is drained, to batch writes under load
+		if len(c.events) == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// StreamEvent is the JSON representation of a decoded event.
+type StreamEvent struct {
+	Type    string  `json:"type"`
+	N       uint64  `json:"n,omitempty"`
+	Time    float64 `json:"time,omitempty"`
+	FCode   *uint8  `json:"fcode,omitempty"`
+	Request string  `json:"request,omitempty"`
+	Address string  `json:"address,omitempty"`
+	Data    *string `json:"data,omitempty"`
+	Class   string  `json:"class,omitempty"`
+	Message string  `json:"message,omitempty"`
+	Count   int     `json:"count,omitempty"`
+}
+
+func newStreamEvent(ev Event) *StreamEvent {
+	se := &StreamEvent{
+		N:    ev.N(),
+		Time: sampleTimestamp(ev.N()).Seconds(),
+	}
+	switch ev := ev.(type) {
+	case *Telegram:
+		fcode := ev.Master.FCode
+		se.Type = "telegram"
+		se.FCode = &fcode
+		se.Request = fcodes[fcode].MasterRequest.String()
+		se.Address = fmt.Sprintf("%03x", ev.Master.Address)
+		if ev.Slave != nil {
+			data := hex.EncodeToString(ev.Slave.data)
+			se.Data = &data
+		}
+	case Error:
+		se.Type = "error"
+		se.Class = ev.Class()
+		se.Message = ev.Error()
+	}
+	return se
+}
+
+// Binary framing: each frame is a type byte, a big-endian uint16 payload
+// length and the payload.
+//
+//	telegram: n (uint64) fcode (uint8) address (uint16) has slave (uint8) slave data
+//	error:    n (uint64) message
+//	dropped:  count (uint32)
+const (
+	frameTelegram = byte('T')
+	frameError    = byte('E')
+	frameDropped  = byte('D')
+)
+
+func writeFrame(w io.Writer, t byte, payload []byte) error {
+	var header [3]byte
+	header[0] = t
+	binary.BigEndian.PutUint16(header[1:], uint16(len(payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func writeEvent(w io.Writer, format string, ev Event) error {
+	if format == "json" {
+		return writeJSONLine(w, newStreamEvent(ev))
+	}
+	payload := make([]byte, 8, 64)
+	binary.BigEndian.PutUint64(payload, ev.N())
+	switch ev := ev.(type) {
+	case *Telegram:
+		var address [2]byte
+		binary.BigEndian.PutUint16(address[:], ev.Master.Address)
+		payload = append(payload, ev.Master.FCode)
+		payload = append(payload, address[:]...)
+		if ev.Slave != nil {
+			payload = append(payload, 1)
+			payload = append(payload, ev.Slave.data...)
+		} else {
+			payload = append(payload, 0)
+		}
+		return writeFrame(w, frameTelegram, payload)
+	case Error:
+		payload = append(payload, ev.Error()...)
+		if len(payload) > 0xffff {
+			payload = payload[:0xffff]
+		}
+		return writeFrame(w, frameError, payload)
+	}
+	return nil
+}
+
+func writeDropped(w io.Writer, format string, count int) error {
+	if format == "json" {
+		return writeJSONLine(w, &StreamEvent{Type: "dropped", Count: count})
+	}
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], uint32(count))
+	return writeFrame(w, frameDropped, payload[:])
+}
+
+func writeJSONLine(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+const reconnectInterval = 1 * time.Second
+
+// RemoteDecoder receives the events from a Server, and can be used in place
+// of MVBDecoder.
+type RemoteDecoder struct {
+	addr string
+	n    uint64
+}
+
+func NewRemoteDecoder(addr string) *RemoteDecoder {
+	return &RemoteDecoder{addr: addr}
+}
+
+// N returns the sample number of the last received event.
+func (d *RemoteDecoder) N() uint64 {
+	return atomic.LoadUint64(&d.n)
+}
+
+// Loop reads the events from the server, reconnecting when the connection is
+// lost. Connection errors are reported as Error events.
+func (d *RemoteDecoder) Loop(events chan<- Event) {
+	for {
+		err := d.receive(events)
+		events <- Error{error: fmt.Errorf("connection to %s: %w", d.addr, err), n: d.N()}
+		time.Sleep(reconnectInterval)
+	}
+}
+
+func (d *RemoteDecoder) receive(events chan<- Event) error {
+	network, address := networkAddr(d.addr)
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var se StreamEvent
+		if err := json.Unmarshal(scanner.Bytes(), &se); err != nil {
+			return err
+		}
+		if se.N != 0 {
+			atomic.StoreUint64(&d.n, se.N)
+		}
+		switch se.Type {
+		case "telegram":
+			t, err := se.telegram()
+			if err != nil {
+				return err
+			}
+			events <- t
+		case "error":
+			events <- Error{error: errors.New(se.Message), n: se.N}
+		case "dropped":
+			events <- Error{error: fmt.Errorf("server dropped %d events", se.Count), n: d.N()}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (se *StreamEvent) telegram() (*Telegram, error) {
+	if se.FCode == nil || fcodes[*se.FCode] == nil {
+		return nil, fmt.Errorf("invalid telegram: missing fcode")
+	}
+	address, err := strconv.ParseUint(se.Address, 16, 12)
+	if err != nil {
+		return nil, fmt.Errorf("invalid telegram: %w", err)
+	}
+	t := &Telegram{
+		n:      se.N,
+		Master: &MasterFrame{FCode: *se.FCode, Address: uint16(address)},
+	}
+	if se.Data != nil {
+		data, err := hex.DecodeString(*se.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid telegram: %w", err)
+		}
+		t.Slave = &SlaveFrame{data}
+	}
+	return t, nil
+}
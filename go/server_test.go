@@ -0,0 +1,91 @@
+package mvb
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitClients waits until n clients are connected to s.
+func waitClients(t *testing.T, s *Server, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		connected := len(s.clients)
+		s.mu.Unlock()
+		if connected == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients connected, want %d", connected, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServer(t *testing.T) {
+	sock := "unix:" + filepath.Join(t.TempDir(), "mvb.sock")
+	s, err := NewServer([]string{"127.0.0.1:0", sock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan Event)
+	done := make(chan bool)
+	go func() {
+		s.Loop(events)
+		done <- true
+	}()
+
+	// a RemoteDecoder over TCP, and a raw client over the Unix socket
+	remote := NewRemoteDecoder(s.listeners[0].Addr().String())
+	received := make(chan Event, 10)
+	remoteErr := make(chan error, 1)
+	go func() { remoteErr <- remote.receive(received) }()
+	network, address := networkAddr(sock)
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitClients(t, s, 2)
+
+	sent := []Event{
+		testTelegram(100, 0x014, "012c"),
+		testTelegram(200, 0x020, ""),
+		Error{error: errors.New("CRC mismatch"), n: 300},
+	}
+	for _, ev := range sent {
+		events <- ev
+	}
+	// the queued events are written before the clients are disconnected
+	close(events)
+	<-done
+	waitClients(t, s, 0)
+
+	for _, want := range sent {
+		if got := <-received; describe(got) != describe(want) {
+			t.Errorf("got %s, want %s", describe(got), describe(want))
+		}
+	}
+	if err := <-remoteErr; err != io.EOF {
+		t.Errorf("got %v, want EOF", err)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	want := `{"type":"telegram","n":100,"time":0.000008333,"fcode":1,"request":"PROCESS_DATA","address":"014","data":"012c"}
+{"type":"telegram","n":200,"time":0.000016666,"fcode":1,"request":"PROCESS_DATA","address":"020"}
+{"type":"error","n":300,"time":0.000025,"class":"crc","message":"CRC mismatch"}`
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
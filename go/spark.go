@@ -1,31 +1,45 @@
 package mvb
 
-import (
-	"strings"
-)
-
 func spark(vs []uint64) string {
-	min := vs[0]
-	max := vs[0]
-	for _, v := range vs {
-		if v < min {
+	fs := make([]float64, len(vs))
+	for i, v := range vs {
+		fs[i] = float64(v)
+	}
+	return sparkFloat(fs, nil)
+}
+
+// sparkFloat is spark for any values; if ok is not nil, the values that are
+// not ok are left blank.
+func sparkFloat(vs []float64, ok []bool) string {
+	first := true
+	var min, max float64
+	for i, v := range vs {
+		if ok != nil && !ok[i] {
+			continue
+		}
+		if first || v < min {
 			min = v
 		}
-		if v > max {
+		if first || v > max {
 			max = v
 		}
-	}
-	if min == max {
-		return strings.Repeat("▁", len(vs))
+		first = false
 	}
 	rs := make([]rune, len(vs))
-	f := float64(8) / float64(max-min)
+	f := 8 / (max - min)
 	for j, v := range vs {
-		i := rune(f * float64(v-min))
-		if i > 7 {
-			i = 7
+		switch {
+		case ok != nil && !ok[j]:
+			rs[j] = ' '
+		case min == max:
+			rs[j] = '▁'
+		default:
+			i := rune(f * (v - min))
+			if i > 7 {
+				i = 7
+			}
+			rs[j] = '▁' + i
 		}
-		rs[j] = '▁' + i
 	}
 	return string(rs)
 }
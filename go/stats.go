@@ -2,6 +2,7 @@ package mvb
 
 import (
 	"bytes"
+	"fmt"
 	"sort"
 )
 
@@ -18,9 +19,28 @@ type Stats struct {
 	errorRate []uint64
 	ErrorLog  []Error
 
+	// events lost because this consumer fell behind the decoder
+	Dropped  uint64
+	dropRate []uint64
+
 	Vars map[uint16][]byte
 
+	// last status reported by each device
+	Devices map[uint16][]byte
+
 	Capture *Capture
+
+	// last telegrams and var changes, nil if -capture-last is 0
+	Ring *Ring
+
+	// recent values of the watched variables, nil if -history-window is 0
+	History *VarHistory
+
+	// conditions to start and stop a capture, and samples to keep capturing
+	// after them
+	startTriggers []*Trigger
+	stopTriggers  []*Trigger
+	triggerPost   uint64
 }
 
 type Var struct {
@@ -36,12 +56,25 @@ func NewStats() Stats {
 	return Stats{
 		rate:      newRate(),
 		errorRate: newRate(),
+		dropRate:  newRate(),
 		mrRates:   mrRates,
 		ErrorLog:  make([]Error, 0, errorLogSize),
 		Vars:      make(map[uint16][]byte),
+		Devices:   make(map[uint16][]byte),
 	}
 }
 
+// newCaptureStats returns Stats with the ring and the triggers given with the
+// flags, as used by the dashboards.
+func newCaptureStats() Stats {
+	s := NewStats()
+	s.Ring = NewRing(captureLast, captureLastTelegrams)
+	s.startTriggers = startTriggers
+	s.stopTriggers = stopTriggers
+	s.triggerPost = uint64(triggerPost.Seconds() * SampleRate)
+	return s
+}
+
 func newRate() []uint64 {
 	return make([]uint64, sparkSize+1)
 }
@@ -76,6 +109,7 @@ func (s *Stats) ErrorRate() []uint64 {
 func (s *Stats) Tick() {
 	rateShift(s.rate)
 	rateShift(s.errorRate)
+	rateShift(s.dropRate)
 	for i := range s.mrRates {
 		rateShift(s.mrRates[i])
 	}
@@ -89,13 +123,26 @@ func (s *Stats) CountTelegram(t *Telegram) {
 	if s.Capture != nil && !s.Capture.Stopped {
 		s.Capture.AddTelegram(t)
 	}
+	if s.Ring != nil {
+		s.Ring.AddTelegram(t)
+	}
 	if fcode.MasterRequest == MR_PROCESS_DATA && t.Slave != nil {
 		s.SetVar(t.N(), t.Master.Address, t.Slave.data)
 	}
+	if fcode.MasterRequest == MR_DEVICE_STATUS && t.Slave != nil {
+		s.Devices[t.Master.Address] = t.Slave.data
+	}
+	s.checkTriggers(t)
 }
 
 func (s *Stats) SetVar(n uint64, port uint16, value []byte) {
+	if old, ok := s.Vars[port]; s.Ring != nil && (!ok || !bytes.Equal(old, value)) {
+		s.Ring.SetVar(n, port, value)
+	}
 	s.Vars[port] = value
+	if s.History != nil {
+		s.History.SetVar(n, port, value)
+	}
 	if s.Capture != nil && !s.Capture.Stopped {
 		s.Capture.SetVar(n, port, value)
 	}
@@ -110,6 +157,23 @@ func (s *Stats) CountError(err Error) {
 	}
 	s.ErrorLog = append(s.ErrorLog, err)
 	rateCount(s.errorRate)
+	s.checkTriggers(err)
+}
+
+func (s *Stats) CountOverrun(o *Overrun) {
+	s.Dropped += o.Dropped
+	s.dropRate[len(s.dropRate)-1] += o.Dropped
+}
+
+func (s *Stats) DropRate() []uint64 {
+	return rateView(s.dropRate)
+}
+
+// Overrun reports whether events were dropped during the current or the
+// last second.
+func (s *Stats) Overrun() bool {
+	n := len(s.dropRate)
+	return s.dropRate[n-1] > 0 || s.dropRate[n-2] > 0
 }
 
 func (s *Stats) StartStopCapture() {
@@ -126,11 +190,80 @@ func (s *Stats) DiscardCapture() {
 	s.Capture = nil
 }
 
+// CaptureLast replaces the capture with the last -capture-last of telegrams
+// and var changes.
+func (s *Stats) CaptureLast() error {
+	if s.Ring == nil {
+		return fmt.Errorf("-capture-last is disabled")
+	}
+	if s.Capture != nil && !s.Capture.Stopped {
+		return fmt.Errorf("capture running")
+	}
+	s.Capture = s.Ring.Capture()
+	return nil
+}
+
+// SaveCapture saves the capture, as SaveCapture, and returns the path of the
+// file.
+func (s *Stats) SaveCapture() (string, error) {
+	if s.Capture == nil {
+		return "", fmt.Errorf("no capture")
+	}
+	return SaveCapture(s.Capture)
+}
+
+// checkTriggers starts a capture when a start trigger matches and there is no
+// capture, and stops the running capture once it's due.
+func (s *Stats) checkTriggers(ev Event) {
+	c := s.Capture
+	n := ev.N()
+	switch {
+	case c == nil:
+		if t := matchTrigger(s.startTriggers, ev, s); t != nil {
+			s.startTriggered(t, n)
+		}
+	case c.Stopped:
+	case c.stopping:
+		if n >= c.stopAt {
+			c.Stopped = true
+		}
+	default:
+		if t := matchTrigger(s.stopTriggers, ev, s); t != nil {
+			c.stopping = true
+			c.stopAt = n + s.triggerPost
+		}
+	}
+}
+
+// startTriggered starts a capture with the history in the ring.
+func (s *Stats) startTriggered(t *Trigger, n uint64) {
+	if s.Ring != nil {
+		s.Capture = s.Ring.Capture()
+	} else {
+		s.Capture = &Capture{Vars: make(map[uint16][]VarChange)}
+	}
+	c := s.Capture
+	c.Stopped = false
+	c.Trigger = fmt.Sprintf("%s at %.3fs", t, sampleTimestamp(n).Seconds())
+	if len(s.stopTriggers) == 0 {
+		c.stopping = true
+		c.stopAt = n + s.triggerPost
+	}
+	logEvent("capture_triggered", "trigger", t, "n", n)
+}
+
 type Capture struct {
 	Telegrams []*Telegram
 	Stopped   bool
 	Vars      map[uint16][]VarChange
 	SeenPorts []int
+
+	// condition that started the capture, if any
+	Trigger string
+
+	// the capture stops on the first event at stopAt or later
+	stopping bool
+	stopAt   uint64
 }
 
 type VarChange struct {
@@ -0,0 +1,97 @@
+package mvb
+
+import "bytes"
+
+// samples per half bit time, at SampleRate
+const synthHalfBit = BT2_SAMPLES
+
+// synth builds sample streams the way gen/gen.c does.
+type synth struct {
+	buf bytes.Buffer
+}
+
+func (s *synth) level(v bool, samples int) {
+	b := signalLow
+	if v {
+		b = signalHigh
+	}
+	for i := 0; i < samples; i++ {
+		s.buf.WriteByte(b)
+	}
+}
+
+func (s *synth) symbol(sym Symbol) {
+	switch sym {
+	case BIT_0:
+		s.level(LOW, synthHalfBit)
+		s.level(HIGH, synthHalfBit)
+	case BIT_1:
+		s.level(HIGH, synthHalfBit)
+		s.level(LOW, synthHalfBit)
+	case NH:
+		s.level(HIGH, 2*synthHalfBit)
+	case NL:
+		s.level(LOW, 2*synthHalfBit)
+	}
+}
+
+// frame writes the start bit, the start delimiter, the bytes (including the
+// check sequences) and the end delimiter of a frame.
+func (s *synth) frame(master bool, data []byte) {
+	s.symbol(BIT_1)
+	delimiter := slaveStartDelimiter
+	if master {
+		delimiter = masterStartDelimiter
+	}
+	for _, sym := range delimiter {
+		s.symbol(sym)
+	}
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			s.symbol(Symbol(b >> i & 1))
+		}
+	}
+	s.symbol(NL)
+	s.symbol(NH)
+}
+
+// withCRC appends a check sequence after every 8 bytes of data.
+func withCRC(data []byte) []byte {
+	var out []byte
+	for i := 0; i < len(data); i += 8 {
+		chunk := data[i:]
+		if len(chunk) > 8 {
+			chunk = chunk[:8]
+		}
+		out = append(out, chunk...)
+		out = append(out, calcCRC(chunk))
+	}
+	return out
+}
+
+// telegram writes a master frame and its slave frame, if slave is not nil.
+func (s *synth) telegram(fcode uint8, address uint16, slave []byte) {
+	s.level(HIGH, 40)
+	s.frame(true, withCRC([]byte{fcode<<4 | byte(address>>8), byte(address)}))
+	if slave != nil {
+		s.level(HIGH, 60)
+		s.frame(false, withCRC(slave))
+	}
+}
+
+// synthTraffic returns n telegrams reading process data ports of every size,
+// separated by idle samples.
+func synthTraffic(n int, idle int) []byte {
+	var s synth
+	for i := 0; i < n; i++ {
+		s.level(HIGH, idle)
+		fcode := uint8(i % 5)
+		data := make([]byte, fcodes[fcode].SlaveFrameSize/8)
+		for j := range data {
+			data[j] = byte(i + j)
+		}
+		s.telegram(fcode, uint16(i%0x1000), data)
+	}
+	s.level(HIGH, 100)
+	return s.buf.Bytes()
+}
@@ -0,0 +1,235 @@
+package mvb
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// conditions as given, compiled by InitConditions
+	startTriggerFlags []string
+	stopTriggerFlags  []string
+
+	startTriggers []*Trigger
+	stopTriggers  []*Trigger
+	triggerPost   = 1 * time.Second
+)
+
+func initTriggerFlags() {
+	flag.Func("trigger", "start a capture, with the last -capture-last before it, when this condition matches: an expression, fcode:N, error:CLASS, missing[:PORT] or value:PORT[:I:J]OP N; can be repeated", func(s string) error {
+		startTriggerFlags = append(startTriggerFlags, s)
+		return nil
+	})
+	flag.Func("trigger-stop", "stop the running capture -trigger-post after this condition matches; can be repeated", func(s string) error {
+		stopTriggerFlags = append(stopTriggerFlags, s)
+		return nil
+	})
+	flag.DurationVar(&triggerPost, "trigger-post", triggerPost, "keep capturing for this long after the trigger, or after the -trigger-stop condition if any")
+}
+
+// InitConditions compiles the conditions given with -trigger, -trigger-stop,
+// -highlight and -record-if, whose var() refers to the watched ports.
+func InitConditions(ports []RecorderPortSpec) error {
+	for _, s := range startTriggerFlags {
+		t, err := ParseTrigger(s, ports)
+		if err != nil {
+			return err
+		}
+		startTriggers = append(startTriggers, t)
+	}
+	for _, s := range stopTriggerFlags {
+		t, err := ParseTrigger(s, ports)
+		if err != nil {
+			return err
+		}
+		stopTriggers = append(stopTriggers, t)
+	}
+	for _, s := range highlightFlags {
+		e, err := CompileExpr(s, ports)
+		if err != nil {
+			return fmt.Errorf("highlight: %v", err)
+		}
+		highlights = append(highlights, e)
+	}
+	if recordIfFlag != "" {
+		e, err := CompileExpr(recordIfFlag, ports)
+		if err != nil {
+			return fmt.Errorf("record-if: %v", err)
+		}
+		recordIf = e
+	}
+	return nil
+}
+
+// Trigger is a condition on the decoded events that starts or stops a
+// capture.
+type Trigger struct {
+	desc  string
+	match func(ev Event, s *Stats) bool
+}
+
+func (t *Trigger) String() string {
+	return t.desc
+}
+
+// Match reports whether the condition holds after ev was counted in s.
+func (t *Trigger) Match(ev Event, s *Stats) bool {
+	return t.match(ev, s)
+}
+
+var triggerOps = []struct {
+	op  string
+	cmp func(a, b uint64) bool
+}{
+	// longer first, for <= to be found before <
+	{"==", func(a, b uint64) bool { return a == b }},
+	{"!=", func(a, b uint64) bool { return a != b }},
+	{"<=", func(a, b uint64) bool { return a <= b }},
+	{">=", func(a, b uint64) bool { return a >= b }},
+	{"<", func(a, b uint64) bool { return a < b }},
+	{">", func(a, b uint64) bool { return a > b }},
+}
+
+// ParseTrigger parses a condition, either an expression, as CompileExpr, or
+// one of:
+//
+//	fcode:N            a telegram with this fcode
+//	error:CLASS        a decoding error of this class, e.g. crc
+//	missing[:PORT]     a master frame without the slave frame it requires
+//	value:PORT[:I:J]OP N
+//	                   a process data telegram whose data, or the bytes I to
+//	                   J of it, compare as a big-endian unsigned integer with
+//	                   N; OP is one of == != < <= > >=
+func ParseTrigger(s string, vars []RecorderPortSpec) (*Trigger, error) {
+	kind, arg, _ := strings.Cut(s, ":")
+	t := &Trigger{desc: s}
+	switch kind {
+	case "fcode":
+		fcode, err := strconv.ParseUint(arg, 0, 8)
+		if err != nil || fcodes[uint8(fcode)] == nil {
+			return nil, fmt.Errorf("trigger %q: invalid fcode", s)
+		}
+		t.match = func(ev Event, _ *Stats) bool {
+			tg, ok := ev.(*Telegram)
+			return ok && tg.Master.FCode == uint8(fcode)
+		}
+	case "error":
+		if !isErrorClass(arg) {
+			return nil, fmt.Errorf("trigger %q: invalid error class", s)
+		}
+		t.match = func(ev Event, _ *Stats) bool {
+			err, ok := ev.(Error)
+			return ok && err.Class() == arg
+		}
+	case "missing":
+		port := -1
+		if arg != "" {
+			p, err := parseTriggerPort(arg)
+			if err != nil {
+				return nil, fmt.Errorf("trigger %q: %v", s, err)
+			}
+			port = int(p)
+		}
+		t.match = func(ev Event, _ *Stats) bool {
+			tg, ok := ev.(*Telegram)
+			return ok && tg.Slave == nil && fcodes[tg.Master.FCode].SlaveFrameSource != SFS_NONE &&
+				(port == -1 || tg.Master.Address == uint16(port))
+		}
+	case "value":
+		return parseValueTrigger(t, arg)
+	default:
+		e, err := CompileExpr(s, vars)
+		if err != nil {
+			return nil, fmt.Errorf("trigger: %v", err)
+		}
+		t.match = e.Eval
+	}
+	return t, nil
+}
+
+func parseValueTrigger(t *Trigger, arg string) (*Trigger, error) {
+	for _, op := range triggerOps {
+		spec, value, ok := strings.Cut(arg, op.op)
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(value), 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("trigger %q: invalid value %q", t.desc, value)
+		}
+		parts := strings.Split(strings.TrimSpace(spec), ":")
+		if len(parts) != 1 && len(parts) != 3 {
+			return nil, fmt.Errorf("trigger %q: invalid port %q", t.desc, spec)
+		}
+		port, err := parseTriggerPort(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("trigger %q: %v", t.desc, err)
+		}
+		i, j := -1, -1
+		if len(parts) == 3 {
+			i, err = strconv.Atoi(parts[1])
+			if err == nil {
+				j, err = strconv.Atoi(parts[2])
+			}
+			if err != nil || i < 0 || j <= i || j-i > 8 {
+				return nil, fmt.Errorf("trigger %q: invalid range %s:%s", t.desc, parts[1], parts[2])
+			}
+		}
+		cmp := op.cmp
+		t.match = func(ev Event, _ *Stats) bool {
+			tg, ok := ev.(*Telegram)
+			if !ok || tg.Slave == nil || tg.Master.Address != port ||
+				fcodes[tg.Master.FCode].MasterRequest != MR_PROCESS_DATA {
+				return false
+			}
+			data := tg.Slave.data
+			if i != -1 {
+				if j > len(data) {
+					return false
+				}
+				data = data[i:j]
+			}
+			if len(data) > 8 {
+				return false
+			}
+			var b [8]byte
+			copy(b[8-len(data):], data)
+			return cmp(binary.BigEndian.Uint64(b[:]), n)
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("trigger %q: missing comparison", t.desc)
+}
+
+func parseTriggerPort(s string) (uint16, error) {
+	port, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 12)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return uint16(port), nil
+}
+
+func isErrorClass(class string) bool {
+	if class == "other" {
+		return true
+	}
+	for _, c := range errorClasses {
+		if c.class == class {
+			return true
+		}
+	}
+	return false
+}
+
+func matchTrigger(triggers []*Trigger, ev Event, s *Stats) *Trigger {
+	for _, t := range triggers {
+		if t.Match(ev, s) {
+			return t
+		}
+	}
+	return nil
+}
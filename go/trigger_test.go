@@ -0,0 +1,95 @@
+package mvb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseTrigger(t *testing.T) {
+	crc := Error{error: errors.New("CRC mismatch"), n: 1}
+	s := NewStats()
+	for _, c := range []struct {
+		trigger string
+		ev      Event
+		want    bool
+	}{
+		{"fcode:15", &Telegram{Master: &MasterFrame{FCode: 15, Address: 0x001}}, true},
+		{"fcode:0xf", testTelegram(0, 0x001, "0001"), false},
+		{"error:crc", crc, true},
+		{"error:bit", crc, false},
+		{"missing", testTelegram(0, 0x014, ""), true},
+		{"missing:014", testTelegram(0, 0x014, ""), true},
+		{"missing:0x020", testTelegram(0, 0x014, ""), false},
+		{"missing", testTelegram(0, 0x014, "0001"), false},
+		{"value:014>=0x100", testTelegram(0, 0x014, "0100"), true},
+		{"value:014>0x100", testTelegram(0, 0x014, "0100"), false},
+		{"value:014:1:2 == 1", testTelegram(0, 0x014, "ff01"), true},
+		{"value:014:1:3==1", testTelegram(0, 0x014, "ff01"), false},
+		{"value:020!=0", testTelegram(0, 0x014, "0001"), false},
+		{"value:014<2", crc, false},
+		{"error(\"crc\") && rate() == 0", crc, true},
+		{"missing() && address() == 0x014", testTelegram(0, 0x014, ""), true},
+	} {
+		tr, err := ParseTrigger(c.trigger, nil)
+		if err != nil {
+			t.Errorf("%s: %v", c.trigger, err)
+			continue
+		}
+		if got := tr.Match(c.ev, &s); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.trigger, got, c.want)
+		}
+	}
+
+	for _, c := range []string{
+		"fcode:16", "error:foo", "missing:xyz", "value:014", "value:014=1",
+		"value:014:2:1==1", "value:014:0:9==1", "value:014==-1", "port:014", "error(crc)",
+	} {
+		if _, err := ParseTrigger(c, nil); err == nil {
+			t.Errorf("%s: no error", c)
+		}
+	}
+}
+
+func TestTriggeredCapture(t *testing.T) {
+	s := NewStats()
+	s.Ring = NewRing(0, 2)
+	start, _ := ParseTrigger("value:014>=3", nil)
+	stop, _ := ParseTrigger("error:crc", nil)
+	s.startTriggers = []*Trigger{start}
+	s.stopTriggers = []*Trigger{stop}
+	s.triggerPost = 100
+
+	for n := uint64(1); n <= 4; n++ {
+		s.CountTelegram(testTelegram(n*10, 0x014, "000"+string('0'+rune(n))))
+	}
+	// started with the last two telegrams, and the value before them
+	c := s.Capture
+	if c == nil || c.Stopped || c.Trigger != "value:014>=3 at 0.000s" {
+		t.Fatalf("got capture %+v", c)
+	}
+	s.CountError(Error{error: errors.New("CRC mismatch"), n: 50})
+	s.CountTelegram(testTelegram(149, 0x014, "0005"))
+	if c.Stopped {
+		t.Fatalf("stopped before the post-trigger time")
+	}
+	s.CountTelegram(testTelegram(150, 0x014, "0006"))
+	if !c.Stopped {
+		t.Fatalf("not stopped after the post-trigger time")
+	}
+	want := "20 014 0002\n30 014 0003\n40 014 0004\n149 014 0005\n150 014 0006\n" +
+		"014 20 0001\n014 20 0002\n014 30 0003\n014 40 0004\n014 149 0005\n014 150 0006\n"
+	if got := captureString(c); got != want {
+		t.Errorf("got\n%swant\n%s", got, want)
+	}
+
+	// not triggered again until the capture is discarded
+	s.CountTelegram(testTelegram(160, 0x014, "0007"))
+	if s.Capture != c || len(c.Telegrams) != 5 {
+		t.Errorf("triggered again")
+	}
+	s.DiscardCapture()
+	s.CountTelegram(testTelegram(170, 0x014, "0008"))
+	if s.Capture == nil || s.Capture.Stopped {
+		t.Errorf("not triggered again")
+	}
+}
@@ -0,0 +1,511 @@
+package mvb
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// maximum amount of capture telegrams included in each update; the full
+// capture is available at /capture
+const webCaptureTail = 200
+
+// WebDashboard serves the same information as Dashboard over HTTP: an HTML
+// page at / that receives periodic updates from /events, as server-sent
+// events.
+type WebDashboard struct {
+	addr         string
+	stats        Stats
+	n            func() uint64
+	watchedPorts []RecorderPortSpec
+	replay       *Replay
+	alarms       *Alarms
+
+	// result of the last command
+	message string
+
+	commands chan webCommand
+	// closed when Loop returns
+	stopped chan struct{}
+	// the stopped capture marshalled into capture, which doesn't change
+	// until it's replaced
+	captureOf *Capture
+
+	mu      sync.Mutex
+	clients map[chan []byte]struct{}
+	last    []byte
+	capture []byte
+}
+
+type webCommand struct {
+	action string
+	value  string
+	done   chan error
+}
+
+// SetCapture opens c in the capture view, as with -load.
+func (d *WebDashboard) SetCapture(c *Capture) {
+	d.stats.Capture = c
+}
+
+// SetReplay makes the dashboard show the values replayed by r, which can be
+// controlled with POST /replay, instead of decoded events.
+func (d *WebDashboard) SetReplay(r *Replay) {
+	d.replay = r
+}
+
+// SetAlarms shows the active and unacknowledged alarms of a, which can be
+// acknowledged with POST /alarms.
+func (d *WebDashboard) SetAlarms(a *Alarms) {
+	d.alarms = a
+}
+
+func NewWebDashboard(addr string, n func() uint64, watchedPorts []RecorderPortSpec) *WebDashboard {
+	d := &WebDashboard{
+		addr:         addr,
+		stats:        newCaptureStats(),
+		n:            n,
+		watchedPorts: watchedPorts,
+		commands:     make(chan webCommand),
+		stopped:      make(chan struct{}),
+		clients:      make(map[chan []byte]struct{}),
+	}
+	d.stats.History = NewVarHistory(watchedPorts, historyWindow)
+	return d
+}
+
+type webSnapshot struct {
+	Time      float64           `json:"time"`
+	Total     uint64            `json:"total"`
+	Rate      []uint64          `json:"rate"`
+	MRRates   []webRate         `json:"mrRates"`
+	ErrorRate []uint64          `json:"errorRate"`
+	Errors    []webError        `json:"errors"`
+	Ports     map[string]string `json:"ports"`
+	Watched   []webVar          `json:"watched"`
+	Capture   *webCapture       `json:"capture"`
+	Dropped   uint64            `json:"dropped"`
+	Overrun   bool              `json:"overrun"`
+	Replay    *webReplay        `json:"replay,omitempty"`
+	Message   string            `json:"message,omitempty"`
+	Highlight []string          `json:"highlight,omitempty"`
+	Alarms    []AlarmStatus     `json:"alarms,omitempty"`
+}
+
+type webReplay struct {
+	Time   string  `json:"time"`
+	Speed  float64 `json:"speed"`
+	Paused bool    `json:"paused"`
+	Status string  `json:"status"`
+}
+
+type webRate struct {
+	Request string   `json:"request"`
+	Rate    []uint64 `json:"rate"`
+}
+
+type webError struct {
+	Time    float64 `json:"time"`
+	Class   string  `json:"class"`
+	Message string  `json:"message"`
+	Trace   string  `json:"trace,omitempty"`
+}
+
+type webVar struct {
+	Port  string `json:"port"`
+	Range string `json:"range"`
+	Desc  string `json:"desc"`
+	Value string `json:"value"`
+	Spark string `json:"spark,omitempty"`
+}
+
+type webCapture struct {
+	Stopped   bool                   `json:"stopped"`
+	Trigger   string                 `json:"trigger,omitempty"`
+	Count     int                    `json:"count"`
+	Telegrams []string               `json:"telegrams"`
+	Vars      map[string][]webChange `json:"vars,omitempty"`
+}
+
+type webChange struct {
+	Time  float64 `json:"time"`
+	Value string  `json:"value"`
+}
+
+func (d *WebDashboard) snapshot() *webSnapshot {
+	s := &d.stats
+	snap := &webSnapshot{
+		Time:      sampleTimestamp(d.n()).Seconds(),
+		Total:     s.Total,
+		Rate:      s.Rate(),
+		ErrorRate: s.ErrorRate(),
+		Ports:     make(map[string]string),
+		Dropped:   s.Dropped,
+		Overrun:   s.Overrun(),
+		Message:   d.message,
+	}
+	for i := range s.mrRates {
+		snap.MRRates = append(snap.MRRates, webRate{
+			Request: MasterRequest(i).String(),
+			Rate:    s.MRRate(MasterRequest(i)),
+		})
+	}
+	for _, err := range s.ErrorLog {
+		e := webError{
+			Time:    sampleTimestamp(err.N()).Seconds(),
+			Class:   err.Class(),
+			Message: err.Error(),
+		}
+		if annotate {
+			e.Trace = traceSamples(err.samples)
+		}
+		snap.Errors = append(snap.Errors, e)
+	}
+	for port, v := range s.Vars {
+		snap.Ports[fmt.Sprintf("%03x", port)] = fmt.Sprintf("%x", v)
+	}
+	for port := range highlighted(s) {
+		snap.Highlight = append(snap.Highlight, fmt.Sprintf("%03x", port))
+	}
+	for i, w := range d.watchedPorts {
+		v := webVar{
+			Port:  fmt.Sprintf("%03x", w.Port),
+			Range: specRange(&w),
+			Desc:  w.Desc,
+			Value: fmt.Sprintf("%x", slice(s.Vars[w.Port], w.I, w.J)),
+		}
+		if h := s.History; h != nil {
+			v.Spark = h.Series[i].spark(d.n(), h.Series[i].window, sparkWidth, false)
+		}
+		snap.Watched = append(snap.Watched, v)
+	}
+	if d.alarms != nil {
+		snap.Alarms = d.alarms.List()
+	}
+	if r := d.replay; r != nil {
+		snap.Replay = &webReplay{
+			Time:   r.Time(),
+			Speed:  r.Speed(),
+			Paused: r.Paused(),
+			Status: r.Status(),
+		}
+	}
+	if c := s.Capture; c != nil {
+		tail := c.Telegrams
+		if len(tail) > webCaptureTail {
+			tail = tail[len(tail)-webCaptureTail:]
+		}
+		snap.Capture = webCaptureOf(c, tail, false)
+	}
+	return snap
+}
+
+func webCaptureOf(c *Capture, telegrams []*Telegram, withVars bool) *webCapture {
+	wc := &webCapture{
+		Stopped:   c.Stopped,
+		Trigger:   c.Trigger,
+		Count:     len(c.Telegrams),
+		Telegrams: make([]string, 0, len(telegrams)),
+	}
+	for _, t := range telegrams {
+		wc.Telegrams = append(wc.Telegrams, t.String())
+	}
+	if withVars {
+		wc.Vars = make(map[string][]webChange)
+		for _, port := range c.SeenPorts {
+			var changes []webChange
+			for _, change := range c.Vars[uint16(port)] {
+				changes = append(changes, webChange{
+					Time:  sampleTimestamp(change.N).Seconds(),
+					Value: fmt.Sprintf("%x", change.Value),
+				})
+			}
+			wc.Vars[fmt.Sprintf("%03x", port)] = changes
+		}
+	}
+	return wc
+}
+
+func (d *WebDashboard) publish() {
+	snap, err := json.Marshal(d.snapshot())
+	if err != nil {
+		panic(err)
+	}
+	var stopped *Capture
+	if c := d.stats.Capture; c != nil && c.Stopped {
+		stopped = c
+	}
+	capture := d.capture
+	if stopped != d.captureOf {
+		capture = nil
+		if stopped != nil {
+			capture, err = json.Marshal(webCaptureOf(stopped, stopped.Telegrams, true))
+			if err != nil {
+				panic(err)
+			}
+		}
+		d.captureOf = stopped
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.last = snap
+	d.capture = capture
+	for client := range d.clients {
+		// drop the previous update if the client did not consume it yet
+		select {
+		case <-client:
+		default:
+		}
+		client <- snap
+	}
+}
+
+func (d *WebDashboard) serve() {
+	err := http.ListenAndServe(d.addr, d.handler())
+	logEvent("http_error", "addr", d.addr, "err", err)
+}
+
+func (d *WebDashboard) handler() http.Handler {
+	static, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(static)))
+	mux.HandleFunc("/events", d.serveEvents)
+	mux.HandleFunc("/capture", d.serveCapture)
+	mux.HandleFunc("/replay", d.serveReplay)
+	mux.HandleFunc("/alarms", d.serveAlarms)
+	return mux
+}
+
+func (d *WebDashboard) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	client := make(chan []byte, 1)
+	d.mu.Lock()
+	d.clients[client] = struct{}{}
+	if d.last != nil {
+		client <- d.last
+	}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.clients, client)
+		d.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case snap := <-client:
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", snap); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// serveCapture returns the full stopped capture on GET, and starts, stops,
+// discards or saves the capture on POST, with action=start|stop|discard|save,
+// or replaces it with the last -capture-last, with action=last.
+func (d *WebDashboard) serveCapture(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		d.mu.Lock()
+		capture := d.capture
+		d.mu.Unlock()
+		if capture == nil {
+			http.Error(w, "no stopped capture", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(capture)
+	case http.MethodPost:
+		d.postCommand(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveReplay controls the replay on POST, with action=play|pause, or
+// action=speed|skip|seek and value set to the speed, a duration such as -10s,
+// or a time of day.
+func (d *WebDashboard) serveReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d.postCommand(w, r)
+}
+
+// serveAlarms acknowledges an alarm on POST, with action=ack and value set to
+// its name, or empty for all of them.
+func (d *WebDashboard) serveAlarms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d.postCommand(w, r)
+}
+
+func (d *WebDashboard) postCommand(w http.ResponseWriter, r *http.Request) {
+	cmd := webCommand{
+		action: r.FormValue("action"),
+		value:  r.FormValue("value"),
+		done:   make(chan error, 1),
+	}
+	select {
+	case d.commands <- cmd:
+	case <-d.stopped:
+		http.Error(w, "dashboard stopped", http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		return
+	}
+	// Loop answers every command it receives
+	if err := <-cmd.done; err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *WebDashboard) runCommand(cmd webCommand) error {
+	d.message = ""
+	running := d.stats.Capture != nil && !d.stats.Capture.Stopped
+	switch cmd.action {
+	case "start":
+		if running {
+			return fmt.Errorf("capture already running")
+		}
+		d.stats.StartStopCapture()
+	case "stop":
+		if !running {
+			return fmt.Errorf("no capture running")
+		}
+		d.stats.StartStopCapture()
+	case "discard":
+		d.stats.DiscardCapture()
+	case "last":
+		return d.stats.CaptureLast()
+	case "save":
+		path, err := d.stats.SaveCapture()
+		if err != nil {
+			return err
+		}
+		d.message = "saved to " + path
+	case "ack":
+		if d.alarms == nil {
+			return fmt.Errorf("no alarms")
+		}
+		n, err := d.alarms.Ack(cmd.value)
+		if err != nil {
+			return err
+		}
+		d.message = fmt.Sprintf("%d alarms acknowledged", n)
+	case "play", "pause", "speed", "skip", "seek":
+		if d.replay == nil {
+			return fmt.Errorf("not replaying")
+		}
+		return d.runReplayCommand(cmd)
+	default:
+		return fmt.Errorf("invalid action: %q", cmd.action)
+	}
+	return nil
+}
+
+func (d *WebDashboard) runReplayCommand(cmd webCommand) error {
+	r := d.replay
+	switch cmd.action {
+	case "play", "pause":
+		if r.Paused() == (cmd.action == "play") {
+			r.TogglePause(&d.stats)
+		}
+	case "speed":
+		speed, err := strconv.ParseFloat(cmd.value, 64)
+		if err != nil || speed <= 0 {
+			return fmt.Errorf("invalid speed: %q", cmd.value)
+		}
+		r.SetSpeed(speed)
+	case "skip":
+		skip, err := time.ParseDuration(cmd.value)
+		if err != nil {
+			return fmt.Errorf("invalid duration: %q", cmd.value)
+		}
+		r.Skip(skip, &d.stats)
+	case "seek":
+		return r.SeekTo(cmd.value, &d.stats)
+	}
+	return nil
+}
+
+func (d *WebDashboard) Loop(mvbEvents chan Event) {
+	defer close(d.stopped)
+	go d.serve()
+
+	publishTicker := time.Tick(500 * time.Millisecond)
+	secondsTicker := time.Tick(1 * time.Second)
+	dirty := true
+
+	var replayTicker <-chan time.Time
+	if d.replay != nil {
+		d.replay.Start(&d.stats)
+		replayTicker = time.Tick(100 * time.Millisecond)
+	}
+
+	for {
+		select {
+		case <-publishTicker:
+			if dirty {
+				d.publish()
+				dirty = false
+			}
+
+		case <-secondsTicker:
+			d.stats.Tick()
+			dirty = true
+
+		case now := <-replayTicker:
+			d.replay.Advance(now, &d.stats)
+			dirty = true
+
+		case cmd := <-d.commands:
+			// publish before answering, for the client to GET the new capture
+			err := d.runCommand(cmd)
+			d.publish()
+			cmd.done <- err
+
+		case ev, ok := <-mvbEvents:
+			if !ok {
+				return
+			}
+			switch ev := ev.(type) {
+			case *Telegram:
+				d.stats.CountTelegram(ev)
+			case Error:
+				d.stats.CountError(ev)
+			case *Overrun:
+				d.stats.CountOverrun(ev)
+			}
+			dirty = true
+		}
+	}
+}
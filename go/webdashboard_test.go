@@ -0,0 +1,106 @@
+package mvb
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWebDashboard(t *testing.T) {
+	d := NewWebDashboard("127.0.0.1:0", func() uint64 { return 0 }, []RecorderPortSpec{{0x014, 0, 2, "speed"}})
+	srv := httptest.NewServer(d.handler())
+	defer srv.Close()
+	events := make(chan Event)
+	done := make(chan bool)
+	go func() {
+		d.Loop(events)
+		done <- true
+	}()
+	events <- testTelegram(100, 0x014, "012c00")
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap webSnapshot
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &snap); err != nil {
+		t.Fatalf("%v: %q", err, line)
+	}
+	if snap.Total != 1 || snap.Ports["014"] != "012c00" || len(snap.Watched) != 1 || snap.Watched[0].Value != "012c" {
+		t.Errorf("got %+v", snap)
+	}
+
+	post := func(path, action string, want int) {
+		t.Helper()
+		resp, err := http.PostForm(srv.URL+path, url.Values{"action": {action}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s %s: got %d, want %d", path, action, resp.StatusCode, want)
+		}
+	}
+	post("/capture", "start", http.StatusNoContent)
+	post("/capture", "start", http.StatusBadRequest)
+	events <- testTelegram(200, 0x020, "01")
+	post("/capture", "stop", http.StatusNoContent)
+	post("/capture", "bogus", http.StatusBadRequest)
+	post("/replay", "play", http.StatusBadRequest)
+	post("/alarms", "ack", http.StatusBadRequest)
+
+	resp, err = http.Get(srv.URL + "/capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var capture webCapture
+	err = json.NewDecoder(resp.Body).Decode(&capture)
+	resp.Body.Close()
+	if err != nil || !capture.Stopped || capture.Count != 1 || capture.Vars["020"][0].Value != "01" {
+		t.Errorf("got %+v, %v", capture, err)
+	}
+
+	// the commands fail once the dashboard is stopped, instead of blocking
+	close(events)
+	<-done
+	post("/capture", "start", http.StatusServiceUnavailable)
+}
+
+func TestWebDashboardCaptureCache(t *testing.T) {
+	d := NewWebDashboard("", func() uint64 { return 0 }, nil)
+	d.stats.StartStopCapture()
+	d.stats.CountTelegram(testTelegram(100, 0x014, "0001"))
+	d.publish()
+	if d.capture != nil {
+		t.Errorf("running capture published as stopped")
+	}
+
+	// marshalled once, until the capture is replaced
+	d.stats.StartStopCapture()
+	d.publish()
+	first := d.capture
+	d.publish()
+	if len(first) == 0 || &d.capture[0] != &first[0] {
+		t.Errorf("stopped capture marshalled again")
+	}
+	d.stats.StartStopCapture()
+	d.stats.StartStopCapture()
+	d.publish()
+	if &d.capture[0] == &first[0] {
+		t.Errorf("new capture not marshalled")
+	}
+	d.stats.DiscardCapture()
+	d.publish()
+	if d.capture != nil {
+		t.Errorf("discarded capture still published")
+	}
+}